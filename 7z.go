@@ -1,9 +1,10 @@
 package xtractr
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/bodgit/sevenzip"
@@ -11,31 +12,33 @@ import (
 
 // Extract7z extracts a 7zip archive.
 // Volumes: https://github.com/bodgit/sevenzip/issues/54
+// The library does not say which volume failed, so ExtractFile checks them first. See ScanVolumes.
 func Extract7z(xFile *XFile) (int64, []string, []string, error) {
-	if len(xFile.Passwords) == 0 && xFile.Password == "" {
+	passwords := xFile.passwordList()
+	if len(passwords) == 1 {
 		return extract7z(xFile)
 	}
 
-	// Try all the passwords.
-	passwords := xFile.Passwords
+	// Try all the passwords, except the blank one at the end.
+	passwords = passwords[:len(passwords)-1]
 
-	if xFile.Password != "" { // If a single password is provided, try it first.
-		passwords = append([]string{xFile.Password}, xFile.Passwords...)
-	}
+	limit := xFile.limiter() // shared by every attempt.
 
 	for idx, password := range passwords {
-		size, files, archives, err := extract7z(&XFile{
-			FilePath:  xFile.FilePath,
-			OutputDir: xFile.OutputDir,
-			FileMode:  xFile.FileMode,
-			DirMode:   xFile.DirMode,
-			Password:  password,
-		})
-		if err != nil && idx == len(passwords)-1 {
+		attempt := *xFile
+		attempt.Password, attempt.Passwords = password, nil
+
+		counted := limit.save()
+
+		size, files, archives, err := extract7z(&attempt)
+		if err != nil && (idx == len(passwords)-1 || isStopError(err)) {
 			return size, files, archives, fmt.Errorf("used password %d of %d: %w", idx+1, len(passwords), err)
 		} else if err == nil {
+			xFile.passwordWorked(password)
 			return size, files, archives, nil
 		}
+
+		limit.restore(counted)
 	}
 
 	// unreachable code
@@ -43,19 +46,9 @@ func Extract7z(xFile *XFile) (int64, []string, []string, error) {
 }
 
 func extract7z(xFile *XFile) (int64, []string, []string, error) {
-	var (
-		sevenZip *sevenzip.ReadCloser
-		err      error
-	)
-
-	if xFile.Password != "" {
-		sevenZip, err = sevenzip.OpenReaderWithPassword(xFile.FilePath, xFile.Password)
-	} else {
-		sevenZip, err = sevenzip.OpenReader(xFile.FilePath)
-	}
-
+	sevenZip, err := open7z(xFile, xFile.Password)
 	if err != nil {
-		return 0, nil, nil, fmt.Errorf("%s: os.Open: %w", xFile.FilePath, err)
+		return 0, nil, nil, err
 	}
 
 	defer sevenZip.Close()
@@ -64,49 +57,155 @@ func extract7z(xFile *XFile) (int64, []string, []string, error) {
 	size := int64(0)
 
 	for _, zipFile := range sevenZip.File {
-		fSize, err := xFile.un7zip(zipFile)
+		wfile, fSize, err := xFile.un7zip(sevenZip, zipFile)
 		if err != nil {
-			lastFile := xFile.FilePath
-			/* // https://github.com/bodgit/sevenzip/issues/54
-			// We can probably never get the file with the error.
-			if volumes := sevenZip.Volumes(); len(volumes) > 0 {
-				lastFile = volumes[len(volumes)-1]
-			} */
-			return size, files, sevenZip.Volumes(), fmt.Errorf("%s: %w", lastFile, err)
+			return size, files, sevenZip.Volumes(), fmt.Errorf("%s: %w", xFile.FilePath, err)
 		}
 
-		files = append(files, filepath.Join(xFile.OutputDir, zipFile.Name))
-		size += fSize
+		if wfile != "" {
+			files = append(files, wfile)
+			size += fSize
+		}
 	}
 
 	return size, files, sevenZip.Volumes(), nil
 }
 
-func (x *XFile) un7zip(zipFile *sevenzip.File) (int64, error) { //nolint:dupl
-	wfile := x.clean(zipFile.Name)
-	if !strings.HasPrefix(wfile, x.OutputDir) {
-		// The file being written is trying to write outside of our base path. Malicious archive?
-		return 0, fmt.Errorf("%s: %w: %s (from: %s)", zipFile.FileInfo().Name(), ErrInvalidPath, wfile, zipFile.Name)
+// list7z lists the contents of a 7zip archive.
+func list7z(xFile *XFile) ([]Entry, error) {
+	sevenZip, err := open7z(xFile, xFile.Password)
+	if err != nil {
+		return nil, err
+	}
+	defer sevenZip.Close()
+
+	entries := make([]Entry, len(sevenZip.File))
+	for idx, zipFile := range sevenZip.File {
+		// 7zip compresses files in (solid) blocks, so the compressed size of each file is unknown.
+		entries[idx] = newEntry(zipFile.Name, int64(zipFile.UncompressedSize), -1, zipFile.Mode(), zipFile.Modified)
+	}
+
+	return entries, nil
+}
+
+// un7zip writes one file from a 7zip archive. Returns the path written, or an empty string if it was skipped.
+func (x *XFile) un7zip(sevenZip *sevenZipReader, zipFile *sevenzip.File) (string, int64, error) { //nolint:dupl
+	wfile, err := x.outputPath(zipFile.Name)
+	if err != nil || wfile == "" {
+		return "", 0, err
 	}
 
 	if strings.HasSuffix(wfile, "/") || zipFile.FileInfo().IsDir() {
 		if err := os.MkdirAll(wfile, x.DirMode); err != nil {
-			return 0, fmt.Errorf("making zipFile dir: %w", err)
+			return "", 0, fmt.Errorf("making zipFile dir: %w", err)
 		}
 
-		return 0, nil
+		return wfile, 0, nil
 	}
 
-	zFile, err := zipFile.Open()
+	zFile, err := sevenZip.open(zipFile)
 	if err != nil {
-		return 0, fmt.Errorf("zipFile.Open: %w", err)
+		return "", 0, fmt.Errorf("zipFile.Open: %w", err)
 	}
 	defer zFile.Close()
 
-	s, err := writeFile(wfile, zFile, x.FileMode, x.DirMode)
+	s, err := x.writeFile(wfile, zFile, x.FileMode, x.DirMode)
+	if err != nil {
+		return wfile, s, fmt.Errorf("%s: %w: %s (from: %s)", zipFile.FileInfo().Name(), err, wfile, zipFile.Name)
+	}
+
+	return wfile, s, nil
+}
+
+// sevenZipReader is a 7zip archive opened from its volumes, or from a self-extracting archive.
+type sevenZipReader struct {
+	*sevenzip.Reader
+	io.Closer
+	volumes []string
+	xFile   *XFile
+}
+
+// Volumes returns the volumes the archive was read from.
+func (s *sevenZipReader) Volumes() []string {
+	return s.volumes
+}
+
+// open opens a file in the archive. Errors reading it are classified by sevenZipError.
+func (s *sevenZipReader) open(zipFile *sevenzip.File) (io.ReadCloser, error) {
+	file, err := zipFile.Open()
+	if err != nil {
+		return nil, s.xFile.sevenZipError(err, s.volumes)
+	}
+
+	return &sevenZipFile{ReadCloser: file, archive: s}, nil
+}
+
+// sevenZipFile is a file in a 7zip archive, opened for reading.
+type sevenZipFile struct {
+	io.ReadCloser
+	archive *sevenZipReader
+}
+
+func (f *sevenZipFile) Read(data []byte) (int, error) {
+	size, err := f.ReadCloser.Read(data)
+	if err != nil && !errors.Is(err, io.EOF) {
+		err = f.archive.xFile.sevenZipError(err, f.archive.volumes)
+	}
+
+	return size, err //nolint:wrapcheck
+}
+
+// open7z opens a 7zip archive with a password, or none if it's blank.
+func open7z(xFile *XFile, password string) (*sevenZipReader, error) {
+	if xFile.sfxOffset != 0 {
+		file, section, err := xFile.openSFX()
+		if err != nil {
+			return nil, err
+		}
+
+		reader, err := new7zReader(section, section.Size(), password)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", xFile.FilePath, xFile.sevenZipError(err, nil))
+		}
+
+		return &sevenZipReader{Reader: reader, Closer: file, volumes: []string{xFile.FilePath}, xFile: xFile}, nil
+	}
+
+	var (
+		sevenZip *sevenzip.ReadCloser
+		err      error
+	)
+
+	if password != "" {
+		sevenZip, err = sevenzip.OpenReaderWithPassword(xFile.FilePath, password)
+	} else {
+		sevenZip, err = sevenzip.OpenReader(xFile.FilePath)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("%s: os.Open: %w", xFile.FilePath, xFile.sevenZipError(err, nil))
+	}
+
+	return &sevenZipReader{Reader: &sevenZip.Reader, Closer: sevenZip, volumes: sevenZip.Volumes(), xFile: xFile}, nil
+}
+
+// new7zReader reads a 7zip archive from reader with a password, or none if it's blank.
+func new7zReader(reader io.ReaderAt, size int64, password string) (*sevenzip.Reader, error) {
+	var (
+		sevenZip *sevenzip.Reader
+		err      error
+	)
+
+	if password != "" {
+		sevenZip, err = sevenzip.NewReaderWithPassword(reader, size, password)
+	} else {
+		sevenZip, err = sevenzip.NewReader(reader, size)
+	}
+
 	if err != nil {
-		return s, fmt.Errorf("%s: %w: %s (from: %s)", zipFile.FileInfo().Name(), err, wfile, zipFile.Name)
+		return nil, fmt.Errorf("sevenzip.NewReader: %w", err)
 	}
 
-	return s, nil
+	return sevenZip, nil
 }
@@ -0,0 +1,441 @@
+package xtractr
+
+/* Read enough of a 7zip archive's header to tell if it's encrypted. The sevenzip library does not export its errors. */
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	sevenZipStartHeader = 32       // signature, version, CRC, next header offset, size and CRC.
+	sevenZipMaxHeader   = 64 << 20 // bigger headers are not read.
+	// Property IDs in a 7zip header.
+	sevenZipEnd           = 0x00
+	sevenZipHeader        = 0x01
+	sevenZipPackInfo      = 0x06
+	sevenZipUnpackInfo    = 0x07
+	sevenZipSize          = 0x09
+	sevenZipCRC           = 0x0a
+	sevenZipFolder        = 0x0b
+	sevenZipUnpackSize    = 0x0c
+	sevenZipEncodedHeader = 0x17
+)
+
+//nolint:gochecknoglobals
+var (
+	// sevenZipAES is the ID of the AES-256 coder. It has properties, so its flag byte is 0x24.
+	sevenZipAES = []byte{0x06, 0xf1, 0x07, 0x01}
+	// sevenZipLZMA is the ID of the coder 7-Zip compresses headers with.
+	sevenZipLZMA = []byte{0x03, 0x01, 0x01}
+)
+
+// errSevenZipHeader is returned when a header can not be read. It never leaves this file.
+var errSevenZipHeader = errors.New("bad 7zip header") //nolint:gochecknoglobals
+
+// sevenZipError returns the kind of problem an error from the sevenzip library represents.
+// Errors reading the file, and errors with a kind, are returned as they are. Any other error
+// is from bad data: the archive is ErrEncrypted if it uses the AES coder, or ErrWrongPassword
+// if a password was used, and ErrCorruptArchive if it does not.
+func (x *XFile) sevenZipError(err error, volumes []string) error {
+	var pathErr *fs.PathError
+
+	switch {
+	case err == nil, isStopError(err), errorKind(err) != nil, errors.As(err, &pathErr):
+		return err
+	case !x.sevenZipEncrypted(volumes):
+		return &ArchiveError{Kind: ErrCorruptArchive, Err: err}
+	case x.Password != "":
+		return &ArchiveError{Kind: ErrWrongPassword, Err: err}
+	default:
+		return &ArchiveError{Kind: ErrEncrypted, Err: err}
+	}
+}
+
+// sevenZipEncrypted returns true if the archive in volumes uses the AES coder.
+func (x *XFile) sevenZipEncrypted(volumes []string) bool {
+	if x.sfxOffset != 0 {
+		file, section, err := x.openSFX()
+		if err != nil {
+			return false
+		}
+		defer file.Close()
+
+		return sevenZipEncrypted(section)
+	}
+
+	if len(volumes) == 0 {
+		volumes = sevenZipVolumes(x.FilePath)
+	}
+
+	parts, sizes := make([]io.ReaderAt, len(volumes)), make([]int64, len(volumes))
+
+	for idx, volume := range volumes {
+		file, err := os.Open(volume)
+		if err != nil {
+			return false
+		}
+		defer file.Close()
+
+		if sizes[idx], err = fileSize(file); err != nil {
+			return false
+		}
+
+		parts[idx] = file
+	}
+
+	joined := newMultiReaderAt(parts, sizes)
+
+	return sevenZipEncrypted(io.NewSectionReader(joined, 0, joined.Size()))
+}
+
+// sevenZipVolumes returns the volumes of a split archive (.7z.001, .7z.002, …) that exist.
+func sevenZipVolumes(path string) []string {
+	volumes := []string{path}
+	if !strings.HasSuffix(path, ".001") {
+		return volumes
+	}
+
+	for idx := 2; ; idx++ {
+		volume := fmt.Sprintf("%s.%03d", strings.TrimSuffix(path, ".001"), idx)
+		if _, err := os.Stat(volume); err != nil {
+			return volumes
+		}
+
+		volumes = append(volumes, volume)
+	}
+}
+
+// sevenZipEncrypted returns true if a 7zip archive's header lists the AES coder. An encoded
+// header is checked for AES (encrypted file names), then decompressed if it's LZMA compressed.
+func sevenZipEncrypted(archive *io.SectionReader) bool {
+	start := make([]byte, sevenZipStartHeader)
+	if _, err := archive.ReadAt(start, 0); err != nil {
+		return false
+	}
+
+	offset, size := binary.LittleEndian.Uint64(start[12:]), binary.LittleEndian.Uint64(start[20:])
+	if size == 0 || size > sevenZipMaxHeader || offset > uint64(archive.Size()) {
+		return false
+	}
+
+	header := make([]byte, size)
+	if _, err := archive.ReadAt(header, sevenZipStartHeader+int64(offset)); err != nil {
+		return false
+	}
+
+	if header[0] == sevenZipHeader {
+		return bytes.Contains(header, append([]byte{0x24}, sevenZipAES...))
+	}
+
+	if header[0] != sevenZipEncodedHeader {
+		return false
+	}
+
+	encoded := &sevenZipBytes{data: header[1:]}
+
+	info, err := encoded.streamsInfo()
+	if err != nil {
+		return false
+	}
+
+	for _, coder := range info.coders {
+		if bytes.Equal(coder.id, sevenZipAES) {
+			return true
+		}
+	}
+
+	if len(info.coders) != 1 || !bytes.Equal(info.coders[0].id, sevenZipLZMA) || info.unpackSize > sevenZipMaxHeader {
+		return false
+	}
+
+	header, err = info.decodeLZMA(archive)
+	if err != nil {
+		return false
+	}
+
+	return header[0] == sevenZipHeader && bytes.Contains(header, append([]byte{0x24}, sevenZipAES...))
+}
+
+// sevenZipBytes reads the numbers and bytes in a 7zip header.
+type sevenZipBytes struct {
+	data []byte
+	pos  int
+}
+
+// sevenZipStreams is the part of an encoded header's streams info that's needed to decode it.
+type sevenZipStreams struct {
+	packPos    uint64
+	packSize   uint64
+	unpackSize uint64
+	coders     []sevenZipCoder
+}
+
+type sevenZipCoder struct {
+	id    []byte
+	props []byte
+}
+
+func (r *sevenZipBytes) byte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, errSevenZipHeader
+	}
+
+	r.pos++
+
+	return r.data[r.pos-1], nil
+}
+
+func (r *sevenZipBytes) bytes(count uint64) ([]byte, error) {
+	if count > uint64(len(r.data)-r.pos) {
+		return nil, errSevenZipHeader
+	}
+
+	r.pos += int(count)
+
+	return r.data[r.pos-int(count) : r.pos], nil
+}
+
+// number reads a 7zip number. The high bits of the first byte say how many bytes follow.
+func (r *sevenZipBytes) number() (uint64, error) {
+	first, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+
+	value, mask := uint64(0), byte(0x80)
+
+	for idx := 0; idx < 8; idx++ {
+		if first&mask == 0 {
+			return value | uint64(first&(mask-1))<<(8*idx), nil
+		}
+
+		next, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		value |= uint64(next) << (8 * idx)
+		mask >>= 1
+	}
+
+	return value, nil
+}
+
+// skipDigests skips the CRCs of count streams.
+func (r *sevenZipBytes) skipDigests(count uint64) error {
+	allDefined, err := r.byte()
+	if err != nil {
+		return err
+	}
+
+	defined := count
+	if allDefined == 0 {
+		bits, err := r.bytes((count + 7) / 8) //nolint:gomnd
+		if err != nil {
+			return err
+		}
+
+		defined = 0
+		for idx := uint64(0); idx < count; idx++ {
+			if bits[idx/8]&(0x80>>(idx%8)) != 0 {
+				defined++
+			}
+		}
+	}
+
+	_, err = r.bytes(4 * defined) //nolint:gomnd
+
+	return err
+}
+
+// streamsInfo reads the pack info and the first folder of an encoded header.
+func (r *sevenZipBytes) streamsInfo() (*sevenZipStreams, error) {
+	info := &sevenZipStreams{}
+
+	if id, err := r.byte(); err != nil || id != sevenZipPackInfo {
+		return nil, errSevenZipHeader
+	}
+
+	var err error
+	if info.packPos, err = r.number(); err != nil {
+		return nil, err
+	}
+
+	packs, err := r.number()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		} else if id == sevenZipEnd {
+			break
+		}
+
+		switch id {
+		case sevenZipSize:
+			for idx := uint64(0); idx < packs; idx++ {
+				size, err := r.number()
+				if err != nil {
+					return nil, err
+				}
+
+				if idx == 0 {
+					info.packSize = size
+				}
+			}
+		case sevenZipCRC:
+			if err := r.skipDigests(packs); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errSevenZipHeader
+		}
+	}
+
+	if id, err := r.byte(); err != nil || id != sevenZipUnpackInfo {
+		return nil, errSevenZipHeader
+	}
+
+	if id, err := r.byte(); err != nil || id != sevenZipFolder {
+		return nil, errSevenZipHeader
+	}
+
+	// Only the first folder matters; the encoded header has one.
+	if folders, err := r.number(); err != nil || folders == 0 {
+		return nil, errSevenZipHeader
+	}
+
+	if external, err := r.byte(); err != nil || external != 0 {
+		return nil, errSevenZipHeader
+	}
+
+	outputs, err := info.readCoders(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return info, info.readUnpackSize(r, outputs)
+}
+
+// readCoders reads the coders of a folder, and skips its bind pairs and packed streams.
+// Returns the number of output streams, which each have an unpacked size.
+func (s *sevenZipStreams) readCoders(r *sevenZipBytes) (uint64, error) {
+	count, err := r.number()
+	if err != nil || count > 64 { //nolint:gomnd
+		return 0, errSevenZipHeader
+	}
+
+	inputs, outputs := uint64(0), uint64(0)
+
+	for idx := uint64(0); idx < count; idx++ {
+		flag, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+
+		coder := sevenZipCoder{}
+		if coder.id, err = r.bytes(uint64(flag & 0x0f)); err != nil {
+			return 0, err
+		}
+
+		coderIn, coderOut := uint64(1), uint64(1)
+		if flag&0x10 != 0 {
+			if coderIn, err = r.number(); err != nil {
+				return 0, err
+			}
+
+			if coderOut, err = r.number(); err != nil {
+				return 0, err
+			}
+		}
+
+		if flag&0x20 != 0 {
+			size, err := r.number()
+			if err != nil {
+				return 0, err
+			}
+
+			if coder.props, err = r.bytes(size); err != nil {
+				return 0, err
+			}
+		}
+
+		inputs, outputs = inputs+coderIn, outputs+coderOut
+		s.coders = append(s.coders, coder)
+	}
+
+	if outputs == 0 || inputs+1 < outputs {
+		return 0, errSevenZipHeader
+	}
+
+	// Bind pairs, then the packed streams if there is more than one.
+	skip := 2 * (outputs - 1) //nolint:gomnd
+	if packed := inputs - (outputs - 1); packed > 1 {
+		skip += packed
+	}
+
+	for idx := uint64(0); idx < skip; idx++ {
+		if _, err := r.number(); err != nil {
+			return 0, err
+		}
+	}
+
+	return outputs, nil
+}
+
+// readUnpackSize reads the unpacked sizes of the folder. The last one is the folder's size.
+func (s *sevenZipStreams) readUnpackSize(r *sevenZipBytes, outputs uint64) error {
+	if id, err := r.byte(); err != nil || id != sevenZipUnpackSize {
+		return errSevenZipHeader
+	}
+
+	for idx := uint64(0); idx < outputs; idx++ {
+		size, err := r.number()
+		if err != nil {
+			return err
+		}
+
+		s.unpackSize = size
+	}
+
+	return nil
+}
+
+// decodeLZMA decompresses an LZMA encoded header from the archive.
+func (s *sevenZipStreams) decodeLZMA(archive *io.SectionReader) ([]byte, error) {
+	props := s.coders[0].props
+	if len(props) != 5 { //nolint:gomnd
+		return nil, errSevenZipHeader
+	}
+
+	// The classic LZMA header is the properties, then the uncompressed size.
+	header := make([]byte, 13) //nolint:gomnd
+	copy(header, props)
+	binary.LittleEndian.PutUint64(header[5:], s.unpackSize)
+
+	packed := io.NewSectionReader(archive, sevenZipStartHeader+int64(s.packPos), int64(s.packSize))
+
+	reader, err := lzma.NewReader(io.MultiReader(bytes.NewReader(header), packed))
+	if err != nil {
+		return nil, fmt.Errorf("lzma.NewReader: %w", err)
+	}
+
+	decoded := make([]byte, s.unpackSize)
+	if _, err := io.ReadFull(reader, decoded); err != nil || len(decoded) == 0 {
+		return nil, errSevenZipHeader
+	}
+
+	return decoded, nil
+}
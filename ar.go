@@ -0,0 +1,392 @@
+package xtractr
+
+/* Code to extract Unix ar archives, and the Debian packages (.deb) built from them. */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// arMagic starts every ar archive.
+	arMagic = "!<arch>\n"
+	// arHeaderSize is the size of the header in front of each member.
+	arHeaderSize = 60
+	// debControlDir is where ExpandDeb puts a package's control files, like dpkg-deb --raw-extract.
+	debControlDir = "DEBIAN"
+)
+
+// arReader reads the members of an ar archive from a stream, like tar.Reader.
+type arReader struct {
+	stream io.Reader
+	// names is the GNU long file name table, from the // member.
+	names []byte
+	// remaining is the unread data in the current member, and pad is its padding byte.
+	remaining int64
+	pad       int64
+}
+
+// arHeader describes a member of an ar archive.
+type arHeader struct {
+	Name    string
+	ModTime time.Time
+	Mode    os.FileMode
+	Size    int64
+}
+
+// ExtractAr extracts a Unix ar archive, like a Debian package (.deb). Set ExpandDeb to also
+// extract the control and data tarballs inside a package, instead of writing them as files.
+func ExtractAr(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatAr)
+}
+
+// isArHeader returns true if the header begins with the ar magic.
+func isArHeader(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(arMagic))
+}
+
+// newArReader reads the ar magic from a stream, and returns a reader for its members.
+func newArReader(stream io.Reader) (*arReader, error) {
+	magic := make([]byte, len(arMagic))
+	if _, err := io.ReadFull(stream, magic); err != nil || string(magic) != arMagic {
+		return nil, fmt.Errorf("%w: missing ar magic", ErrInvalidHead)
+	}
+
+	return &arReader{stream: stream}, nil
+}
+
+// Next skips the rest of the current member, and returns the header for the next one.
+// Symbol tables and the long file name table are read or skipped, and never returned.
+func (a *arReader) Next() (*arHeader, error) {
+	for {
+		if _, err := io.CopyN(io.Discard, a.stream, a.remaining+a.pad); err != nil {
+			return nil, fmt.Errorf("reading ar member: %w", noEOF(err))
+		}
+
+		block := make([]byte, arHeaderSize)
+		if _, err := io.ReadFull(a.stream, block); errors.Is(err, io.EOF) {
+			return nil, io.EOF
+		} else if err != nil {
+			return nil, fmt.Errorf("reading ar header: %w", err)
+		}
+
+		header, err := a.parseHeader(block)
+		if err != nil {
+			return nil, err
+		}
+
+		switch name := header.Name; {
+		case name == "//": // GNU long file name table.
+			a.names = make([]byte, header.Size)
+			if _, err := io.ReadFull(a, a.names); err != nil {
+				return nil, fmt.Errorf("reading ar name table: %w", noEOF(err))
+			}
+		case name == "/", name == "/SYM64/", name == "__.SYMDEF", name == "__.SYMDEF SORTED":
+			continue // symbol tables.
+		default:
+			return header, nil
+		}
+	}
+}
+
+// parseHeader decodes a member header, and sets up the reader for the member's data.
+func (a *arReader) parseHeader(block []byte) (*arHeader, error) {
+	if string(block[58:60]) != "`\n" {
+		return nil, fmt.Errorf("%w: bad ar header trailer", ErrInvalidHead)
+	}
+
+	field := func(start, end int) string { return strings.TrimSpace(string(block[start:end])) }
+	header := &arHeader{Name: field(0, 16)}
+
+	size, err := strconv.ParseInt(field(48, 58), 10, 64) //nolint:gomnd
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w: bad ar member size: %q", ErrInvalidHead, field(48, 58))
+	}
+
+	if mtime, err := strconv.ParseInt(field(16, 28), 10, 64); err == nil { //nolint:gomnd
+		header.ModTime = time.Unix(mtime, 0)
+	}
+
+	if mode, err := strconv.ParseUint(field(40, 48), 8, 32); err == nil { //nolint:gomnd
+		header.Mode = os.FileMode(mode) & os.ModePerm
+	}
+
+	a.remaining, a.pad = size, size%2 // members are padded to an even size.
+
+	switch name := header.Name; {
+	case strings.HasPrefix(name, "#1/"): // BSD: the name is in front of the data.
+		length, err := strconv.ParseInt(name[3:], 10, 64)
+		if err != nil || length < 0 || length > size {
+			return nil, fmt.Errorf("%w: bad ar name length: %q", ErrInvalidHead, name)
+		}
+
+		buf := make([]byte, length)
+		if _, err := io.ReadFull(a, buf); err != nil {
+			return nil, fmt.Errorf("reading ar name: %w", noEOF(err))
+		}
+
+		header.Name = string(bytes.TrimRight(buf, "\x00"))
+	case len(name) > 1 && name[0] == '/' && name[1] >= '0' && name[1] <= '9': // GNU: offset in the name table.
+		offset, err := strconv.Atoi(name[1:])
+		if err != nil || offset >= len(a.names) {
+			return nil, fmt.Errorf("%w: bad ar name offset: %q", ErrInvalidHead, name)
+		}
+
+		end := bytes.Index(a.names[offset:], []byte("/\n"))
+		if end < 0 {
+			end = len(a.names) - offset
+		}
+
+		header.Name = string(a.names[offset : offset+end])
+	case name != "/" && name != "//":
+		header.Name = strings.TrimSuffix(name, "/") // GNU ends names with a slash.
+	}
+
+	header.Size = a.remaining
+
+	return header, nil
+}
+
+// Read reads the data in the current member.
+func (a *arReader) Read(data []byte) (int, error) {
+	if a.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(data)) > a.remaining {
+		data = data[:a.remaining]
+	}
+
+	size, err := a.stream.Read(data)
+	a.remaining -= int64(size)
+
+	if errors.Is(err, io.EOF) && a.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return size, err //nolint:wrapcheck
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, for reads that must finish.
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+// debMember returns the folder a member of a Debian package is expanded into, relative
+// to the output folder, and true if the member is a tarball to extract.
+func debMember(name string) (string, bool) {
+	switch {
+	case strings.HasPrefix(name, "data.tar"):
+		return "", true
+	case strings.HasPrefix(name, "control.tar"):
+		return debControlDir, true
+	default:
+		return debControlDir, false
+	}
+}
+
+// isDeb returns true once the first member of an archive shows it's a Debian package.
+func isDeb(deb, first bool, header *arHeader) bool {
+	return deb || first && header.Name == "debian-binary"
+}
+
+func (x *XFile) unar(stream io.Reader) (int64, []string, error) {
+	reader, err := newArReader(stream)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", x.FilePath, err)
+	}
+
+	files := []string{}
+	size := int64(0)
+	deb := false
+
+	for first := true; ; first = false {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return size, files, nil
+		} else if err != nil {
+			return size, files, fmt.Errorf("%s: %w", x.FilePath, err)
+		}
+
+		deb = x.ExpandDeb && isDeb(deb, first, header)
+		fSize, written, err := x.unarMember(header, reader, deb)
+		files = append(files, written...)
+		size += fSize
+
+		if err != nil {
+			return size, files, err
+		}
+	}
+}
+
+// unarMember writes one member of an ar archive. With deb true, the member is put where
+// dpkg-deb --raw-extract puts it, and the control and data tarballs are extracted.
+func (x *XFile) unarMember(header *arHeader, member io.Reader, deb bool) (int64, []string, error) {
+	if !deb {
+		return x.writeArMember(header.Name, header.Mode, member)
+	}
+
+	dir, tarball := debMember(header.Name)
+	sub := *x
+	sub.OutputDir = filepath.Join(x.OutputDir, dir)
+
+	if !tarball {
+		return sub.writeArMember(header.Name, header.Mode, member)
+	}
+
+	inner, err := peel(member, header.Name, nil)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %s: %w", x.FilePath, header.Name, err)
+	}
+	defer inner.Close()
+
+	if inner.container != nil {
+		return inner.container.extract(&sub, inner)
+	}
+
+	return sub.writeArMember(inner.name, header.Mode, inner)
+}
+
+// writeArMember writes one member of an ar archive into the output folder.
+func (x *XFile) writeArMember(name string, mode os.FileMode, member io.Reader) (int64, []string, error) {
+	wfile, err := x.outputPath(name)
+	if err != nil {
+		return 0, nil, fmt.Errorf("%s: %w", x.FilePath, err)
+	} else if wfile == "" {
+		return 0, nil, nil
+	}
+
+	if mode == 0 {
+		mode = x.FileMode
+	}
+
+	size, err := x.writeFile(wfile, member, mode, x.DirMode)
+	if err != nil {
+		return size, nil, err
+	}
+
+	return size, []string{wfile}, nil
+}
+
+// listAr lists the members of an ar archive. With ExpandDeb, the files
+// in a Debian package's tarballs are listed instead of the tarballs.
+func (x *XFile) listAr(stream io.Reader) ([]Entry, error) {
+	reader, err := newArReader(stream)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", x.FilePath, err)
+	}
+
+	entries := []Entry{}
+	deb := false
+
+	for first := true; ; first = false {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return entries, nil
+		} else if err != nil {
+			return entries, fmt.Errorf("%s: %w", x.FilePath, err)
+		}
+
+		entry := newEntry(header.Name, header.Size, header.Size, header.Mode, header.ModTime)
+		if deb = x.ExpandDeb && isDeb(deb, first, header); !deb {
+			entries = append(entries, entry)
+			continue
+		}
+
+		dir, tarball := debMember(header.Name)
+		if !tarball {
+			entry.Name = path.Join(dir, header.Name)
+			entries = append(entries, entry)
+
+			continue
+		}
+
+		inner, err := x.listDebTarball(dir, header, reader)
+		entries = append(entries, inner...)
+
+		if err != nil {
+			return entries, err
+		}
+	}
+}
+
+// listDebTarball lists the files in a tarball inside a Debian package.
+func (x *XFile) listDebTarball(dir string, header *arHeader, member io.Reader) ([]Entry, error) {
+	inner, err := peel(member, header.Name, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", x.FilePath, header.Name, err)
+	}
+	defer inner.Close()
+
+	if inner.container == nil {
+		return []Entry{newEntry(path.Join(dir, inner.name), -1, header.Size, header.Mode, header.ModTime)}, nil
+	}
+
+	entries, err := inner.container.list(x, inner)
+	for idx := range entries {
+		entries[idx].Name = path.Join(dir, entries[idx].Name)
+	}
+
+	return entries, err
+}
+
+// testAr reads every member of an ar archive. ar has no checksums, but the
+// tarballs in a Debian package are tested, so their compression is verified.
+func (x *XFile) testAr(stream io.Reader) (int64, []string, error) {
+	reader, err := newArReader(stream)
+	if err != nil {
+		return 0, []string{x.FilePath}, fmt.Errorf("%s: %w", x.FilePath, err)
+	}
+
+	failed := &IntegrityError{Archive: x.FilePath}
+	size := int64(0)
+	deb := false
+
+	for first := true; ; first = false {
+		header, err := reader.Next()
+		if errors.Is(err, io.EOF) {
+			return size, []string{x.FilePath}, failed.err()
+		} else if err != nil {
+			return size, []string{x.FilePath}, fmt.Errorf("%s: %w", x.FilePath, err)
+		}
+
+		if deb = isDeb(deb, first, header); !deb {
+			size += failed.discard(header.Name, reader)
+			continue
+		}
+
+		if _, tarball := debMember(header.Name); !tarball {
+			size += failed.discard(header.Name, reader)
+			continue
+		}
+
+		inner, err := peel(reader, header.Name, nil)
+		if err != nil {
+			failed.add(header.Name, err)
+			continue
+		}
+
+		if inner.container != nil {
+			innerSize, _, err := inner.container.test(x, inner)
+			if size += innerSize; err != nil {
+				failed.add(header.Name, err)
+			}
+		} else {
+			size += failed.discard(header.Name, inner)
+		}
+
+		inner.Close()
+	}
+}
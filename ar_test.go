@@ -0,0 +1,152 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+func TestExtractDeb(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	deb := filepath.Join(dir, "hello_1.0_amd64.deb")
+	assert.NoError(t, makeDeb(t, deb))
+
+	format, err := xtractr.DetectFormat(deb)
+	assert.NoError(t, err)
+	assert.Equal(t, xtractr.FormatAr, format)
+
+	// Without ExpandDeb, the members are written as they are.
+	_, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: deb, OutputDir: filepath.Join(dir, "raw")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "raw", "debian-binary"),
+		filepath.Join(dir, "raw", "control.tar.gz"),
+		filepath.Join(dir, "raw", "data.tar.xz"),
+		filepath.Join(dir, "raw", "a_long_member_name_for_gnu.txt"),
+	}, files)
+
+	// With ExpandDeb, the tarballs are extracted like dpkg-deb --raw-extract.
+	size, files, _, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:  deb,
+		OutputDir: filepath.Join(dir, "expanded"),
+		ExpandDeb: true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("2.0\n")+len("Package: hello\n")+len("#!/bin/sh\n")+len("long")), size)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "expanded", "DEBIAN", "debian-binary"),
+		filepath.Join(dir, "expanded", "DEBIAN", "control"),
+		filepath.Join(dir, "expanded", "usr", "bin", "hello"),
+		filepath.Join(dir, "expanded", "DEBIAN", "a_long_member_name_for_gnu.txt"),
+	}, files)
+
+	data, err := os.ReadFile(filepath.Join(dir, "expanded", "usr", "bin", "hello"))
+	assert.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\n", string(data))
+
+	entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: deb, ExpandDeb: true})
+	assert.NoError(t, err)
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name)
+	}
+
+	assert.ElementsMatch(t, []string{
+		"DEBIAN/debian-binary", "DEBIAN/control", "usr/bin/hello", "DEBIAN/a_long_member_name_for_gnu.txt",
+	}, names)
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: deb})
+	assert.NoError(t, err)
+}
+
+func TestQueueDeb(t *testing.T) {
+	t.Parallel()
+
+	queue := xtractr.NewQueue(&xtractr.Config{Logger: &testLogger{t: t}})
+	defer queue.Stop()
+
+	dir := t.TempDir()
+	assert.NoError(t, makeDeb(t, filepath.Join(dir, "hello.deb")))
+
+	// Without ExpandDeb, recursion extracts the tarballs written from the package.
+	ext := &xtractr.Xtract{Filter: xtractr.Filter{Path: dir}, ExtractTo: filepath.Join(dir, "out"), TempFolder: true}
+	ext.CBChannel = make(chan *xtractr.Response, 2)
+
+	_, err := queue.Extract(ext)
+	assert.NoError(t, err)
+
+	for resp := range ext.CBChannel {
+		if resp.Done {
+			assert.NoError(t, resp.Error)
+			assert.FileExists(t, filepath.Join(resp.Output, "control"))
+			assert.FileExists(t, filepath.Join(resp.Output, "usr", "bin", "hello"))
+
+			break
+		}
+	}
+}
+
+// makeDeb writes a small Debian package, with a member name long enough to need the GNU name table.
+func makeDeb(t *testing.T, fileName string) error {
+	t.Helper()
+
+	control := &bytes.Buffer{}
+	assert.NoError(t, writeCompressed(control, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		makeTar(t, map[string][]byte{"./control": []byte("Package: hello\n")})))
+
+	data := &bytes.Buffer{}
+	assert.NoError(t, writeCompressed(data, func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+		makeTar(t, map[string][]byte{"./usr/bin/hello": []byte("#!/bin/sh\n")})))
+
+	return makeArFile(fileName, []string{"debian-binary", "control.tar.gz", "data.tar.xz", "a_long_member_name_for_gnu.txt"},
+		[][]byte{[]byte("2.0\n"), control.Bytes(), data.Bytes(), []byte("long")})
+}
+
+// makeArFile writes a GNU ar archive.
+func makeArFile(fileName string, names []string, members [][]byte) error {
+	buf := bytes.NewBufferString("!<arch>\n")
+	header := func(name string, size int) {
+		fmt.Fprintf(buf, "%-16s%-12d%-6d%-6d%-8o%-10d`\n", name, 1700000000, 0, 0, 0o644, size)
+	}
+
+	table := ""
+	for _, name := range names {
+		if len(name) > 15 { //nolint:gomnd
+			table += name + "/\n"
+		}
+	}
+
+	if table != "" {
+		header("//", len(table))
+		buf.WriteString(table)
+	}
+
+	for idx, name := range names {
+		if len(name) > 15 { //nolint:gomnd
+			name = fmt.Sprintf("/%d", strings.Index(table, name+"/\n"))
+		} else {
+			name += "/"
+		}
+
+		header(name, len(members[idx]))
+		buf.Write(members[idx])
+
+		if len(members[idx])%2 == 1 {
+			buf.WriteByte('\n')
+		}
+	}
+
+	return os.WriteFile(fileName, buf.Bytes(), 0o600) //nolint:wrapcheck
+}
@@ -0,0 +1,658 @@
+package xtractr
+
+/* Code to extract Microsoft Cabinet (.cab) files, including sets that span several cabinets. */
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// cabMagic starts every cabinet. The 4 reserved bytes after it are zero.
+	cabMagic      = "MSCF\x00\x00\x00\x00"
+	cabHeaderSize = 36
+	// Header flags.
+	cabFlagPrev    = 0x0001
+	cabFlagNext    = 0x0002
+	cabFlagReserve = 0x0004
+	// Compression types, in the low bits of a folder's typeCompress.
+	cabCompressMask    = 0x000f
+	cabCompressNone    = 0
+	cabCompressMSZIP   = 1
+	cabCompressQuantum = 2
+	cabCompressLZX     = 3
+	// Folder indexes for files that span cabinets.
+	cabContinuedFromPrev    = 0xfffd
+	cabContinuedToNext      = 0xfffe
+	cabContinuedPrevAndNext = 0xffff
+	// cabMaxString protects against a header with a string that does not end.
+	cabMaxString = 1024
+	// mszipWindow is the history carried from one MSZIP block to the next.
+	mszipWindow = 32 * 1024
+)
+
+// cabinet is one file in a cabinet set.
+type cabinet struct {
+	path  string
+	file  *os.File
+	size  int64
+	flags uint16
+	// next is the file name of the next cabinet in the set.
+	next string
+	// dataReserve is the size of the reserved area in each data block header.
+	dataReserve int64
+	// folders are the physical folders in this cabinet, and files are its file headers.
+	folders []*cabSegment
+	files   []*cabFileHeader
+	// logical maps the physical folders to the folders they are part of.
+	logical []*cabFolder
+}
+
+// cabSegment is the part of a folder stored in one cabinet.
+type cabSegment struct {
+	cab      *cabinet
+	offset   int64
+	blocks   int
+	compress uint16
+}
+
+// cabFolder is a compressed stream of files. It may span cabinets, one segment per cabinet.
+type cabFolder struct {
+	compress uint16
+	segments []*cabSegment
+}
+
+// cabFileHeader is a file as recorded in a cabinet.
+type cabFileHeader struct {
+	name    string
+	size    int64
+	offset  int64
+	folder  uint16
+	modTime time.Time
+}
+
+// cabFile is a file in a cabinet set, and the folder its data is in.
+type cabFile struct {
+	*cabFileHeader
+	folder *cabFolder
+}
+
+// cabSet is a cabinet, and the cabinets after it in the same set.
+type cabSet struct {
+	cabinets []*cabinet
+	folders  []*cabFolder
+	files    []*cabFile
+}
+
+// cabFolderReader decompresses a folder's data blocks.
+type cabFolderReader struct {
+	folder   *cabFolder
+	segment  int
+	blocks   int
+	reader   *bufio.Reader
+	reserved int64
+	// buf is decompressed data not yet read, window is the MSZIP history.
+	buf    []byte
+	window []byte
+	// pos is how much of the folder has been read. err is returned by every read after a failure.
+	pos int64
+	err error
+}
+
+// ExtractCAB extracts a Microsoft Cabinet file. Stored and MSZIP folders are supported.
+// Pass the first cabinet of a set; the next cabinets are found by the names in their
+// headers, in the same folder. Every cabinet read is returned as an archive.
+func ExtractCAB(xFile *XFile) (int64, []string, []string, error) {
+	set, err := openCabSet(xFile.FilePath)
+	if err != nil {
+		return 0, nil, set.paths(), err
+	}
+	defer set.Close()
+
+	files := []string{}
+	size := int64(0)
+
+	err = set.walk(func(file *cabFile, data io.Reader) error {
+		wfile, fSize, err := xFile.writeEntry(file.name, xFile.FileMode, data)
+		if err != nil {
+			return err
+		}
+
+		if wfile != "" {
+			files = append(files, wfile)
+			size += fSize
+		}
+
+		return nil
+	})
+	if err != nil {
+		return size, files, set.paths(), fmt.Errorf("%s: %w", xFile.FilePath, err)
+	}
+
+	return size, files, set.paths(), nil
+}
+
+// listCAB lists the files in a cabinet set.
+func listCAB(xFile *XFile) ([]Entry, error) {
+	set, err := openCabSet(xFile.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer set.Close()
+
+	entries := make([]Entry, len(set.files))
+
+	for idx, file := range set.files {
+		compressed := int64(-1)
+		if file.folder.compress == cabCompressNone {
+			compressed = file.size
+		}
+
+		entries[idx] = newEntry(file.name, file.size, compressed, xFile.FileMode, file.modTime)
+	}
+
+	return entries, nil
+}
+
+// testCAB reads every file in a cabinet set, which verifies the data block checksums.
+func testCAB(xFile *XFile) (int64, []string, error) {
+	set, err := openCabSet(xFile.FilePath)
+	if err != nil {
+		return 0, set.paths(), err
+	}
+	defer set.Close()
+
+	failed := &IntegrityError{Archive: xFile.FilePath}
+	size := int64(0)
+
+	err = set.walk(func(file *cabFile, data io.Reader) error {
+		size += failed.discard(file.name, data)
+		return nil
+	})
+	if err != nil {
+		return size, set.paths(), err
+	}
+
+	return size, set.paths(), failed.err()
+}
+
+// isCabContinuation returns true if a file is a cabinet that continues a set, not the first one.
+func isCabContinuation(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	header := make([]byte, cabHeaderSize)
+	if _, err := io.ReadFull(file, header); err != nil || !bytes.HasPrefix(header, []byte(cabMagic)) {
+		return false
+	}
+
+	return binary.LittleEndian.Uint16(header[30:32])&cabFlagPrev != 0
+}
+
+// openCabSet opens a cabinet, and the cabinets after it in its set. The set is
+// returned with the cabinets that were opened, even if one is missing or broken.
+func openCabSet(path string) (*cabSet, error) {
+	set := &cabSet{}
+
+	for path != "" {
+		for _, cab := range set.cabinets {
+			if cab.path == path {
+				set.Close()
+				return set, fmt.Errorf("%s: %w: cabinet set loops", path, ErrInvalidHead)
+			}
+		}
+
+		cab, err := openCabinet(path)
+		if err != nil {
+			set.Close()
+			return set, err
+		}
+
+		set.cabinets = append(set.cabinets, cab)
+		path = cab.nextPath()
+	}
+
+	if err := set.link(); err != nil {
+		set.Close()
+		return set, err
+	}
+
+	return set, nil
+}
+
+// link joins the folders that span cabinets, and finds the folder each file is in.
+// Files that continue from a cabinet before the first one are skipped; they can't be read.
+func (s *cabSet) link() error {
+	for idx, cab := range s.cabinets {
+		cab.logical = make([]*cabFolder, len(cab.folders))
+
+		for num, segment := range cab.folders {
+			if num == 0 && idx > 0 && cab.continues() {
+				last := s.folders[len(s.folders)-1]
+				last.segments = append(last.segments, segment)
+				cab.logical[num] = last
+
+				continue
+			}
+
+			cab.logical[num] = &cabFolder{compress: segment.compress, segments: []*cabSegment{segment}}
+			s.folders = append(s.folders, cab.logical[num])
+		}
+
+		for _, header := range cab.files {
+			switch num := int(header.folder); {
+			case num == cabContinuedFromPrev, num == cabContinuedPrevAndNext:
+				continue // listed in the cabinet before this one.
+			case num == cabContinuedToNext && len(cab.logical) > 0:
+				s.files = append(s.files, &cabFile{cabFileHeader: header, folder: cab.logical[len(cab.logical)-1]})
+			case num < len(cab.logical):
+				s.files = append(s.files, &cabFile{cabFileHeader: header, folder: cab.logical[num]})
+			default:
+				return fmt.Errorf("%s: %w: file %s is in folder %d of %d",
+					cab.path, ErrInvalidHead, header.name, num, len(cab.logical))
+			}
+		}
+	}
+
+	return nil
+}
+
+// walk reads the files in the set, in the order they are stored, and calls fn with each file's data.
+func (s *cabSet) walk(fn func(file *cabFile, data io.Reader) error) error {
+	for _, folder := range s.folders {
+		var reader *cabFolderReader
+
+		for _, file := range s.files {
+			if file.folder != folder {
+				continue
+			}
+
+			if reader == nil || reader.pos > file.offset {
+				reader = newCabFolderReader(folder)
+			}
+
+			if _, err := io.CopyN(io.Discard, reader, file.offset-reader.pos); err != nil {
+				err = fmt.Errorf("%s: %w", file.name, noEOF(err))
+				if err = fn(file, &errReader{err: err}); err != nil {
+					return err
+				}
+
+				continue
+			}
+
+			data := &exactReader{Reader: io.LimitReader(reader, file.size), remaining: file.size}
+			if err := fn(file, data); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// paths returns the path of each cabinet in the set.
+func (s *cabSet) paths() []string {
+	paths := make([]string, len(s.cabinets))
+	for idx, cab := range s.cabinets {
+		paths[idx] = cab.path
+	}
+
+	return paths
+}
+
+// Close closes every cabinet in the set.
+func (s *cabSet) Close() {
+	for _, cab := range s.cabinets {
+		cab.file.Close()
+	}
+}
+
+// openCabinet opens a cabinet and reads its folder and file headers.
+func openCabinet(path string) (*cabinet, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", volumeError(err))
+	}
+
+	cab := &cabinet{path: path, file: file}
+	if info, err := file.Stat(); err == nil {
+		cab.size = info.Size()
+	}
+
+	if err := cab.readHeaders(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return cab, nil
+}
+
+// readHeaders reads the cabinet header, the folders and the files.
+func (c *cabinet) readHeaders() error {
+	reader := bufio.NewReader(c.file)
+	header := make([]byte, cabHeaderSize)
+
+	if _, err := io.ReadFull(reader, header); err != nil || !bytes.HasPrefix(header, []byte(cabMagic)) {
+		return fmt.Errorf("%w: missing cabinet signature", ErrInvalidHead)
+	}
+
+	filesOffset := int64(binary.LittleEndian.Uint32(header[16:20]))
+	folders := int(binary.LittleEndian.Uint16(header[26:28]))
+	files := int(binary.LittleEndian.Uint16(header[28:30]))
+	c.flags = binary.LittleEndian.Uint16(header[30:32])
+	folderReserve := int64(0)
+
+	if c.flags&cabFlagReserve != 0 {
+		reserve := make([]byte, 4) //nolint:gomnd
+		if _, err := io.ReadFull(reader, reserve); err != nil {
+			return fmt.Errorf("reading cabinet header: %w", noEOF(err))
+		}
+
+		folderReserve, c.dataReserve = int64(reserve[2]), int64(reserve[3])
+
+		if _, err := io.CopyN(io.Discard, reader, int64(binary.LittleEndian.Uint16(reserve[0:2]))); err != nil {
+			return fmt.Errorf("reading cabinet header: %w", noEOF(err))
+		}
+	}
+
+	// The previous and next cabinets each have a file name and a disk name.
+	names := []*string{}
+	if c.flags&cabFlagPrev != 0 {
+		names = append(names, new(string), new(string))
+	}
+
+	if c.flags&cabFlagNext != 0 {
+		names = append(names, &c.next, new(string))
+	}
+
+	for _, name := range names {
+		var err error
+		if *name, err = readCString(reader); err != nil {
+			return err
+		}
+	}
+
+	if err := c.readFolders(reader, folders, folderReserve); err != nil {
+		return err
+	}
+
+	if _, err := c.file.Seek(filesOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("seeking to files: %w", err)
+	}
+
+	return c.readFiles(bufio.NewReader(c.file), files)
+}
+
+// readFolders reads the folder headers.
+func (c *cabinet) readFolders(reader io.Reader, count int, reserve int64) error {
+	buf := make([]byte, 8+reserve) //nolint:gomnd
+
+	for idx := 0; idx < count; idx++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return fmt.Errorf("reading cabinet folder: %w", noEOF(err))
+		}
+
+		c.folders = append(c.folders, &cabSegment{
+			cab:      c,
+			offset:   int64(binary.LittleEndian.Uint32(buf[0:4])),
+			blocks:   int(binary.LittleEndian.Uint16(buf[4:6])),
+			compress: binary.LittleEndian.Uint16(buf[6:8]) & cabCompressMask,
+		})
+	}
+
+	return nil
+}
+
+// readFiles reads the file headers. Names use backslashes, and are converted to slashes.
+func (c *cabinet) readFiles(reader *bufio.Reader, count int) error {
+	buf := make([]byte, 16) //nolint:gomnd
+
+	for idx := 0; idx < count; idx++ {
+		if _, err := io.ReadFull(reader, buf); err != nil {
+			return fmt.Errorf("reading cabinet file: %w", noEOF(err))
+		}
+
+		name, err := readCString(reader)
+		if err != nil {
+			return err
+		}
+
+		c.files = append(c.files, &cabFileHeader{
+			name:   strings.ReplaceAll(name, `\`, "/"),
+			size:   int64(binary.LittleEndian.Uint32(buf[0:4])),
+			offset: int64(binary.LittleEndian.Uint32(buf[4:8])),
+			folder: binary.LittleEndian.Uint16(buf[8:10]),
+			modTime: msdosTime(binary.LittleEndian.Uint16(buf[10:12]),
+				binary.LittleEndian.Uint16(buf[12:14])),
+		})
+	}
+
+	return nil
+}
+
+// nextPath returns the path of the next cabinet in the set, or an empty string.
+// The name is matched without case if there is no exact match, because cabinets come from Windows.
+func (c *cabinet) nextPath() string {
+	if c.flags&cabFlagNext == 0 || c.next == "" {
+		return ""
+	}
+
+	dir := filepath.Dir(c.path)
+	next := filepath.Join(dir, filepath.Base(strings.ReplaceAll(c.next, `\`, "/")))
+
+	if _, err := os.Stat(next); err == nil {
+		return next
+	}
+
+	if entries, err := os.ReadDir(dir); err == nil {
+		for _, entry := range entries {
+			if strings.EqualFold(entry.Name(), filepath.Base(next)) {
+				return filepath.Join(dir, entry.Name())
+			}
+		}
+	}
+
+	return next // missing; opening it reports ErrMissingVolume.
+}
+
+// continues returns true if this cabinet's first folder continues the last folder of the cabinet before it.
+func (c *cabinet) continues() bool {
+	for _, file := range c.files {
+		if file.folder == cabContinuedFromPrev || file.folder == cabContinuedPrevAndNext {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readCString reads a string that ends with a zero byte.
+func readCString(reader *bufio.Reader) (string, error) {
+	str := []byte{}
+
+	for len(str) < cabMaxString {
+		char, err := reader.ReadByte()
+		if err != nil {
+			return "", fmt.Errorf("reading cabinet string: %w", noEOF(err))
+		} else if char == 0 {
+			return string(str), nil
+		}
+
+		str = append(str, char)
+	}
+
+	return "", fmt.Errorf("%w: cabinet string is too long", ErrInvalidHead)
+}
+
+// msdosTime converts an MS-DOS date and time, in local time.
+func msdosTime(date, clock uint16) time.Time {
+	return time.Date(int(date>>9)+1980, time.Month(date>>5&0xf), int(date&0x1f), //nolint:gomnd
+		int(clock>>11), int(clock>>5&0x3f), int(clock&0x1f)*2, 0, time.Local) //nolint:gomnd
+}
+
+func newCabFolderReader(folder *cabFolder) *cabFolderReader {
+	return &cabFolderReader{folder: folder, segment: -1}
+}
+
+// Read reads the folder's decompressed data.
+func (r *cabFolderReader) Read(data []byte) (int, error) {
+	for len(r.buf) == 0 && r.err == nil {
+		r.err = r.nextBlock()
+	}
+
+	if len(r.buf) == 0 {
+		return 0, r.err
+	}
+
+	size := copy(data, r.buf)
+	r.buf = r.buf[size:]
+	r.pos += int64(size)
+
+	return size, nil
+}
+
+// nextBlock reads and decompresses the next data block. A block may be split
+// between two cabinets; the first part says its uncompressed size is 0.
+func (r *cabFolderReader) nextBlock() error {
+	data, size, err := r.readBlock()
+	for err == nil && size == 0 {
+		var more []byte
+
+		if more, size, err = r.readBlock(); err == nil {
+			data = append(data, more...)
+		} else if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	switch r.folder.compress {
+	case cabCompressNone:
+		if len(data) != size {
+			return fmt.Errorf("%w: stored cabinet block is %d bytes, expected %d", ErrCorruptArchive, len(data), size)
+		}
+
+		r.buf = data
+	case cabCompressMSZIP:
+		if r.buf, err = r.inflate(data, size); err != nil {
+			return err
+		}
+	case cabCompressQuantum:
+		return fmt.Errorf("%w: cabinet Quantum compression", ErrUnsupportedMethod)
+	case cabCompressLZX:
+		return fmt.Errorf("%w: cabinet LZX compression", ErrUnsupportedMethod)
+	default:
+		return fmt.Errorf("%w: cabinet compression type %d", ErrUnsupportedMethod, r.folder.compress)
+	}
+
+	return nil
+}
+
+// inflate decompresses an MSZIP block. Each block is a deflate stream
+// that may refer back to the data in the blocks before it.
+func (r *cabFolderReader) inflate(data []byte, size int) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte("CK")) {
+		return nil, fmt.Errorf("%w: MSZIP block is missing its signature", ErrCorruptArchive)
+	}
+
+	out := make([]byte, size)
+	inflater := flate.NewReaderDict(bytes.NewReader(data[2:]), r.window)
+	defer inflater.Close()
+
+	if _, err := io.ReadFull(inflater, out); err != nil {
+		return nil, fmt.Errorf("MSZIP: %w", err)
+	}
+
+	r.window = append(r.window, out...)
+	if len(r.window) > mszipWindow {
+		r.window = append([]byte{}, r.window[len(r.window)-mszipWindow:]...)
+	}
+
+	return out, nil
+}
+
+// readBlock reads the next data block, moving on to the next segment when this one is done.
+// Returns the compressed data, and the uncompressed size. The checksum is verified if it's set.
+func (r *cabFolderReader) readBlock() ([]byte, int, error) {
+	for r.blocks == 0 {
+		if r.segment++; r.segment >= len(r.folder.segments) {
+			return nil, 0, io.EOF
+		}
+
+		segment := r.folder.segments[r.segment]
+		r.blocks, r.reserved = segment.blocks, segment.cab.dataReserve
+		r.reader = bufio.NewReader(io.NewSectionReader(segment.cab.file, segment.offset, segment.cab.size-segment.offset))
+	}
+
+	r.blocks--
+
+	header := make([]byte, 8+r.reserved) //nolint:gomnd
+	if _, err := io.ReadFull(r.reader, header); err != nil {
+		return nil, 0, fmt.Errorf("reading cabinet block: %w", noEOF(err))
+	}
+
+	data := make([]byte, binary.LittleEndian.Uint16(header[4:6]))
+	if _, err := io.ReadFull(r.reader, data); err != nil {
+		return nil, 0, fmt.Errorf("reading cabinet block: %w", noEOF(err))
+	}
+
+	if sum := binary.LittleEndian.Uint32(header[0:4]); sum != 0 && sum != cabChecksum(header[4:], cabChecksum(data, 0)) {
+		return nil, 0, fmt.Errorf("cabinet block: %w", ErrChecksum)
+	}
+
+	return data, int(binary.LittleEndian.Uint16(header[6:8])), nil
+}
+
+// cabChecksum is the checksum used by cabinet data blocks: the data XORed as little endian
+// 32-bit words. The last 1 to 3 bytes are combined in the reverse order.
+func cabChecksum(data []byte, seed uint32) uint32 {
+	sum := seed
+
+	for ; len(data) >= 4; data = data[4:] {
+		sum ^= binary.LittleEndian.Uint32(data)
+	}
+
+	last := uint32(0)
+	for _, b := range data {
+		last = last<<8 | uint32(b)
+	}
+
+	return sum ^ last
+}
+
+// exactReader returns io.ErrUnexpectedEOF if its reader ends before remaining bytes are read.
+type exactReader struct {
+	io.Reader
+	remaining int64
+}
+
+func (e *exactReader) Read(data []byte) (int, error) {
+	size, err := e.Reader.Read(data)
+	e.remaining -= int64(size)
+
+	if errors.Is(err, io.EOF) && e.remaining > 0 {
+		return size, io.ErrUnexpectedEOF
+	}
+
+	return size, err //nolint:wrapcheck
+}
+
+// errReader returns an error from every read.
+type errReader struct{ err error }
+
+func (e *errReader) Read([]byte) (int, error) { return 0, e.err }
@@ -0,0 +1,302 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	cabStored = 0
+	cabMSZIP  = 1
+)
+
+type cabTestFile struct {
+	name string
+	data []byte
+}
+
+// cabTestBlock is a compressed data block. A size of 0 means it continues in the next cabinet.
+type cabTestBlock struct {
+	data []byte
+	size int
+}
+
+func testCabFiles() []cabTestFile {
+	return []cabTestFile{
+		{name: "readme.txt", data: []byte("vendor driver bundle\r\n")},
+		{name: `drivers\x64\driver.inf`, data: bytes.Repeat([]byte("[Version]\r\nSignature=$Windows NT$\r\n"), 200)},
+		{name: `drivers\x64\driver.sys`, data: []byte(strings.Repeat("MZ\x90\x00driver", 9000))},
+	}
+}
+
+func TestExtractCAB(t *testing.T) {
+	t.Parallel()
+
+	for _, compress := range []uint16{cabStored, cabMSZIP} {
+		dir := t.TempDir()
+		cabs, err := makeCabSet(dir, "driver", compress, testCabFiles(), 1)
+		assert.NoError(t, err)
+
+		format, err := xtractr.DetectFormat(cabs[0])
+		assert.NoError(t, err)
+		assert.Equal(t, xtractr.FormatCAB, format)
+
+		entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: cabs[0]})
+		assert.NoError(t, err)
+		assert.Len(t, entries, 3)
+		assert.Equal(t, "drivers/x64/driver.sys", entries[2].Name)
+		assert.Equal(t, int64(len(testCabFiles()[2].data)), entries[2].Size)
+
+		size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: cabs[0], OutputDir: filepath.Join(dir, "out")})
+		assert.NoError(t, err, compress)
+		assert.Equal(t, cabs, archives)
+		assert.Len(t, files, 3)
+
+		total := int64(0)
+		for _, file := range testCabFiles() {
+			data, err := os.ReadFile(filepath.Join(dir, "out", strings.ReplaceAll(file.name, `\`, "/")))
+			assert.NoError(t, err)
+			assert.Equal(t, file.data, data, file.name)
+
+			total += int64(len(file.data))
+		}
+
+		assert.Equal(t, total, size)
+
+		_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: cabs[0]})
+		assert.NoError(t, err)
+	}
+}
+
+func TestExtractCABSet(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cabs, err := makeCabSet(dir, "disk", cabMSZIP, testCabFiles(), 3)
+	assert.NoError(t, err)
+
+	// Only the first cabinet is found; the others are read with it.
+	found := xtractr.FindCompressedFiles(xtractr.Filter{Path: dir})
+	assert.Equal(t, []string{cabs[0]}, found[dir])
+
+	_, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: cabs[0], OutputDir: filepath.Join(dir, "out")})
+	assert.NoError(t, err)
+	assert.Equal(t, cabs, archives)
+	assert.Len(t, files, 3)
+
+	data, err := os.ReadFile(filepath.Join(dir, "out", "drivers", "x64", "driver.sys"))
+	assert.NoError(t, err)
+	assert.Equal(t, testCabFiles()[2].data, data, "the file spanning cabinets must be complete")
+
+	assert.NoError(t, os.Remove(cabs[2]))
+
+	_, _, archives, err = xtractr.ExtractFile(&xtractr.XFile{FilePath: cabs[0], OutputDir: filepath.Join(dir, "out2")})
+	assert.ErrorIs(t, err, xtractr.ErrMissingVolume)
+	assert.Equal(t, cabs[:2], archives)
+}
+
+func TestExtractCABNamePolicy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cabs, err := makeCabSet(dir, "names", cabMSZIP, []cabTestFile{
+		{name: `__MACOSX\._readme.txt`, data: []byte("metadata")},
+		{name: "CON.txt", data: []byte("reserved")},
+		{name: "readme.txt", data: []byte("readme")},
+	}, 1)
+	assert.NoError(t, err)
+
+	output := filepath.Join(dir, "out")
+	xFile := &xtractr.XFile{FilePath: cabs[0], OutputDir: output, NamePolicy: xtractr.NamePolicy{Portable: true, SkipMacOS: true}}
+	size, files, _, err := xtractr.ExtractFile(xFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(output, "CON_.txt"), filepath.Join(output, "readme.txt")}, files,
+		"skipped files are not reported")
+	assert.Equal(t, int64(len("reserved")+len("readme")), size)
+	assert.NoDirExists(t, filepath.Join(output, "__MACOSX"))
+}
+
+func TestCABChecksum(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cabs, err := makeCabSet(dir, "bad", cabStored, testCabFiles(), 1)
+	assert.NoError(t, err)
+
+	data, err := os.ReadFile(cabs[0])
+	assert.NoError(t, err)
+
+	data[bytes.Index(data, []byte("vendor driver"))] = 'V'
+	assert.NoError(t, os.WriteFile(cabs[0], data, 0o600))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: cabs[0]})
+	assert.ErrorIs(t, err, xtractr.ErrCorruptArchive)
+	assert.ErrorIs(t, err, xtractr.ErrChecksum)
+}
+
+// makeCabSet writes files into a set of cabinets with one folder, split into volumes.
+// A data block is split between each pair of cabinets, and the last file spans them.
+func makeCabSet(dir, name string, compress uint16, files []cabTestFile, volumes int) ([]string, error) {
+	stream := []byte{}
+	for _, file := range files {
+		stream = append(stream, file.data...)
+	}
+
+	const blockSize = 4096
+
+	blocks := []cabTestBlock{}
+	window := []byte{}
+
+	for start := 0; start < len(stream); start += blockSize {
+		end := start + blockSize
+		if end > len(stream) {
+			end = len(stream)
+		}
+
+		raw := stream[start:end]
+		if compress == cabStored {
+			blocks = append(blocks, cabTestBlock{data: raw, size: len(raw)})
+			continue
+		}
+
+		buf := bytes.NewBufferString("CK")
+		writer, _ := flate.NewWriterDict(buf, flate.BestCompression, window)
+		_, _ = writer.Write(raw)
+		_ = writer.Close()
+
+		blocks = append(blocks, cabTestBlock{data: buf.Bytes(), size: len(raw)})
+		window = append(window, raw...)
+	}
+
+	// Each volume gets an equal share of blocks. The last block in each volume but the last is split.
+	paths := []string{}
+	perVolume := (len(blocks) + volumes - 1) / volumes
+	carry := []byte(nil)
+
+	for vol := 0; vol < volumes; vol++ {
+		volBlocks := []cabTestBlock{}
+		if carry != nil {
+			volBlocks = append(volBlocks, cabTestBlock{data: carry, size: blocks[vol*perVolume-1].size})
+			carry = nil
+		}
+
+		for idx := vol * perVolume; idx < (vol+1)*perVolume && idx < len(blocks); idx++ {
+			volBlocks = append(volBlocks, blocks[idx])
+		}
+
+		if vol < volumes-1 {
+			last := volBlocks[len(volBlocks)-1]
+			half := len(last.data) / 2
+			volBlocks[len(volBlocks)-1] = cabTestBlock{data: last.data[:half]}
+			carry = last.data[half:]
+		}
+
+		cabFiles := []cabTestFile{}
+		folders := []uint16{}
+
+		for idx, file := range files {
+			switch {
+			case volumes == 1:
+				cabFiles, folders = append(cabFiles, file), append(folders, 0)
+			case vol == 0:
+				cabFiles = append(cabFiles, file)
+				folders = append(folders, map[bool]uint16{true: 0xfffe, false: 0}[idx == len(files)-1])
+			case idx == len(files)-1: // the spanning file is listed in every cabinet.
+				cabFiles = append(cabFiles, file)
+				folders = append(folders, map[bool]uint16{true: 0xfffd, false: 0xffff}[vol == volumes-1])
+			}
+		}
+
+		path := filepath.Join(dir, fmt.Sprintf("%s%d.cab", name, vol+1))
+		next := ""
+
+		if vol < volumes-1 {
+			next = fmt.Sprintf("%s%d.cab", name, vol+2)
+		}
+
+		if err := writeCabinet(path, vol, next, compress, cabFiles, folders, volBlocks); err != nil {
+			return nil, err
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// writeCabinet writes one cabinet with one folder. Blocks without a size continue in the next cabinet.
+func writeCabinet(path string, index int, next string, compress uint16, files []cabTestFile, folders []uint16,
+	blocks []cabTestBlock,
+) error {
+	flags := uint16(0)
+	names := []byte{}
+
+	if index > 0 {
+		flags |= 1
+		names = append(names, []byte(fmt.Sprintf("prev.cab\x00disk%d\x00", index))...)
+	}
+
+	if next != "" {
+		flags |= 2
+		names = append(names, []byte(next+"\x00disk\x00")...)
+	}
+
+	fileHeaders := &bytes.Buffer{}
+	offset := uint32(0)
+
+	for idx, file := range files {
+		_ = binary.Write(fileHeaders, binary.LittleEndian, []uint32{uint32(len(file.data)), offset})
+		_ = binary.Write(fileHeaders, binary.LittleEndian, []uint16{folders[idx], 0x5a21, 0x6000, 0x20})
+		fileHeaders.WriteString(file.name + "\x00")
+
+		offset += uint32(len(file.data))
+	}
+
+	filesOffset := 36 + len(names) + 8
+	dataOffset := filesOffset + fileHeaders.Len()
+	data := &bytes.Buffer{}
+
+	for _, block := range blocks {
+		header := make([]byte, 8)
+		binary.LittleEndian.PutUint16(header[4:6], uint16(len(block.data)))
+		binary.LittleEndian.PutUint16(header[6:8], uint16(block.size))
+		binary.LittleEndian.PutUint32(header[0:4], cabSum(header[4:8], cabSum(block.data, 0)))
+		data.Write(header)
+		data.Write(block.data)
+	}
+
+	buf := bytes.NewBufferString("MSCF")
+	_ = binary.Write(buf, binary.LittleEndian, []uint32{0, uint32(dataOffset + data.Len()), 0, uint32(filesOffset), 0})
+	_ = binary.Write(buf, binary.LittleEndian, []uint8{3, 1})
+	_ = binary.Write(buf, binary.LittleEndian, []uint16{1, uint16(len(files)), flags, 1234, uint16(index)})
+	buf.Write(names)
+	_ = binary.Write(buf, binary.LittleEndian, uint32(dataOffset))
+	_ = binary.Write(buf, binary.LittleEndian, []uint16{uint16(len(blocks)), compress})
+	buf.Write(fileHeaders.Bytes())
+	buf.Write(data.Bytes())
+
+	return os.WriteFile(path, buf.Bytes(), 0o600) //nolint:wrapcheck
+}
+
+// cabSum is the cabinet data block checksum.
+func cabSum(data []byte, sum uint32) uint32 {
+	for ; len(data) >= 4; data = data[4:] {
+		sum ^= binary.LittleEndian.Uint32(data)
+	}
+
+	last := uint32(0)
+	for _, b := range data {
+		last = last<<8 | uint32(b)
+	}
+
+	return sum ^ last
+}
@@ -0,0 +1,250 @@
+package xtractr
+
+/* Decode the names of files in zip archives that were not written in UTF-8. */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/yeka/zip"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+)
+
+// NameCharsetAuto is the XFile.NameCharset that guesses the character set of names.
+const NameCharsetAuto = "auto"
+
+const (
+	// zipUTF8Flag is the general purpose flag that says a name is UTF-8 (bit 11).
+	zipUTF8Flag = 0x800
+	// zipUnicodePath is the Info-ZIP Unicode Path extra field. It has a UTF-8 name,
+	// and the CRC32 of the name it replaces, so a renamed file's stale field is ignored.
+	zipUnicodePath = 0x7075
+)
+
+//nolint:gochecknoglobals
+var (
+	// guessCharsets are tried in order when names are not UTF-8. The first one wins a tie.
+	guessCharsets = []encoding.Encoding{
+		simplifiedchinese.GBK,
+		japanese.ShiftJIS,
+		traditionalchinese.Big5,
+		korean.EUCKR,
+		charmap.CodePage866,
+		charmap.CodePage437,
+	}
+	// charsetAliases are common names for character sets that the IANA and WHATWG indexes do not have.
+	charsetAliases = map[string]encoding.Encoding{
+		"cp437":  charmap.CodePage437,
+		"cp866":  charmap.CodePage866,
+		"cp932":  japanese.ShiftJIS,
+		"cp936":  simplifiedchinese.GBK,
+		"cp949":  korean.EUCKR,
+		"cp950":  traditionalchinese.Big5,
+		"sjis":   japanese.ShiftJIS,
+		"cp1251": charmap.Windows1251,
+		"cp1252": charmap.Windows1252,
+	}
+)
+
+// decodeZIPNames changes the name of every file in a zip archive to UTF-8. A name marked as
+// UTF-8, or with a Unicode Path extra field, is used as it is. Other names are decoded with
+// charset. When it's blank, they are used as they are if they are all valid UTF-8, because
+// many tools write UTF-8 without the flag, and decoded with CP437 if not, like the zip
+// specification says.
+func decodeZIPNames(files []*zip.File, charset string) error {
+	legacy := []*zip.File{}
+
+	for _, zipFile := range files {
+		if name, ok := zipUnicodeName(zipFile); ok {
+			zipFile.Name = name
+		} else if !isASCII(zipFile.Name) {
+			legacy = append(legacy, zipFile)
+		}
+	}
+
+	if len(legacy) == 0 {
+		return nil
+	}
+
+	enc, err := zipCharset(charset, legacy)
+	if err != nil || enc == nil {
+		return err
+	}
+
+	decoder := enc.NewDecoder()
+
+	for _, zipFile := range legacy {
+		if name, err := decoder.String(zipFile.Name); err == nil {
+			zipFile.Name = name
+		}
+	}
+
+	return nil
+}
+
+// zipUnicodeName returns the UTF-8 name of a file, if the archive has one.
+func zipUnicodeName(zipFile *zip.File) (string, bool) {
+	if zipFile.Flags&zipUTF8Flag != 0 {
+		return zipFile.Name, true
+	}
+
+	for extra := zipFile.Extra; len(extra) >= 4; { //nolint:gomnd
+		tag, size := binary.LittleEndian.Uint16(extra), int(binary.LittleEndian.Uint16(extra[2:]))
+		if len(extra) < 4+size {
+			break
+		}
+
+		// Version 1, the CRC32 of the name in the header, then the name.
+		field := extra[4 : 4+size]
+		if tag == zipUnicodePath && size > 5 && field[0] == 1 &&
+			binary.LittleEndian.Uint32(field[1:]) == crc32.ChecksumIEEE([]byte(zipFile.Name)) && utf8.Valid(field[5:]) {
+			return string(field[5:]), true
+		}
+
+		extra = extra[4+size:]
+	}
+
+	return "", false
+}
+
+// zipCharset returns the encoding for charset. A nil encoding means the names are UTF-8.
+func zipCharset(charset string, files []*zip.File) (encoding.Encoding, error) {
+	switch name := strings.ToLower(strings.TrimSpace(charset)); {
+	case name == "" && validUTF8(files):
+		return nil, nil
+	case name == "":
+		return charmap.CodePage437, nil
+	case name == NameCharsetAuto:
+		return guessCharset(files), nil
+	case name == "utf-8" || name == "utf8":
+		return nil, nil
+	case charsetAliases[name] != nil:
+		return charsetAliases[name], nil
+	}
+
+	if enc, err := ianaindex.IANA.Encoding(charset); err == nil && enc != nil {
+		return enc, nil
+	}
+
+	if enc, err := htmlindex.Get(charset); err == nil {
+		return enc, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownCharset, charset)
+}
+
+// guessCharset returns the character set that makes the most sense of the names. UTF-8
+// is used if every name is valid UTF-8, because legacy names rarely are. This is a guess:
+// names with only Chinese characters can not be told apart from Japanese ones.
+func guessCharset(files []*zip.File) encoding.Encoding {
+	if validUTF8(files) {
+		return nil
+	}
+
+	var (
+		best      encoding.Encoding
+		bestScore int
+	)
+
+	for _, enc := range guessCharsets {
+		decoder := enc.NewDecoder()
+		score := 0
+
+		for _, zipFile := range files {
+			name, err := decoder.String(zipFile.Name)
+			if err != nil {
+				score -= 10 * len(zipFile.Name) //nolint:gomnd
+				continue
+			}
+
+			score += nameScore(name)
+		}
+
+		if best == nil || score > bestScore {
+			best, bestScore = enc, score
+		}
+	}
+
+	return best
+}
+
+// nameScore is how much sense a decoded name makes. Letters score, and a double byte
+// character set's kana and Han score more than a single byte character set's letters,
+// because they use two bytes. Bad bytes, half-width katakana, control characters and
+// letters of one script stuck to another script's letters (a wrong decode) cost points.
+func nameScore(name string) int {
+	score, last := 0, ' '
+
+	for _, r := range name {
+		switch {
+		case r == utf8.RuneError, unicode.IsControl(r), unicode.Is(unicode.Co, r):
+			score -= 10
+		case r >= 0xff61 && r <= 0xff9f, strings.ContainsRune("@^`|~{}\\", r):
+			score -= 2
+		case unicode.In(r, unicode.Hiragana, unicode.Katakana):
+			score += 3
+		case unicode.In(r, unicode.Han, unicode.Hangul, unicode.Cyrillic):
+			score += 2
+		case r >= utf8.RuneSelf && unicode.IsLetter(r):
+			score++
+		case r >= utf8.RuneSelf:
+			score-- // box drawing, symbols.
+		}
+
+		if script(last) != script(r) && script(last) != 0 && script(r) != 0 {
+			score -= 2
+		}
+
+		last = r
+	}
+
+	return score
+}
+
+// script groups letters for nameScore: Latin, Cyrillic, or Chinese, Japanese and Korean.
+// Returns 0 for anything else.
+func script(r rune) byte {
+	switch {
+	case unicode.Is(unicode.Latin, r):
+		return 'l'
+	case unicode.Is(unicode.Cyrillic, r):
+		return 'c'
+	case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+		return 'k'
+	default:
+		return 0
+	}
+}
+
+// validUTF8 returns true if every name is valid UTF-8.
+func validUTF8(files []*zip.File) bool {
+	for _, zipFile := range files {
+		if !utf8.ValidString(zipFile.Name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// isASCII returns true if name has no bytes above 0x7f, so every character set decodes it the same.
+func isASCII(name string) bool {
+	for idx := 0; idx < len(name); idx++ {
+		if name[idx] >= utf8.RuneSelf {
+			return false
+		}
+	}
+
+	return true
+}
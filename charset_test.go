@@ -0,0 +1,124 @@
+package xtractr_test
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+	"github.com/yeka/zip"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+func TestExtractZIPNames(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		test    string
+		name    string // written as it is.
+		flags   uint16
+		extra   []byte
+		charset string
+		want    string
+	}{
+		{test: "utf-8 flag", name: "Über/naïve.txt", flags: 0x800, want: "Über/naïve.txt"},
+		{test: "unicode path", name: "r\x82sum\x82.txt", extra: unicodePath("r\x82sum\x82.txt", "résumé 名.txt"), want: "résumé 名.txt"},
+		{test: "stale unicode path", name: "r\x82sum\x82.txt", extra: unicodePath("other.txt", "名.txt"), want: "résumé.txt"},
+		{test: "cp437", name: encode(t, charmap.CodePage437, "Résumé.txt"), want: "Résumé.txt"},
+		{test: "gbk", name: encode(t, simplifiedchinese.GBK, "中文文件.txt"), charset: "gbk", want: "中文文件.txt"},
+		{test: "sjis", name: encode(t, japanese.ShiftJIS, "日本語のファイル.txt"), charset: "Shift-JIS", want: "日本語のファイル.txt"},
+		{test: "cp866", name: encode(t, charmap.CodePage866, "Отчёт 2020.doc"), charset: "cp866", want: "Отчёт 2020.doc"},
+		{test: "auto gbk", name: encode(t, simplifiedchinese.GBK, "中文文件.txt"), charset: "auto", want: "中文文件.txt"},
+		{test: "auto sjis", name: encode(t, japanese.ShiftJIS, "日本語のファイル.txt"), charset: "auto", want: "日本語のファイル.txt"},
+		{test: "auto cp866", name: encode(t, charmap.CodePage866, "Отчёт 2020.doc"), charset: "auto", want: "Отчёт 2020.doc"},
+		{test: "auto cp437", name: encode(t, charmap.CodePage437, "Résumé.txt"), charset: "auto", want: "Résumé.txt"},
+		{test: "auto utf-8", name: "Über.txt", charset: "auto", want: "Über.txt"},
+		{test: "unflagged utf-8", name: "Über/naïve 名.txt", want: "Über/naïve 名.txt"},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.test, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "names.zip")
+			assert.NoError(t, makeNamedZip(archive, &zip.FileHeader{Name: test.name, Flags: test.flags, Extra: test.extra}))
+
+			xFile := &xtractr.XFile{FilePath: archive, OutputDir: filepath.Join(dir, "out"), NameCharset: test.charset}
+			entries, err := xtractr.ListFile(xFile)
+			assert.NoError(t, err)
+			assert.Equal(t, test.want, entries[0].Name)
+
+			_, files, _, err := xtractr.ExtractFile(xFile)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{filepath.Join(dir, "out", test.want)}, files, "the file written is the file reported")
+			assert.FileExists(t, files[0])
+		})
+	}
+}
+
+func TestExtractZIPNamesCharset(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "names.zip")
+	assert.NoError(t, makeNamedZip(archive, &zip.FileHeader{Name: encode(t, simplifiedchinese.GBK, "中文.txt")}))
+
+	_, err := xtractr.ListFile(&xtractr.XFile{FilePath: archive, NameCharset: "klingon"})
+	assert.ErrorIs(t, err, xtractr.ErrUnknownCharset)
+
+	// ASCII names do not need a character set.
+	assert.NoError(t, makeNamedZip(archive, &zip.FileHeader{Name: "plain.txt"}))
+
+	_, err = xtractr.ListFile(&xtractr.XFile{FilePath: archive, NameCharset: "klingon"})
+	assert.NoError(t, err)
+}
+
+// makeNamedZip writes a zip file with one file in it, with the header's name, flags and extra fields.
+func makeNamedZip(path string, header *zip.FileHeader) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+
+	writer, err := zipWriter.CreateHeader(header)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if _, err = writer.Write([]byte("data")); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return zipWriter.Close() //nolint:wrapcheck
+}
+
+// unicodePath returns an Info-ZIP Unicode Path extra field for a file named name.
+func unicodePath(name, unicode string) []byte {
+	field := make([]byte, 9, 9+len(unicode))
+	binary.LittleEndian.PutUint16(field, 0x7075)
+	binary.LittleEndian.PutUint16(field[2:], uint16(5+len(unicode)))
+	field[4] = 1
+	binary.LittleEndian.PutUint32(field[5:], crc32.ChecksumIEEE([]byte(name)))
+
+	return append(field, unicode...)
+}
+
+func encode(t *testing.T, enc encoding.Encoding, name string) string {
+	t.Helper()
+
+	encoded, err := enc.NewEncoder().String(name)
+	assert.NoError(t, err)
+
+	return encoded
+}
@@ -10,12 +10,14 @@ import (
 	"strings"
 	"time"
 
-	"golift.io/xtractr"
+	"github.com/fmzchao/xtractr"
 )
 
 func main() {
 	pwd, _ := os.Getwd()
 	output := flag.String("output", pwd, "Output directory, default is current directory")
+	list := flag.Bool("list", false, "List archive contents instead of extracting them")
+	password := flag.String("password", "", "Archive password, if the archives are encrypted")
 
 	flag.Parse()
 	log.SetFlags(0)
@@ -23,13 +25,51 @@ func main() {
 	inputFiles := flag.Args()
 	if len(inputFiles) < 1 {
 		log.Printf("If you pass a directory, this app will extract every archive in it.")
-		log.Fatalf("Usage: %s [-output <path>] <path> [paths...]", os.Args[0])
+		log.Fatalf("Usage: %s [-list] [-password <pass>] [-output <path>] <path> [paths...]", os.Args[0])
 	}
 
-	processInput(inputFiles, *output)
+	if *list {
+		listInput(inputFiles, *password)
+		return
+	}
+
+	processInput(inputFiles, *output, *password)
+}
+
+func listInput(paths []string, password string) {
+	for _, files := range getArchives(paths) {
+		for _, fileName := range files {
+			entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: fileName, Password: password})
+			if err != nil {
+				log.Printf("[ERROR] Archive: %s: %v", fileName, err)
+				continue
+			}
+
+			log.Printf("==> Archive: %s (%d items)", fileName, len(entries))
+
+			for _, entry := range entries {
+				log.Printf("%s %12d %12d %s %s%s", entry.Mode, entry.Size, entry.CompressedSize,
+					entry.ModTime.Format("2006-01-02 15:04"), entry.Name, entryFlags(entry))
+			}
+		}
+	}
+}
+
+// entryFlags returns a short note about symlinks and encrypted items.
+func entryFlags(entry xtractr.Entry) string {
+	switch {
+	case entry.IsSymlink && entry.Encrypted:
+		return " (symlink, encrypted)"
+	case entry.IsSymlink:
+		return " (symlink)"
+	case entry.Encrypted:
+		return " (encrypted)"
+	default:
+		return ""
+	}
 }
 
-func processInput(paths []string, output string) {
+func processInput(paths []string, output, password string) {
 	log.Printf("==> Output Path: %s", output)
 
 	archives := getArchives(paths)
@@ -56,7 +96,7 @@ func processInput(paths []string, output string) {
 				OutputDir: output,   // Folder to extract archive into.
 				FileMode:  0o644,    //nolint:gomnd // Write files with this mode.
 				DirMode:   0o755,    //nolint:gomnd // Write folders with this mode.
-				Password:  "",       // (RAR) Archive password. Blank for none.
+				Password:  password, // Archive password. Blank for none.
 			})
 			if err != nil {
 				log.Printf("[ERROR] Archive: %s: %v", fileName, err)
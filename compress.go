@@ -0,0 +1,156 @@
+package xtractr
+
+/* Code to extract xz, zstd, lz4, brotli and lzma files, and the decompressors the pipeline uses. */
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// decompressor returns the decompressed stream for a compressed file.
+// Close the returned stream when done; zstd uses it to free its decoder.
+type decompressor func(io.Reader) (io.ReadCloser, error)
+
+// ExtractXZ extracts an xz-compressed file, or the tarball inside one.
+func ExtractXZ(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatXZ)
+}
+
+// ExtractTarXZ extracts an xz-compressed tar archive. It is the same as ExtractXZ.
+func ExtractTarXZ(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatXZ)
+}
+
+// ExtractZstd extracts a zstd-compressed file, or the tarball inside one.
+func ExtractZstd(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatZstd)
+}
+
+// ExtractTarZstd extracts a zstd-compressed tar archive. It is the same as ExtractZstd.
+func ExtractTarZstd(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatZstd)
+}
+
+// ExtractLZ4 extracts an lz4-compressed file, or the tarball inside one.
+func ExtractLZ4(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatLZ4)
+}
+
+// ExtractTarLZ4 extracts an lz4-compressed tar archive. It is the same as ExtractLZ4.
+func ExtractTarLZ4(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatLZ4)
+}
+
+// ExtractBrotli extracts a brotli-compressed file, or the tarball inside one.
+func ExtractBrotli(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatBrotli)
+}
+
+// ExtractTarBrotli extracts a brotli-compressed tar archive. It is the same as ExtractBrotli.
+func ExtractTarBrotli(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatBrotli)
+}
+
+// ExtractLZMA extracts an lzma-compressed file, or the tarball inside one.
+func ExtractLZMA(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatLZMA)
+}
+
+// ExtractTarLZMA extracts an lzma-compressed tar archive. It is the same as ExtractLZMA.
+func ExtractTarLZMA(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatLZMA)
+}
+
+func newGzipReader(reader io.Reader) (io.ReadCloser, error) {
+	stream, err := gzip.NewReader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("gzip.NewReader: %w", err)
+	}
+
+	return stream, nil
+}
+
+func newBzip2Reader(reader io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(bzip2.NewReader(reader)), nil
+}
+
+func newXZReader(reader io.Reader) (io.ReadCloser, error) {
+	return untypedDecoder(reader, func(source io.Reader) (io.Reader, error) {
+		stream, err := xz.NewReader(source)
+		if err != nil {
+			return nil, fmt.Errorf("xz.NewReader: %w", err)
+		}
+
+		return stream, nil
+	})
+}
+
+func newZstdReader(reader io.Reader) (io.ReadCloser, error) {
+	stream, err := zstd.NewReader(reader, zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, fmt.Errorf("zstd.NewReader: %w", err)
+	}
+
+	return stream.IOReadCloser(), nil
+}
+
+func newLZ4Reader(reader io.Reader) (io.ReadCloser, error) {
+	// Hide the lz4 reader's WriteTo; it fails if Read was called first, like when the header is peeked.
+	return io.NopCloser(struct{ io.Reader }{lz4.NewReader(reader)}), nil
+}
+
+func newBrotliReader(reader io.Reader) (io.ReadCloser, error) {
+	return untypedDecoder(reader, func(source io.Reader) (io.Reader, error) {
+		return brotli.NewReader(source), nil
+	})
+}
+
+func newLZMAReader(reader io.Reader) (io.ReadCloser, error) {
+	return untypedDecoder(reader, func(source io.Reader) (io.Reader, error) {
+		stream, err := lzma.NewReader(source)
+		if err != nil {
+			return nil, fmt.Errorf("lzma.NewReader: %w", err)
+		}
+
+		return stream, nil
+	})
+}
+
+// gzipSize returns the uncompressed size from the gzip trailer, so it's only right for files under 4GB.
+func gzipSize(file *os.File) int64 {
+	info, err := file.Stat()
+	if err != nil {
+		return -1
+	}
+
+	trailer := make([]byte, gzipTrailerSize)
+	if _, err := file.ReadAt(trailer, info.Size()-gzipTrailerSize); err != nil {
+		return -1
+	}
+
+	return int64(binary.LittleEndian.Uint32(trailer))
+}
+
+// detectLZMA returns true if the header is a valid lzma header. lzma files have no magic bytes,
+// so the signature only matches the most common properties byte.
+func detectLZMA(header []byte) bool {
+	_, err := lzma.NewReader(bytes.NewReader(header))
+
+	return err == nil
+}
+
+// noDetect is used by formats that have no signature and can only be found by their suffix.
+func noDetect([]byte) bool {
+	return false
+}
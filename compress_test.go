@@ -0,0 +1,133 @@
+package xtractr_test
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/fmzchao/xtractr"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestCompressedFiles(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		suffix    string
+		tarSuffix string
+		format    xtractr.Format
+		tarFormat xtractr.Format
+		writer    func(io.Writer) (io.WriteCloser, error)
+	}{
+		{".xz", ".txz", xtractr.FormatXZ, xtractr.FormatTarXZ, func(w io.Writer) (io.WriteCloser, error) {
+			return xz.NewWriter(w)
+		}},
+		{".zst", ".tar.zst", xtractr.FormatZstd, xtractr.FormatTarZstd, func(w io.Writer) (io.WriteCloser, error) {
+			return zstd.NewWriter(w)
+		}},
+		{".lz4", ".tar.lz4", xtractr.FormatLZ4, xtractr.FormatTarLZ4, func(w io.Writer) (io.WriteCloser, error) {
+			return lz4.NewWriter(w), nil
+		}},
+		{".br", ".tar.br", xtractr.FormatUnknown, xtractr.FormatTarBrotli, func(w io.Writer) (io.WriteCloser, error) {
+			return brotli.NewWriter(w), nil
+		}},
+		{".lzma", ".tar.lzma", xtractr.FormatLZMA, xtractr.FormatTarLZMA, func(w io.Writer) (io.WriteCloser, error) {
+			return lzma.NewWriter(w)
+		}},
+	}
+
+	for _, test := range tests {
+		test := test
+		t.Run(test.suffix, func(t *testing.T) {
+			t.Parallel()
+
+			dir := t.TempDir()
+			single := filepath.Join(dir, "file.txt"+test.suffix)
+			tarball := filepath.Join(dir, "files"+test.tarSuffix)
+			data := bytes.Repeat([]byte("compressed data\n"), 1000)
+
+			assert.NoError(t, makeCompressedFile(single, test.writer, data))
+			assert.NoError(t, makeCompressedFile(tarball, test.writer, makeTar(t, map[string][]byte{"a.txt": data, "b/c.txt": data})))
+
+			format, err := xtractr.DetectFormat(single)
+			assert.NoError(t, err)
+			assert.Equal(t, test.format, format, "single files are detected by content, except brotli")
+
+			format, err = xtractr.DetectFormat(tarball)
+			assert.NoError(t, err)
+
+			if test.format == xtractr.FormatUnknown {
+				assert.Equal(t, xtractr.FormatUnknown, format, "brotli tarballs are only found by their suffix")
+				format = xtractr.FormatFromName(tarball)
+			}
+
+			assert.Equal(t, test.tarFormat, format, "tarballs are detected by content")
+
+			size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: single, OutputDir: filepath.Join(dir, "single")})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(len(data)), size)
+			assert.Equal(t, []string{filepath.Join(dir, "single", "file.txt")}, files)
+			assert.Equal(t, []string{single}, archives)
+
+			size, files, _, err = xtractr.ExtractFile(&xtractr.XFile{FilePath: tarball, OutputDir: filepath.Join(dir, "tar")})
+			assert.NoError(t, err)
+			assert.Equal(t, int64(2*len(data)), size)
+			assert.Len(t, files, 2)
+
+			written, err := os.ReadFile(filepath.Join(dir, "tar", "b", "c.txt"))
+			assert.NoError(t, err)
+			assert.Equal(t, data, written)
+
+			_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: tarball})
+			assert.NoError(t, err)
+
+			found := xtractr.FindCompressedFiles(xtractr.Filter{Path: dir})
+			assert.ElementsMatch(t, []string{single, tarball}, found[dir])
+		})
+	}
+}
+
+func makeCompressedFile(fileName string, writer func(io.Writer) (io.WriteCloser, error), data []byte) error {
+	openFile, err := os.Create(fileName)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer openFile.Close()
+
+	compressor, err := writer(openFile)
+	if err != nil {
+		return err
+	}
+
+	if _, err := compressor.Write(data); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return compressor.Close() //nolint:wrapcheck
+}
+
+func makeTar(t *testing.T, contents map[string][]byte) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	tarWriter := tar.NewWriter(buf)
+
+	for name, data := range contents {
+		header := &tar.Header{Name: name, Mode: xtractr.DefaultFileMode, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		assert.NoError(t, tarWriter.WriteHeader(header))
+		_, err := tarWriter.Write(data)
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, tarWriter.Close())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,367 @@
+package xtractr
+
+/* Code to read cpio archives: standalone, inside RPM packages, and initramfs images. */
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const (
+	// cpioNewcMagic starts each header in a "new ASCII" (SVR4) cpio archive.
+	cpioNewcMagic = "070701"
+	// cpioCRCMagic is a new ASCII header with a checksum of the file's data.
+	cpioCRCMagic = "070702"
+	// cpioOdcMagic starts each header in a "portable ASCII" (POSIX.1) cpio archive.
+	cpioOdcMagic = "070707"
+	// cpioNewcHeaderSize and cpioOdcHeaderSize are the header sizes, without the name.
+	cpioNewcHeaderSize = 110
+	cpioOdcHeaderSize  = 76
+	// cpioTrailer is the name of the last entry in a cpio archive.
+	cpioTrailer = "TRAILER!!!"
+	// cpioMaxName protects against a header that claims a huge name or symlink target.
+	cpioMaxName = 64 * 1024
+)
+
+// Unix file types and mode bits, from the mode in cpio headers and RPM file lists.
+const (
+	unixTypeMask    = 0o170000
+	unixTypeFIFO    = 0o010000
+	unixTypeChar    = 0o020000
+	unixTypeDir     = 0o040000
+	unixTypeBlock   = 0o060000
+	unixTypeRegular = 0o100000
+	unixTypeSymlink = 0o120000
+	unixTypeSocket  = 0o140000
+	unixSetuid      = 0o4000
+	unixSetgid      = 0o2000
+	unixSticky      = 0o1000
+)
+
+// cpioReader reads the entries of a cpio archive from a stream, like tar.Reader.
+// The newc, crc and odc formats are supported; each entry may use any of them.
+type cpioReader struct {
+	stream io.Reader
+	// remaining is the unread data in the current entry, and pad is the padding after it.
+	remaining int64
+	pad       int64
+	// crc is true if the current entry has a checksum. sum adds up its data as it's read.
+	crc   bool
+	check uint32
+	sum   uint32
+}
+
+// cpioHeader describes an entry in a cpio archive.
+type cpioHeader struct {
+	Name string
+	// Linkname is a symlink's target. It's read from the entry's data, so Size is 0 for symlinks.
+	Linkname string
+	Mode     os.FileMode
+	ModTime  time.Time
+	Size     int64
+}
+
+// ExtractCpio extracts a cpio archive in the newc, crc or odc format. Compressed
+// archives, like .cpio.gz files and initramfs images, are extracted by ExtractGzip and friends.
+func ExtractCpio(xFile *XFile) (int64, []string, error) {
+	return xFile.extractStream(FormatCpio)
+}
+
+func newCpioReader(stream io.Reader) *cpioReader {
+	return &cpioReader{stream: stream}
+}
+
+// isCpioHeader returns true if the header begins with a newc, crc or odc cpio header.
+func isCpioHeader(header []byte) bool {
+	return bytes.HasPrefix(header, []byte(cpioNewcMagic)) ||
+		bytes.HasPrefix(header, []byte(cpioCRCMagic)) ||
+		bytes.HasPrefix(header, []byte(cpioOdcMagic))
+}
+
+// Next skips the rest of the current entry, and returns the header for the next one.
+// Returns io.EOF after the trailer.
+func (c *cpioReader) Next() (*cpioHeader, error) {
+	if _, err := io.CopyN(io.Discard, c.stream, c.remaining+c.pad); err != nil {
+		return nil, fmt.Errorf("reading cpio entry: %w", noEOF(err))
+	}
+
+	magic := make([]byte, len(cpioNewcMagic))
+	if _, err := io.ReadFull(c.stream, magic); err != nil {
+		return nil, fmt.Errorf("reading cpio header: %w", noEOF(err))
+	}
+
+	var (
+		header *cpioHeader
+		err    error
+	)
+
+	switch string(magic) {
+	case cpioNewcMagic, cpioCRCMagic:
+		header, err = c.readNewc(string(magic) == cpioCRCMagic)
+	case cpioOdcMagic:
+		header, err = c.readOdc()
+	default:
+		return nil, fmt.Errorf("%w: bad cpio magic: %q", ErrInvalidHead, magic)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if header.Name == cpioTrailer {
+		return nil, io.EOF
+	}
+
+	if header.Mode&os.ModeSymlink != 0 {
+		return header, c.readLink(header)
+	}
+
+	return header, nil
+}
+
+// readNewc reads the rest of a newc or crc header, after the magic.
+func (c *cpioReader) readNewc(crc bool) (*cpioHeader, error) {
+	block := make([]byte, cpioNewcHeaderSize-len(cpioNewcMagic))
+	if _, err := io.ReadFull(c.stream, block); err != nil {
+		return nil, fmt.Errorf("reading cpio header: %w", noEOF(err))
+	}
+
+	fields := make([]uint64, 13) //nolint:gomnd // ino, mode, uid, gid, nlink, mtime, filesize, 4x dev, namesize, check.
+	for idx := range fields {
+		value, err := strconv.ParseUint(string(block[idx*8:idx*8+8]), 16, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad cpio header field: %v", ErrInvalidHead, err) //nolint:errorlint
+		}
+
+		fields[idx] = value
+	}
+
+	// The name and the data are each padded to a multiple of 4 bytes.
+	name, err := c.readName(int64(fields[11]), pad4(cpioNewcHeaderSize+int64(fields[11])))
+	if err != nil {
+		return nil, err
+	}
+
+	header := &cpioHeader{
+		Name:    name,
+		Mode:    unixMode(uint32(fields[1])),
+		ModTime: time.Unix(int64(fields[5]), 0),
+		Size:    int64(fields[6]),
+	}
+
+	// Only regular files have a checksum.
+	c.remaining, c.pad = header.Size, pad4(header.Size)
+	c.crc, c.check, c.sum = crc && header.Mode.IsRegular(), uint32(fields[12]), 0
+
+	return header, nil
+}
+
+// readOdc reads the rest of an odc header, after the magic. odc has no padding.
+func (c *cpioReader) readOdc() (*cpioHeader, error) {
+	block := make([]byte, cpioOdcHeaderSize-len(cpioOdcMagic))
+	if _, err := io.ReadFull(c.stream, block); err != nil {
+		return nil, fmt.Errorf("reading cpio header: %w", noEOF(err))
+	}
+
+	// dev, ino, mode, uid, gid, nlink, rdev, mtime, namesize, filesize.
+	widths := []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11} //nolint:gomnd
+	fields := make([]uint64, len(widths))
+
+	for idx, start := 0, 0; idx < len(widths); start, idx = start+widths[idx], idx+1 {
+		value, err := strconv.ParseUint(string(block[start:start+widths[idx]]), 8, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: bad cpio header field: %v", ErrInvalidHead, err) //nolint:errorlint
+		}
+
+		fields[idx] = value
+	}
+
+	name, err := c.readName(int64(fields[8]), 0)
+	if err != nil {
+		return nil, err
+	}
+
+	c.remaining, c.pad, c.crc = int64(fields[9]), 0, false
+
+	return &cpioHeader{
+		Name:    name,
+		Mode:    unixMode(uint32(fields[2])),
+		ModTime: time.Unix(int64(fields[7]), 0),
+		Size:    c.remaining,
+	}, nil
+}
+
+// readName reads an entry's name, and the padding after it.
+func (c *cpioReader) readName(size, pad int64) (string, error) {
+	if size > cpioMaxName {
+		return "", fmt.Errorf("%w: cpio name is %d bytes", ErrInvalidHead, size)
+	}
+
+	name := make([]byte, size+pad)
+	if _, err := io.ReadFull(c.stream, name); err != nil {
+		return "", fmt.Errorf("reading cpio name: %w", noEOF(err))
+	}
+
+	return string(bytes.TrimRight(name[:size], "\x00")), nil
+}
+
+// readLink moves a symlink's target from its data into the header, like tar headers have it.
+func (c *cpioReader) readLink(header *cpioHeader) error {
+	if header.Size > cpioMaxName {
+		return fmt.Errorf("%w: cpio symlink target is %d bytes", ErrInvalidHead, header.Size)
+	}
+
+	target, err := io.ReadAll(c)
+	if err != nil {
+		return fmt.Errorf("reading cpio symlink: %w", err)
+	}
+
+	header.Linkname, header.Size = string(target), 0
+
+	return nil
+}
+
+// Read reads the data in the current entry. For crc archives, the checksum is
+// verified after the last byte, and a mismatch returns ErrCorruptArchive.
+func (c *cpioReader) Read(data []byte) (int, error) {
+	if c.remaining <= 0 {
+		return 0, io.EOF
+	}
+
+	if int64(len(data)) > c.remaining {
+		data = data[:c.remaining]
+	}
+
+	size, err := c.stream.Read(data)
+	c.remaining -= int64(size)
+
+	if c.crc {
+		for _, b := range data[:size] {
+			c.sum += uint32(b)
+		}
+
+		if c.remaining == 0 && c.sum != c.check {
+			return size, fmt.Errorf("%w: cpio checksum mismatch", ErrCorruptArchive)
+		}
+	}
+
+	if errors.Is(err, io.EOF) && c.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+
+	return size, err //nolint:wrapcheck
+}
+
+// pad4 returns the bytes needed to pad a size to a multiple of 4.
+func pad4(size int64) int64 {
+	return (4 - size%4) % 4 //nolint:gomnd
+}
+
+// unixMode converts a Unix st_mode to an os.FileMode, the same way tar.Header.FileInfo does.
+func unixMode(mode uint32) os.FileMode {
+	fileMode := os.FileMode(mode) & os.ModePerm
+
+	for bit, set := range map[uint32]os.FileMode{unixSetuid: os.ModeSetuid, unixSetgid: os.ModeSetgid, unixSticky: os.ModeSticky} {
+		if mode&bit != 0 {
+			fileMode |= set
+		}
+	}
+
+	switch mode & unixTypeMask {
+	case unixTypeRegular:
+	case unixTypeDir:
+		fileMode |= os.ModeDir
+	case unixTypeSymlink:
+		fileMode |= os.ModeSymlink
+	case unixTypeChar:
+		fileMode |= os.ModeDevice | os.ModeCharDevice
+	case unixTypeBlock:
+		fileMode |= os.ModeDevice
+	case unixTypeFIFO:
+		fileMode |= os.ModeNamedPipe
+	case unixTypeSocket:
+		fileMode |= os.ModeSocket
+	default:
+		fileMode |= os.ModeIrregular
+	}
+
+	return fileMode
+}
+
+// uncpio extracts the files in a cpio stream, with the same rules untar uses.
+func (x *XFile) uncpio(stream io.Reader) (int64, []string, error) {
+	reader := newCpioReader(stream)
+	files := []string{}
+	size := int64(0)
+
+	for {
+		header, err := reader.Next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return size, files, nil
+		case err != nil:
+			return size, files, fmt.Errorf("%s: cpioReader.Next: %w", x.FilePath, err)
+		}
+
+		wfile, fSize, err := x.writeEntry(header.Name, header.Mode, reader)
+		if err != nil {
+			return size, files, err
+		}
+
+		if wfile != "" {
+			files = append(files, wfile)
+			size += fSize
+		}
+	}
+}
+
+// listCpio lists the entries in a cpio stream.
+func (x *XFile) listCpio(stream io.Reader) ([]Entry, error) {
+	reader := newCpioReader(stream)
+	entries := []Entry{}
+
+	for {
+		header, err := reader.Next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return entries, nil
+		case err != nil:
+			return entries, fmt.Errorf("%s: cpioReader.Next: %w", x.FilePath, err)
+		}
+
+		entries = append(entries, newEntry(header.Name, header.Size, -1, header.Mode, header.ModTime))
+	}
+}
+
+// testCpio reads every entry in a cpio stream, then drains the stream
+// so a compression layer underneath gets to verify its trailer.
+func (x *XFile) testCpio(stream io.Reader) (int64, []string, error) {
+	reader := newCpioReader(stream)
+	failed := &IntegrityError{Archive: x.FilePath}
+	size := int64(0)
+
+	for {
+		header, err := reader.Next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			if _, err := io.Copy(io.Discard, stream); err != nil {
+				failed.add(filepath.Base(x.FilePath), err)
+			}
+
+			return size, []string{x.FilePath}, failed.err()
+		case err != nil:
+			return size, []string{x.FilePath}, fmt.Errorf("%s: cpioReader.Next: %w", x.FilePath, err)
+		}
+
+		size += failed.discard(header.Name, reader)
+	}
+}
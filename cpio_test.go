@@ -0,0 +1,135 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+type cpioFile struct {
+	name string
+	mode uint32
+	data string
+}
+
+//nolint:gochecknoglobals
+var testCpioFiles = []cpioFile{
+	{name: ".", mode: 0o40755},
+	{name: "bin", mode: 0o40755},
+	{name: "bin/init", mode: 0o100755, data: "#!/bin/sh\nexec /bin/busybox sh\n"},
+	{name: "bin/sh", mode: 0o120777, data: "busybox"},
+	{name: "dev/console", mode: 0o20600},
+	{name: "etc/hostname", mode: 0o100644, data: "initramfs\n"},
+}
+
+func TestExtractCpio(t *testing.T) {
+	t.Parallel()
+
+	for _, magic := range []string{"070701", "070702", "070707"} {
+		dir := t.TempDir()
+		cpio := filepath.Join(dir, "initramfs.cpio")
+		assert.NoError(t, os.WriteFile(cpio, makeCpio(magic, testCpioFiles), 0o600))
+
+		format, err := xtractr.DetectFormat(cpio)
+		assert.NoError(t, err, magic)
+		assert.Equal(t, xtractr.FormatCpio, format, magic)
+
+		entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: cpio})
+		assert.NoError(t, err, magic)
+		assert.Len(t, entries, len(testCpioFiles), magic)
+		assert.Equal(t, int64(0), entries[3].Size, "symlink targets are not data")
+		assert.Equal(t, os.ModeSymlink, entries[3].Mode&os.ModeSymlink, magic)
+
+		// Links and devices are written as empty files, like untar writes them.
+		size, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: cpio, OutputDir: filepath.Join(dir, "out")})
+		assert.NoError(t, err, magic)
+		assert.Equal(t, int64(len(testCpioFiles[2].data)+len(testCpioFiles[5].data)), size, magic)
+		assert.Equal(t, []string{
+			filepath.Join(dir, "out", "bin", "init"),
+			filepath.Join(dir, "out", "bin", "sh"),
+			filepath.Join(dir, "out", "dev", "console"),
+			filepath.Join(dir, "out", "etc", "hostname"),
+		}, files, magic)
+
+		data, err := os.ReadFile(filepath.Join(dir, "out", "etc", "hostname"))
+		assert.NoError(t, err, magic)
+		assert.Equal(t, "initramfs\n", string(data), magic)
+
+		_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: cpio})
+		assert.NoError(t, err, magic)
+	}
+}
+
+func TestCpioChecksum(t *testing.T) {
+	t.Parallel()
+
+	cpio := filepath.Join(t.TempDir(), "bad.cpio")
+	data := makeCpio("070702", testCpioFiles)
+	data[bytes.Index(data, []byte("initramfs\n"))] = 'X'
+	assert.NoError(t, os.WriteFile(cpio, data, 0o600))
+
+	_, _, err := xtractr.TestFile(&xtractr.XFile{FilePath: cpio})
+	assert.ErrorIs(t, err, xtractr.ErrCorruptArchive)
+}
+
+func TestFindCompressedCpio(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cpio := filepath.Join(dir, "initrd.cpio.gz")
+	buf := &bytes.Buffer{}
+	assert.NoError(t, writeCompressed(buf, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		makeCpio("070701", testCpioFiles)))
+	assert.NoError(t, os.WriteFile(cpio, buf.Bytes(), 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "plain.cpio"), makeCpio("070707", testCpioFiles), 0o600))
+
+	found := xtractr.FindCompressedFiles(xtractr.Filter{Path: dir})
+	assert.ElementsMatch(t, []string{cpio, filepath.Join(dir, "plain.cpio")}, found[dir])
+
+	_, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: cpio, OutputDir: filepath.Join(dir, "out")})
+	assert.NoError(t, err)
+	assert.Contains(t, files, filepath.Join(dir, "out", "bin", "init"))
+}
+
+// makeCpio encodes files as a cpio archive: 070701 is newc, 070702 is crc and 070707 is odc.
+func makeCpio(magic string, files []cpioFile) []byte {
+	buf := &bytes.Buffer{}
+	pad := func() {
+		for magic != "070707" && buf.Len()%4 != 0 {
+			buf.WriteByte(0)
+		}
+	}
+
+	for idx, file := range append(files, cpioFile{name: "TRAILER!!!"}) {
+		check := 0
+		for _, b := range []byte(file.data) {
+			check += int(b)
+		}
+
+		if magic == "070707" {
+			fmt.Fprintf(buf, "070707%06o%06o%06o%06o%06o%06o%06o%011o%06o%011o",
+				0, idx+1, file.mode, 0, 0, 1, 0, 1700000000, len(file.name)+1, len(file.data))
+		} else {
+			if magic != "070702" || file.mode&0o170000 != 0o100000 {
+				check = 0
+			}
+
+			fmt.Fprintf(buf, "%s%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X%08X",
+				magic, idx+1, file.mode, 0, 0, 1, 1700000000, len(file.data), 0, 0, 0, 0, len(file.name)+1, check)
+		}
+
+		buf.WriteString(file.name + "\x00")
+		pad()
+		buf.WriteString(file.data)
+		pad()
+	}
+
+	return buf.Bytes()
+}
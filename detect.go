@@ -0,0 +1,102 @@
+package xtractr
+
+/* Code to identify archives by their content instead of their file name. */
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Format is an archive or compression type identifier.
+// DetectFormat returns one of these after inspecting a file's header.
+type Format string
+
+// Formats that can be detected by this library.
+const (
+	FormatUnknown   Format = ""
+	FormatZIP       Format = "zip"
+	FormatRAR       Format = "rar"
+	FormatRAR5      Format = "rar5"
+	Format7z        Format = "7z"
+	FormatGzip      Format = "gzip"
+	FormatTarGzip   Format = "tar.gz"
+	FormatBzip2     Format = "bzip2"
+	FormatTarBzip2  Format = "tar.bz2"
+	FormatXZ        Format = "xz"
+	FormatTarXZ     Format = "tar.xz"
+	FormatZstd      Format = "zstd"
+	FormatTarZstd   Format = "tar.zst"
+	FormatLZ4       Format = "lz4"
+	FormatTarLZ4    Format = "tar.lz4"
+	FormatBrotli    Format = "brotli"
+	FormatTarBrotli Format = "tar.br"
+	FormatLZMA      Format = "lzma"
+	FormatTarLZMA   Format = "tar.lzma"
+	FormatISO       Format = "iso"
+	FormatTar       Format = "tar"
+	FormatAr        Format = "ar"
+	FormatRPM       Format = "rpm"
+	FormatCpio      Format = "cpio"
+	FormatCAB       Format = "cab"
+	FormatSquashFS  Format = "squashfs"
+)
+
+// headerSize is how much of a file is read to detect its format.
+// ISO9660 images have their signature at 0x8001, so it must cover that.
+const headerSize = 0x8800
+
+// DetectFormat opens a file and returns its format based on the signature
+// (magic bytes) in its header. Returns FormatUnknown if nothing matches.
+// Compressed streams are peeked into to find out if they contain a tarball.
+func DetectFormat(path string) (Format, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, fmt.Errorf("os.Open: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, headerSize)
+
+	n, err := io.ReadFull(file, header)
+	if err != nil && n == 0 {
+		return FormatUnknown, fmt.Errorf("reading header: %s: %w", path, err)
+	}
+
+	return DetectHeader(header[:n]), nil
+}
+
+// DetectHeader returns the format of the data in header, which should
+// be the first bytes of a file. Provide at least 34KB to detect ISO images.
+// The registry is not locked while an Extractor's Detect method runs.
+func DetectHeader(header []byte) Format {
+	for _, reg := range registrations() {
+		if reg.matches(header) {
+			return reg.format
+		}
+	}
+
+	return FormatUnknown
+}
+
+// FormatFromName returns the format for a file based on its name (suffix).
+// The format with the longest matching suffix wins, so .tar.gz beats .gz.
+func FormatFromName(path string) Format {
+	var (
+		lowerName = strings.ToLower(path)
+		format    = FormatUnknown
+		longest   = 0
+	)
+
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	for _, reg := range registry {
+		if l := reg.suffixLen(lowerName); l > longest {
+			format, longest = reg.format, l
+		}
+	}
+
+	return format
+}
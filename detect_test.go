@@ -0,0 +1,106 @@
+package xtractr_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectFormat(t *testing.T) {
+	t.Parallel()
+
+	name := t.TempDir()
+	zipFile := filepath.Join(name, "really_a_zip.rar")
+	tgzFile := filepath.Join(name, "payload.bin")
+
+	assert.NoError(t, makeZipFile(zipFile, map[string]string{"a.txt": "hello"}))
+	assert.NoError(t, makeTarGzFile(tgzFile, map[string]string{"b.txt": "world"}))
+
+	format, err := xtractr.DetectFormat(zipFile)
+	assert.NoError(t, err)
+	assert.Equal(t, xtractr.FormatZIP, format, "the header should win over the suffix")
+
+	format, err = xtractr.DetectFormat(tgzFile)
+	assert.NoError(t, err)
+	assert.Equal(t, xtractr.FormatTarGzip, format, "a tarball inside gzip should be detected")
+
+	format, err = xtractr.DetectFormat(testFile)
+	assert.NoError(t, err)
+	assert.Equal(t, xtractr.FormatRAR5, format)
+
+	size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:  zipFile,
+		OutputDir: filepath.Join(name, "out"),
+		FileMode:  xtractr.DefaultFileMode,
+		DirMode:   xtractr.DefaultDirMode,
+	})
+	assert.NoError(t, err, "a zip named .rar must extract")
+	assert.Equal(t, int64(5), size)
+	assert.Equal(t, []string{zipFile}, archives)
+	assert.Equal(t, 1, len(files))
+
+	found := xtractr.FindCompressedFiles(xtractr.Filter{Path: name})
+	assert.Equal(t, []string{zipFile}, found[name], "only the suffix matches without DetectByContent")
+
+	found = xtractr.FindCompressedFiles(xtractr.Filter{Path: name, DetectByContent: true})
+	assert.ElementsMatch(t, []string{zipFile, tgzFile}, found[name], "content detection must find the .bin file")
+}
+
+// makeZipFile writes a zip archive containing the provided file names and contents.
+func makeZipFile(fileName string, contents map[string]string) error {
+	openFile, err := os.Create(fileName)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer openFile.Close()
+
+	zipWriter := zip.NewWriter(openFile)
+
+	for name, data := range contents {
+		writer, err := zipWriter.Create(name)
+		if err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if _, err = writer.Write([]byte(data)); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	return zipWriter.Close() //nolint:wrapcheck
+}
+
+// makeTarGzFile writes a gzip compressed tar archive containing the provided file names and contents.
+func makeTarGzFile(fileName string, contents map[string]string) error {
+	openFile, err := os.Create(fileName)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer openFile.Close()
+
+	gzipWriter := gzip.NewWriter(openFile)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for name, data := range contents {
+		header := &tar.Header{Name: name, Mode: xtractr.DefaultFileMode, Size: int64(len(data)), Typeflag: tar.TypeReg}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err //nolint:wrapcheck
+		}
+
+		if _, err := tarWriter.Write([]byte(data)); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return gzipWriter.Close() //nolint:wrapcheck
+}
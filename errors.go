@@ -0,0 +1,206 @@
+package xtractr
+
+/* Code to turn errors from the archive libraries into errors callers can check. */
+
+import (
+	"archive/tar"
+	stdzip "archive/zip"
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/fs"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/nwaples/rardecode/v2"
+	"github.com/pierrec/lz4/v4"
+	"github.com/yeka/zip"
+)
+
+// ArchiveError wraps an error from an archive library with the kind of problem it represents:
+// ErrWrongPassword, ErrEncrypted, ErrCorruptArchive, ErrMissingVolume, ErrTruncated or
+// ErrUnsupportedMethod. errors.Is matches the kind and the wrapped error, and errors.As
+// reaches the library's own error types.
+type ArchiveError struct {
+	Kind error
+	Err  error
+}
+
+func (e *ArchiveError) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Is allows errors.Is to match the kind of error.
+func (e *ArchiveError) Is(target error) bool {
+	return target == e.Kind //nolint:errorlint,goerr113
+}
+
+// Unwrap returns the library error.
+func (e *ArchiveError) Unwrap() error {
+	return e.Err
+}
+
+// archiveError wraps err in an *ArchiveError if the kind of problem is known.
+// Other errors, including limits and cancellations, are returned unchanged.
+// Set password to true if a password was provided, so a password error is
+// reported as ErrWrongPassword instead of ErrEncrypted.
+func archiveError(err error, password bool) error {
+	var archiveErr *ArchiveError
+	if err == nil || isStopError(err) || errors.As(err, &archiveErr) {
+		return err
+	}
+
+	kind := errorKind(err)
+	switch {
+	case kind == nil:
+		return err
+	case !password && (kind == ErrWrongPassword || kind == ErrEncrypted):
+		kind = ErrEncrypted
+	case password && kind == ErrEncrypted:
+		kind = ErrWrongPassword
+	}
+
+	return &ArchiveError{Kind: kind, Err: err}
+}
+
+// wrongPassword reports ErrEncrypted as ErrWrongPassword. Used after every password
+// was tried, and the last attempt without a password says the archive needs one.
+// The error is wrapped, not changed, so its message matches its kind.
+func wrongPassword(err error) error {
+	if failed := (*IntegrityError)(nil); errors.As(err, &failed) {
+		changed := &IntegrityError{Archive: failed.Archive, Entries: make([]EntryError, len(failed.Entries))}
+		for idx, entry := range failed.Entries {
+			changed.Entries[idx] = EntryError{Name: entry.Name, Err: wrongPassword(entry.Err)}
+		}
+
+		return changed
+	}
+
+	if !errors.Is(err, ErrEncrypted) || errors.Is(err, ErrWrongPassword) {
+		return err
+	}
+
+	if archiveErr, ok := err.(*ArchiveError); ok { //nolint:errorlint
+		return &ArchiveError{Kind: ErrWrongPassword, Err: archiveErr.Err}
+	}
+
+	return &ArchiveError{Kind: ErrWrongPassword, Err: err}
+}
+
+// volumeError reports a file that disappears after an archive was opened as a missing volume.
+// Multi-volume readers open the next volume when they reach the end of the current one.
+func volumeError(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return &ArchiveError{Kind: ErrMissingVolume, Err: err}
+	}
+
+	return err
+}
+
+// errorKind returns the kind of problem an archive library error represents, or nil.
+func errorKind(err error) error {
+	var (
+		bzipErr  bzip2.StructuralError
+		flateErr flate.CorruptInputError
+	)
+
+	switch {
+	case errors.Is(err, zip.ErrPassword), errors.Is(err, zip.ErrAuthentication), errors.Is(err, zip.ErrDecryption),
+		errors.Is(err, rardecode.ErrBadPassword):
+		return ErrWrongPassword
+	case errors.Is(err, rardecode.ErrArchiveEncrypted), errors.Is(err, rardecode.ErrArchivedFileEncrypted):
+		return ErrEncrypted
+	case errors.Is(err, rardecode.ErrUnexpectedArcEnd), errors.Is(err, rardecode.ErrShortFile),
+		errors.Is(err, rardecode.ErrDecoderOutOfData):
+		return ErrTruncated
+	case errors.Is(err, rardecode.ErrUnknownDecoder), errors.Is(err, rardecode.ErrUnsupportedDecoder),
+		errors.Is(err, rardecode.ErrUnknownEncryptMethod), errors.Is(err, rardecode.ErrUnknownVersion),
+		errors.Is(err, rardecode.ErrUnknownFilter), errors.Is(err, rardecode.ErrDictionaryTooLarge),
+		errors.Is(err, rardecode.ErrMultipleDecoders), errors.Is(err, rardecode.ErrPlatformIntSize):
+		return ErrUnsupportedMethod
+	case errors.Is(err, rardecode.ErrNoSig), errors.Is(err, rardecode.ErrVerMismatch),
+		errors.Is(err, rardecode.ErrTooManyFilters), errors.Is(err, rardecode.ErrInvalidFilter),
+		errors.Is(err, rardecode.ErrCorruptDecodeHeader), errors.Is(err, rardecode.ErrInvalidFileBlock),
+		errors.Is(err, rardecode.ErrBadFileChecksum), errors.Is(err, rardecode.ErrInvalidVMInstruction),
+		errors.Is(err, rardecode.ErrCorruptPPM), errors.Is(err, rardecode.ErrCorruptBlockHeader),
+		errors.Is(err, rardecode.ErrCorruptFileHeader), errors.Is(err, rardecode.ErrBadHeaderCRC),
+		errors.Is(err, rardecode.ErrCorruptEncryptData), errors.Is(err, rardecode.ErrHuffDecodeFailed),
+		errors.Is(err, rardecode.ErrInvalidLengthTable):
+		return ErrCorruptArchive
+	case errors.Is(err, lz4.ErrInvalidFrame), errors.Is(err, lz4.ErrInvalidHeaderChecksum),
+		errors.Is(err, lz4.ErrInvalidBlockChecksum), errors.Is(err, lz4.ErrInvalidFrameChecksum),
+		errors.Is(err, lz4.ErrInvalidSourceShortBuffer), errors.Is(err, lz4.ErrInternalUnhandledState):
+		return ErrCorruptArchive
+	case errors.Is(err, zip.ErrAlgorithm), errors.Is(err, stdzip.ErrAlgorithm):
+		return ErrUnsupportedMethod
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrTruncated
+	case errors.Is(err, zip.ErrFormat), errors.Is(err, zip.ErrChecksum),
+		errors.Is(err, stdzip.ErrFormat), errors.Is(err, stdzip.ErrChecksum),
+		errors.Is(err, gzip.ErrHeader), errors.Is(err, gzip.ErrChecksum),
+		errors.Is(err, tar.ErrHeader), errors.Is(err, ErrChecksum), errors.Is(err, ErrInvalidHead),
+		errors.As(err, &bzipErr), errors.As(err, &flateErr),
+		errors.Is(err, zstd.ErrMagicMismatch), errors.Is(err, zstd.ErrCRCMismatch),
+		errors.Is(err, zstd.ErrReservedBlockType), errors.Is(err, zstd.ErrBlockTooSmall):
+		return ErrCorruptArchive
+	}
+
+	return nil
+}
+
+// sourceReader remembers the last error from reading an archive, so the errors of a
+// library that does not export them can be told apart from errors reading the file.
+type sourceReader struct {
+	io.Reader
+	err error
+}
+
+// decoderReader classifies the errors of a decompressor that reads from a sourceReader.
+type decoderReader struct {
+	io.Reader
+	source *sourceReader
+}
+
+// untypedDecoder opens a decompressor from a library that does not export its errors, like
+// xz, lzma and brotli. Its errors are classified by where they came from. See sourceReader.classify.
+func untypedDecoder(reader io.Reader, open func(io.Reader) (io.Reader, error)) (io.ReadCloser, error) {
+	source := &sourceReader{Reader: reader}
+
+	decoder, err := open(source)
+	if err != nil {
+		return nil, source.classify(err)
+	}
+
+	return io.NopCloser(&decoderReader{Reader: decoder, source: source}), nil
+}
+
+func (s *sourceReader) Read(data []byte) (int, error) {
+	size, err := s.Reader.Read(data)
+	if err != nil {
+		s.err = err
+	}
+
+	return size, err //nolint:wrapcheck
+}
+
+// classify returns an error from the decompressor as ErrTruncated if the file ended first,
+// or ErrCorruptArchive if it did not come from reading the file. Other errors are unchanged.
+func (s *sourceReader) classify(err error) error {
+	switch {
+	case err == nil, errors.Is(err, io.EOF), isStopError(err), errorKind(err) != nil:
+		return err
+	case errors.Is(s.err, io.EOF):
+		return &ArchiveError{Kind: ErrTruncated, Err: err}
+	case s.err != nil && errors.Is(err, s.err):
+		return err
+	default:
+		return &ArchiveError{Kind: ErrCorruptArchive, Err: err}
+	}
+}
+
+func (d *decoderReader) Read(data []byte) (int, error) {
+	size, err := d.Reader.Read(data)
+
+	return size, d.source.classify(err)
+}
@@ -0,0 +1,113 @@
+package xtractr_test
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+	yzip "github.com/yeka/zip"
+)
+
+func TestArchiveErrors(t *testing.T) {
+	t.Parallel()
+
+	name := t.TempDir()
+	xFile := &xtractr.XFile{
+		FilePath:  testFile,
+		OutputDir: name,
+		FileMode:  xtractr.DefaultFileMode,
+		DirMode:   xtractr.DefaultDirMode,
+	}
+
+	_, _, _, err := xtractr.ExtractFile(xFile)
+	assert.ErrorIs(t, err, xtractr.ErrEncrypted, "no password was provided")
+
+	xFile.Passwords = []string{"wrong", "also_wrong"}
+	_, _, _, err = xtractr.ExtractFile(xFile)
+	assert.ErrorIs(t, err, xtractr.ErrWrongPassword)
+
+	var archiveErr *xtractr.ArchiveError
+	assert.True(t, errors.As(err, &archiveErr), "the error must be an *ArchiveError")
+	assert.True(t, strings.HasPrefix(err.Error(), xtractr.ErrWrongPassword.Error()),
+		"the message must say the password was wrong: %v", err)
+
+	// The zip's last attempt has no password; its entries still report a wrong password.
+	secret := filepath.Join(name, "secret.zip")
+	assert.NoError(t, makeEncryptedZip(secret, "secret", yzip.StandardEncryption))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: secret, Passwords: []string{"wrong"}})
+	assert.ErrorIs(t, err, xtractr.ErrWrongPassword)
+	assert.Contains(t, err.Error(), "secret.txt: "+xtractr.ErrWrongPassword.Error())
+	assert.NotContains(t, err.Error(), xtractr.ErrEncrypted.Error())
+
+	xFile.Passwords = append(xFile.Passwords, "some_password")
+	_, _, _, err = xtractr.ExtractFile(xFile)
+	assert.NoError(t, err, "the last password is correct")
+
+	// Cut a tarball in half.
+	tarball := filepath.Join(name, "short.tar.gz")
+	assert.NoError(t, makeTarGzFile(tarball, map[string]string{"file.txt": string(make([]byte, 64*1024))}))
+	data, err := os.ReadFile(tarball)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(tarball, data[:len(data)/2], xtractr.DefaultFileMode))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: tarball})
+	assert.ErrorIs(t, err, xtractr.ErrTruncated)
+
+	// Damage the gzip trailer's checksum.
+	assert.NoError(t, makeTarGzFile(tarball, map[string]string{"file.txt": "some data"}))
+	data, err = os.ReadFile(tarball)
+	assert.NoError(t, err)
+	data[len(data)-8] ^= 0xff
+	assert.NoError(t, os.WriteFile(tarball, data, xtractr.DefaultFileMode))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: tarball})
+	assert.ErrorIs(t, err, xtractr.ErrCorruptArchive)
+}
+
+func TestLibraryErrors(t *testing.T) {
+	t.Parallel()
+
+	// The 7zip library does not export its errors; the archive's header says it's encrypted.
+	sevenZip := &xtractr.XFile{FilePath: "test_data/encrypted.7z", OutputDir: t.TempDir()}
+	_, _, _, err := xtractr.ExtractFile(sevenZip)
+	assert.ErrorIs(t, err, xtractr.ErrEncrypted)
+
+	sevenZip.Password = "wrong"
+	_, _, _, err = xtractr.ExtractFile(sevenZip)
+	assert.ErrorIs(t, err, xtractr.ErrWrongPassword)
+
+	sevenZip.Password = "password"
+	_, _, _, err = xtractr.ExtractFile(sevenZip)
+	assert.NoError(t, err)
+
+	// Neither does xz. Its errors are classified by where they came from.
+	data := make([]byte, 64*1024)
+	for idx := range data {
+		data[idx] = byte(idx * idx >> 3)
+	}
+
+	compressed := filepath.Join(t.TempDir(), "file.txt.xz")
+	assert.NoError(t, makeCompressedFile(compressed, func(w io.Writer) (io.WriteCloser, error) {
+		return xz.NewWriter(w)
+	}, data))
+
+	data, err = os.ReadFile(compressed)
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(compressed, data[:len(data)/2], xtractr.DefaultFileMode))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: compressed})
+	assert.ErrorIs(t, err, xtractr.ErrTruncated)
+
+	data[len(data)/2] ^= 0xff
+	assert.NoError(t, os.WriteFile(compressed, data, xtractr.DefaultFileMode))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: compressed})
+	assert.ErrorIs(t, err, xtractr.ErrCorruptArchive)
+}
@@ -3,12 +3,15 @@ package xtractr
 /* Code to find, write, move and delete files. */
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
 )
 
 // XFile defines the data needed to extract an archive.
@@ -21,10 +24,44 @@ type XFile struct {
 	FileMode os.FileMode
 	// Write folders with this mode.
 	DirMode os.FileMode
-	// (RAR/7z) Archive password. Blank for none. Gets prepended to Passwords, below.
+	// (RAR/7z/ZIP) Archive password. Blank for none. Gets prepended to Passwords, below.
 	Password string
-	// (RAR/7z) Archive passwords (to try multiple).
+	// (RAR/7z/ZIP) Archive passwords (to try multiple).
 	Passwords []string
+	// PasswordProvider is asked for more passwords to try, after Password and Passwords. Optional.
+	PasswordProvider PasswordProvider
+	// passwords remembers which passwords worked in each folder. Shared by a queued Xtract's archives.
+	passwords *passwordCache
+	// tries is the password list for this archive, made once so the PasswordProvider is asked once.
+	tries []string
+	// Limits protect against hostile archives (zip bombs). Zero values mean no limit.
+	Limits
+	// (deb) Set ExpandDeb to true to extract the data and control tarballs inside a Debian
+	// package, instead of writing them as files. The data goes into OutputDir, and the
+	// control files go into a DEBIAN folder in it, like dpkg-deb --raw-extract.
+	ExpandDeb bool
+	// (ZIP) NameCharset decodes names that are not marked as UTF-8. Use a character set name
+	// like cp437, gbk, shift_jis or cp866, or NameCharsetAuto to guess. Blank uses the
+	// names as UTF-8 if they all are valid UTF-8, and decodes them with cp437 if not.
+	NameCharset string
+	// NamePolicy changes the names of the files written, so they work on any file system.
+	NamePolicy NamePolicy
+	// Set SFX to true to read self-extracting archives: programs with a zip, rar or 7z
+	// archive appended. Without it, a program is not an archive. ExtractSFX does not need it.
+	SFX bool
+	// Progress is called while files are written, once per ProgressInterval,
+	// and once more when the archive is finished. Optional.
+	Progress func(Progress)
+	// How often to call Progress. Default is DefaultProgressInterval.
+	ProgressInterval time.Duration
+	// track counts bytes written for the Progress callback.
+	track *tracker
+	// limit tracks data written, so Limits can be enforced. Shared by a queued Xtract's archives.
+	limit *limiter
+	// ctx stops an extraction when it's cancelled. See ExtractFileContext.
+	ctx context.Context //nolint:containedctx
+	// sfxOffset is where the archive starts in a self-extracting archive. See ExtractSFX.
+	sfxOffset int64
 }
 
 // Filter is the input to find compressed files.
@@ -33,8 +70,17 @@ type Filter struct {
 	Path string
 	// Any files with this suffix are ignored. ie. ".7z" or ."iso"
 	ExcludeSuffix Exclude
+	// Set DetectByContent to true to also find archives that do not have a known
+	// suffix, like .bin or .dat files. This reads the header of every such file.
+	DetectByContent bool
+	// Set SFX to true to also find self-extracting archives: .exe files with a zip,
+	// rar or 7z archive appended. This reads the end of every program. See ExtractSFX.
+	SFX bool
 }
 
+// volumeName matches secondary volumes of multi-part archives.
+var volumeName = regexp.MustCompile(`\.(r[0-9]{2,3}|z[0-9]{2,3}|[0-9]{3})$`) //nolint:gochecknoglobals
+
 // Exclude represents an exclusion list.
 type Exclude []string
 
@@ -106,8 +152,8 @@ func FindCompressedFiles(filter Filter) map[string][]string {
 
 	if info, err := dir.Stat(); err != nil {
 		return nil // unreadable folder?
-	} else if l := strings.ToLower(filter.Path); !info.IsDir() &&
-		(strings.HasSuffix(l, ".zip") || strings.HasSuffix(l, ".rar") || strings.HasSuffix(l, ".r00")) {
+	} else if !info.IsDir() && (FormatFromName(filter.Path) != FormatUnknown ||
+		(filter.DetectByContent && hasArchiveContent(filter.Path)) || (filter.SFX && isSFX(filter.Path))) {
 		return map[string][]string{filter.Path: {filter.Path}} // passed in an archive file; send it back out.
 	}
 
@@ -124,6 +170,7 @@ func FindCompressedFiles(filter Filter) map[string][]string {
 }
 
 // getCompressedFiles checks file suffixes to find archives to decompress.
+// Every suffix passed to RegisterFormat is checked.
 // This pays special attention to the widely accepted variance of rar formats.
 func getCompressedFiles(hasrar bool, filter Filter, fileList []os.FileInfo) map[string][]string { //nolint:cyclop
 	files := map[string][]string{}
@@ -137,16 +184,13 @@ func getCompressedFiles(hasrar bool, filter Filter, fileList []os.FileInfo) map[
 			continue // ignore empty names and dot files/folders.
 		case file.IsDir(): // Recurse.
 			for k, v := range FindCompressedFiles(Filter{
-				Path:          filepath.Join(path, file.Name()),
-				ExcludeSuffix: filter.ExcludeSuffix,
+				Path:            filepath.Join(path, file.Name()),
+				ExcludeSuffix:   filter.ExcludeSuffix,
+				DetectByContent: filter.DetectByContent,
+				SFX:             filter.SFX,
 			}) {
 				files[k] = v
 			}
-		case strings.HasSuffix(lowerName, ".zip") || strings.HasSuffix(lowerName, ".tar") ||
-			strings.HasSuffix(lowerName, ".tgz") || strings.HasSuffix(lowerName, ".gz") ||
-			strings.HasSuffix(lowerName, ".bz2") || strings.HasSuffix(lowerName, ".7z") ||
-			strings.HasSuffix(lowerName, ".7z.001") || strings.HasSuffix(lowerName, ".iso"):
-			files[path] = append(files[path], filepath.Join(path, file.Name()))
 		case strings.HasSuffix(lowerName, ".rar"):
 			hasParts := regexp.MustCompile(`.*\.part[0-9]+\.rar$`)
 			partOne := regexp.MustCompile(`.*\.part0*1\.rar$`)
@@ -154,9 +198,22 @@ func getCompressedFiles(hasrar bool, filter Filter, fileList []os.FileInfo) map[
 			if !hasParts.Match([]byte(lowerName)) || partOne.Match([]byte(lowerName)) {
 				files[path] = append(files[path], filepath.Join(path, file.Name()))
 			}
-
-		case !hasrar && strings.HasSuffix(lowerName, ".r00"):
-			// Accept .r00 as the first archive file if no .rar files are present in the path.
+		case strings.HasSuffix(lowerName, ".r00"):
+			if !hasrar {
+				// Accept .r00 as the first archive file if no .rar files are present in the path.
+				files[path] = append(files[path], filepath.Join(path, file.Name()))
+			}
+		case strings.HasSuffix(lowerName, ".cab"):
+			// Only return the first cabinet in a set. ExtractCAB reads the rest with it.
+			if !isCabContinuation(filepath.Join(path, file.Name())) {
+				files[path] = append(files[path], filepath.Join(path, file.Name()))
+			}
+		case FormatFromName(lowerName) != FormatUnknown:
+			files[path] = append(files[path], filepath.Join(path, file.Name()))
+		case filter.SFX && strings.HasSuffix(lowerName, ".exe") && isSFX(filepath.Join(path, file.Name())):
+			files[path] = append(files[path], filepath.Join(path, file.Name()))
+		case filter.DetectByContent && !isVolumeName(lowerName) && hasArchiveContent(filepath.Join(path, file.Name())):
+			// Unknown suffix, but the header says it's an archive.
 			files[path] = append(files[path], filepath.Join(path, file.Name()))
 		}
 	}
@@ -164,46 +221,76 @@ func getCompressedFiles(hasrar bool, filter Filter, fileList []os.FileInfo) map[
 	return files
 }
 
+// hasArchiveContent returns true if the file's header matches a known archive signature.
+func hasArchiveContent(path string) bool {
+	format, err := DetectFormat(path)
+
+	return err == nil && format != FormatUnknown
+}
+
+// isVolumeName returns true if the (lowercased) name looks like a secondary volume
+// in a multi-part archive, ie. name.r01, name.7z.002 or name.z01. These are never
+// returned as archives on their own, even if their content has a signature.
+func isVolumeName(lowerName string) bool {
+	return volumeName.MatchString(lowerName)
+}
+
 // Extract calls the correct procedure for the type of file being extracted.
 // Returns size of extracted data, list of extracted files, and/or error.
 func (x *XFile) Extract() (int64, []string, []string, error) {
 	return ExtractFile(x)
 }
 
+// ExtractFileContext is ExtractFile with a context. The extraction stops between files,
+// and while writing a file, when the context is cancelled or its deadline passes.
+// The context's error is returned and the files written so far are removed.
+func ExtractFileContext(ctx context.Context, xFile *XFile) (int64, []string, []string, error) {
+	withContext := *xFile
+	withContext.ctx = ctx
+
+	return ExtractFile(&withContext)
+}
+
 // ExtractFile calls the correct procedure for the type of file being extracted.
+// The type is detected from the file's content. The file name suffix is only
+// used when the content does not match a known signature. See RegisterFormat.
 // Returns size of extracted data, list of extracted files, list of archives processed, and/or error.
-func ExtractFile(xFile *XFile) (int64, []string, []string, error) { //nolint:cyclop
-	var (
-		size  int64
-		files []string
-		err   error
-	)
+// If a limit is crossed, the files written so far are removed.
+func ExtractFile(xFile *XFile) (int64, []string, []string, error) {
+	reg, err := registrationFor(xFile.FilePath, xFile.SFX)
+	if err != nil {
+		return 0, nil, nil, err
+	}
 
-	switch sName := strings.ToLower(xFile.FilePath); {
-	case strings.HasSuffix(sName, ".rar"), strings.HasSuffix(sName, ".r00"):
-		return ExtractRAR(xFile)
-	case strings.HasSuffix(sName, ".7z"), strings.HasSuffix(sName, ".7z.001"):
-		return Extract7z(xFile)
-	case strings.HasSuffix(sName, ".zip"):
-		size, files, err = ExtractZIP(xFile)
-	case strings.HasSuffix(sName, ".tar.gz"), strings.HasSuffix(sName, ".tgz"):
-		size, files, err = ExtractTarGzip(xFile)
-	case strings.HasSuffix(sName, ".tar.bz2"), strings.HasSuffix(sName, ".tbz2"),
-		strings.HasSuffix(sName, ".tbz"), strings.HasSuffix(sName, ".tar.bz"):
-		size, files, err = ExtractTarBzip(xFile)
-	case strings.HasSuffix(sName, ".bz"), strings.HasSuffix(sName, ".bz2"):
-		size, files, err = ExtractBzip(xFile)
-	case strings.HasSuffix(sName, ".gz"):
-		size, files, err = ExtractGzip(xFile)
-	case strings.HasSuffix(sName, ".iso"):
-		size, files, err = ExtractISO(xFile)
-	case strings.HasSuffix(sName, ".tar"):
-		size, files, err = ExtractTar(xFile)
-	default:
-		return 0, nil, nil, fmt.Errorf("%w: %s", ErrUnknownArchiveType, xFile.FilePath)
+	if err := xFile.context().Err(); err != nil {
+		return 0, nil, nil, fmt.Errorf("%s: %w", xFile.FilePath, err)
 	}
 
-	return size, files, []string{xFile.FilePath}, err
+	// Find missing volumes before anything is written, instead of halfway through.
+	if set, err := ScanVolumes(xFile.FilePath); errors.Is(err, ErrMissingVolume) {
+		return 0, nil, set.Volumes, fmt.Errorf("%s: %w", xFile.FilePath, err)
+	}
+
+	xFile.tries = xFile.passwordList()
+	defer func() { xFile.tries = nil }()
+
+	xFile.limiter().startArchive(xFile.FilePath)
+	xFile.tracker().startArchive(xFile, reg)
+
+	size, files, archives, err := reg.extractor.Extract(xFile)
+	err = archiveError(err, xFile.hasPassword())
+
+	xFile.tracker().finish()
+
+	if isStopError(err) {
+		for _, file := range files {
+			os.Remove(file)
+		}
+
+		return size, nil, archives, err
+	}
+
+	return size, files, archives, err
 }
 
 // MoveFiles relocates files then removes the folder they were in.
@@ -271,7 +358,16 @@ func (x *Xtractr) DeleteFiles(files ...string) {
 }
 
 // writeFile writes a file from an io reader, making sure all parent directories exist.
-func writeFile(fpath string, fdata io.Reader, fMode, dMode os.FileMode) (int64, error) {
+// Limits and the context are checked here; a file that is stopped part way is removed.
+func (x *XFile) writeFile(fpath string, fdata io.Reader, fMode, dMode os.FileMode) (int64, error) {
+	if err := x.context().Err(); err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	if err := x.limiter().addEntry(); err != nil {
+		return 0, err
+	}
+
 	if err := os.MkdirAll(filepath.Dir(fpath), dMode); err != nil {
 		return 0, fmt.Errorf("os.MkdirAll: %w", err)
 	}
@@ -282,8 +378,13 @@ func writeFile(fpath string, fdata io.Reader, fMode, dMode os.FileMode) (int64,
 	}
 	defer fout.Close()
 
-	s, err := io.Copy(fout, fdata)
+	s, err := io.Copy(&limitWriter{Writer: fout, limit: x.limiter(), ctx: x.context()}, x.reader(fpath, fdata))
 	if err != nil {
+		if isStopError(err) {
+			fout.Close()
+			os.Remove(fpath)
+		}
+
 		return s, fmt.Errorf("copying io: %w", err)
 	}
 
@@ -323,7 +424,16 @@ func (x *Xtractr) Rename(oldpath, newpath string) error {
 	return nil
 }
 
-// clean returns an absolute path for a file inside the OutputDir.
+// context returns the context for this extraction.
+func (x *XFile) context() context.Context {
+	if x.ctx == nil {
+		return context.Background()
+	}
+
+	return x.ctx
+}
+
+// clean returns an absolute path for a file inside the OutputDir, with the NamePolicy applied.
 // If trim length is > 0, then the suffixes are trimmed, and filepath removed.
 func (x *XFile) clean(filePath string, trim ...string) string {
 	if len(trim) != 0 {
@@ -333,5 +443,5 @@ func (x *XFile) clean(filePath string, trim ...string) string {
 		}
 	}
 
-	return filepath.Clean(filepath.Join(x.OutputDir, filePath))
+	return filepath.Clean(filepath.Join(x.OutputDir, x.NamePolicy.apply(filePath)))
 }
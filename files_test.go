@@ -0,0 +1,63 @@
+package xtractr_test
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractFileContext(t *testing.T) {
+	t.Parallel()
+
+	name := t.TempDir()
+	xFile := &xtractr.XFile{
+		FilePath:  testFile,
+		OutputDir: name,
+		Password:  "some_password",
+		FileMode:  xtractr.DefaultFileMode,
+		DirMode:   xtractr.DefaultDirMode,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+
+	_, files, _, err := xtractr.ExtractFileContext(ctx, xFile)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, len(files))
+
+	for _, file := range filesInTestArchive {
+		assert.NoFileExists(t, filepath.Join(name, file))
+	}
+
+	size, files, _, err := xtractr.ExtractFileContext(context.Background(), xFile)
+	assert.NoError(t, err, "the XFile must not keep the expired context")
+	assert.Equal(t, testDataSize, size)
+	assert.Equal(t, len(filesInTestArchive), len(files))
+}
+
+func TestExtractFileContextMidCopy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "large.zip")
+	assert.NoError(t, makeZipFile(archive, map[string]string{
+		"large.bin": strings.Repeat("large file ", 1024*1024),
+	}))
+
+	output := filepath.Join(dir, "out")
+	ctx := newCopyCanceler(filepath.Join(output, "large.bin"), 1024*1024)
+	defer ctx.cancel()
+
+	size, files, _, err := xtractr.ExtractFileContext(ctx, &xtractr.XFile{FilePath: archive, OutputDir: output})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.True(t, ctx.stopped, "the extraction must be cancelled while the large file is written")
+	assert.Empty(t, files)
+	assert.Less(t, size, int64(len("large file ")*1024*1024), "the copy stopped in the middle")
+	assert.NoFileExists(t, filepath.Join(output, "large.bin"), "the partial file must be removed")
+}
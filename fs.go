@@ -0,0 +1,440 @@
+package xtractr
+
+/* Code to browse an archive as a read-only io/fs.FS, without extracting it. */
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// FSOpener is an optional interface an Extractor may implement to support OpenFS.
+type FSOpener interface {
+	// OpenFS returns a read-only file system view of an archive.
+	// The io.Closer must be closed when the file system is no longer needed.
+	OpenFS(xFile *XFile) (fs.FS, io.Closer, error)
+}
+
+// OpenFS returns a read-only view of a ZIP, 7z, RAR, tar or ISO archive. The returned
+// fs.FS also implements fs.ReadDirFS and fs.StatFS, so it works with fs.WalkDir,
+// fs.ReadFile and http.FS. Close the io.Closer when you're done with the file system.
+// RAR archives and tarballs are read sequentially, so opening a file inside them
+// re-reads the archive up to that file. ZIP, 7z and ISO files are opened directly.
+func OpenFS(path, password string) (fs.FS, io.Closer, error) {
+	reg, err := registrationFor(path, false)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	opener, ok := reg.extractor.(FSOpener)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s: %s", ErrNoFS, reg.format, path)
+	}
+
+	fsys, closer, err := opener.OpenFS(&XFile{FilePath: path, Password: password})
+
+	return fsys, closer, archiveError(err, password != "")
+}
+
+// archiveFS is a read-only file system built from the entries in an archive.
+type archiveFS struct {
+	nodes map[string]*fsNode
+}
+
+// fsNode is a file or folder in an archiveFS. It satisfies fs.FileInfo and fs.DirEntry.
+type fsNode struct {
+	name     string
+	mode     fs.FileMode
+	size     int64
+	modTime  time.Time
+	children []*fsNode
+	open     func() (io.ReadCloser, error)
+}
+
+// fsFile is an open file in an archiveFS.
+type fsFile struct {
+	io.ReadCloser
+	node *fsNode
+}
+
+// fsDir is an open folder in an archiveFS.
+type fsDir struct {
+	node   *fsNode
+	offset int
+}
+
+// closerFunc allows a function to satisfy io.Closer.
+type closerFunc func() error
+
+// readCloser combines a reader with a different closer.
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+func newArchiveFS() *archiveFS {
+	return &archiveFS{nodes: map[string]*fsNode{".": {name: ".", mode: fs.ModeDir | 0o555}}}
+}
+
+// add puts an archive entry into the file system, creating parent folders as needed.
+// Entries with invalid names (absolute paths, or paths with ..) are skipped.
+func (a *archiveFS) add(entry Entry, open func() (io.ReadCloser, error)) {
+	name := strings.TrimLeft(strings.ReplaceAll(entry.Name, `\`, "/"), "/")
+	isDir := entry.IsDir || strings.HasSuffix(name, "/")
+
+	if name = path.Clean(name); name == "." || !fs.ValidPath(name) {
+		return
+	}
+
+	node := &fsNode{
+		name:    path.Base(name),
+		mode:    entry.Mode &^ fs.ModeDir,
+		size:    entry.Size,
+		modTime: entry.ModTime,
+		open:    open,
+	}
+
+	if isDir {
+		node.mode |= fs.ModeDir
+		node.size = 0
+	}
+
+	if node.size < 0 {
+		node.size = 0
+	}
+
+	switch existing := a.nodes[name]; {
+	case existing == nil:
+		a.nodes[name] = node
+		parent := a.mkdirAll(path.Dir(name))
+		parent.children = insertNode(parent.children, node)
+	case existing.IsDir() && isDir: // folder was created by a child; update its details.
+		existing.mode, existing.modTime = node.mode, node.modTime
+	case !existing.IsDir() && !isDir: // the last copy of a file wins, like extracting would.
+		*existing = *node
+	}
+}
+
+// mkdirAll returns the folder node for name, creating it and its parents if needed.
+func (a *archiveFS) mkdirAll(name string) *fsNode {
+	if node := a.nodes[name]; node != nil {
+		return node
+	}
+
+	node := &fsNode{name: path.Base(name), mode: fs.ModeDir | 0o555}
+	a.nodes[name] = node
+	parent := a.mkdirAll(path.Dir(name))
+	parent.children = insertNode(parent.children, node)
+
+	return node
+}
+
+// insertNode adds a node to a list of nodes, keeping it sorted by name.
+func insertNode(nodes []*fsNode, node *fsNode) []*fsNode {
+	idx := sort.Search(len(nodes), func(i int) bool { return nodes[i].name >= node.name })
+	nodes = append(nodes, nil)
+	copy(nodes[idx+1:], nodes[idx:])
+	nodes[idx] = node
+
+	return nodes
+}
+
+// lookup returns the node for a path, or an fs.PathError.
+func (a *archiveFS) lookup(operation, name string) (*fsNode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: operation, Path: name, Err: fs.ErrInvalid}
+	}
+
+	node := a.nodes[name]
+	if node == nil {
+		return nil, &fs.PathError{Op: operation, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return node, nil
+}
+
+// Open satisfies fs.FS.
+func (a *archiveFS) Open(name string) (fs.File, error) {
+	node, err := a.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if node.IsDir() {
+		return &fsDir{node: node}, nil
+	}
+
+	reader, err := node.open()
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+
+	return &fsFile{ReadCloser: reader, node: node}, nil
+}
+
+// ReadDir satisfies fs.ReadDirFS.
+func (a *archiveFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	node, err := a.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !node.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: ErrNotDir}
+	}
+
+	entries := make([]fs.DirEntry, len(node.children))
+	for idx, child := range node.children {
+		entries[idx] = child
+	}
+
+	return entries, nil
+}
+
+// Stat satisfies fs.StatFS.
+func (a *archiveFS) Stat(name string) (fs.FileInfo, error) {
+	node, err := a.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return node, nil
+}
+
+func (n *fsNode) Name() string               { return n.name }
+func (n *fsNode) Size() int64                { return n.size }
+func (n *fsNode) Mode() fs.FileMode          { return n.mode }
+func (n *fsNode) ModTime() time.Time         { return n.modTime }
+func (n *fsNode) IsDir() bool                { return n.mode.IsDir() }
+func (n *fsNode) Sys() interface{}           { return nil }
+func (n *fsNode) Type() fs.FileMode          { return n.mode.Type() }
+func (n *fsNode) Info() (fs.FileInfo, error) { return n, nil }
+
+func (f *fsFile) Stat() (fs.FileInfo, error) { return f.node, nil }
+
+func (d *fsDir) Stat() (fs.FileInfo, error) { return d.node, nil }
+func (d *fsDir) Close() error               { return nil }
+
+func (d *fsDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.node.name, Err: ErrIsDir}
+}
+
+// ReadDir satisfies fs.ReadDirFile.
+func (d *fsDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	remaining := d.node.children[d.offset:]
+	if count > 0 && len(remaining) == 0 {
+		return nil, io.EOF
+	}
+
+	if count > 0 && count < len(remaining) {
+		remaining = remaining[:count]
+	}
+
+	d.offset += len(remaining)
+	entries := make([]fs.DirEntry, len(remaining))
+
+	for idx, child := range remaining {
+		entries[idx] = child
+	}
+
+	return entries, nil
+}
+
+func (c closerFunc) Close() error {
+	return c()
+}
+
+// fsZIP opens a zip file as an fs.FS. Encrypted files are decrypted with xFile.Password.
+func fsZIP(xFile *XFile) (fs.FS, io.Closer, error) {
+	zipReader, err := openZIP(xFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	archive := newArchiveFS()
+
+	for _, zipFile := range zipReader.File {
+		if zipFile.IsEncrypted() && xFile.Password != "" {
+			zipFile.SetPassword(xFile.Password)
+		}
+
+		entry := newEntry(zipFile.Name, int64(zipFile.UncompressedSize64),
+			int64(zipFile.CompressedSize64), zipFile.Mode(), zipFile.ModTime())
+		archive.add(entry, zipFile.Open)
+	}
+
+	return archive, zipReader, nil
+}
+
+// fs7z opens a 7zip archive as an fs.FS.
+func fs7z(xFile *XFile) (fs.FS, io.Closer, error) {
+	sevenZip, err := open7z(xFile, xFile.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	archive := newArchiveFS()
+
+	for _, zipFile := range sevenZip.File {
+		zipFile := zipFile
+		entry := newEntry(zipFile.Name, int64(zipFile.UncompressedSize), -1, zipFile.Mode(), zipFile.Modified)
+		archive.add(entry, func() (io.ReadCloser, error) { return sevenZip.open(zipFile) })
+	}
+
+	return archive, sevenZip, nil
+}
+
+// fsISO opens an ISO image as an fs.FS. The root folder is not renamed like ExtractISO does.
+func fsISO(xFile *XFile) (fs.FS, io.Closer, error) {
+	openISO, err := os.Open(xFile.FilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open iso file: %s: %w", xFile.FilePath, err)
+	}
+
+	iso, err := iso9660.OpenImage(openISO)
+	if err != nil {
+		openISO.Close()
+		return nil, nil, fmt.Errorf("failed to open iso image: %s: %w", xFile.FilePath, err)
+	}
+
+	root, err := iso.RootDir()
+	if err != nil {
+		openISO.Close()
+		return nil, nil, fmt.Errorf("failed to open iso root: %s: %w", xFile.FilePath, err)
+	}
+
+	archive := newArchiveFS()
+	if err := archive.addISO(root, ""); err != nil {
+		openISO.Close()
+		return nil, nil, fmt.Errorf("%s: %w", xFile.FilePath, err)
+	}
+
+	return archive, openISO, nil
+}
+
+func (a *archiveFS) addISO(isoFile *iso9660.File, parent string) error {
+	children, err := isoFile.GetChildren()
+	if err != nil {
+		return fmt.Errorf("getting children for %s: %w", isoFile.Name(), err)
+	}
+
+	for _, child := range children {
+		child := child
+		name := path.Join(parent, child.Name())
+		a.add(newEntry(name, child.Size(), child.Size(), child.Mode(), child.ModTime()), func() (io.ReadCloser, error) {
+			return io.NopCloser(child.Reader()), nil
+		})
+
+		if !child.IsDir() {
+			continue
+		}
+
+		if err := a.addISO(child, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fsRAR opens a rar archive as an fs.FS. Opening a file re-reads the archive up to that file.
+func fsRAR(xFile *XFile) (fs.FS, io.Closer, error) {
+	entries, err := listRAR(xFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	archive := newArchiveFS()
+
+	for idx, entry := range entries {
+		idx := idx
+		archive.add(entry, func() (io.ReadCloser, error) {
+			rarReader, _, err := openRAR(xFile, xFile.Password)
+			if err != nil {
+				return nil, err
+			}
+
+			for count := 0; ; count++ {
+				if _, err := rarReader.Next(); err != nil {
+					rarReader.Close()
+					return nil, fmt.Errorf("rarReader.Next: %w", err)
+				} else if count == idx {
+					return rarReader, nil
+				}
+			}
+		})
+	}
+
+	return archive, closerFunc(func() error { return nil }), nil
+}
+
+// fsTar opens a tarball as an fs.FS. Opening a file re-reads the tarball up to that file.
+// The format is the tarball's outer compression layer, or FormatTar if it's not compressed.
+func fsTar(format Format) func(xFile *XFile) (fs.FS, io.Closer, error) {
+	return func(xFile *XFile) (fs.FS, io.Closer, error) {
+		stream, err := openTarStream(xFile.FilePath, format)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		entries, err := xFile.listTar(tar.NewReader(stream))
+		stream.Close()
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		archive := newArchiveFS()
+
+		for idx, entry := range entries {
+			idx := idx
+			archive.add(entry, func() (io.ReadCloser, error) {
+				return openTarEntry(xFile.FilePath, format, idx)
+			})
+		}
+
+		return archive, closerFunc(func() error { return nil }), nil
+	}
+}
+
+// openTarEntry returns a reader for the idx'th entry in a tarball.
+func openTarEntry(filePath string, format Format, idx int) (io.ReadCloser, error) {
+	stream, err := openTarStream(filePath, format)
+	if err != nil {
+		return nil, err
+	}
+
+	tarReader := tar.NewReader(stream)
+
+	for count := 0; ; count++ {
+		if _, err := tarReader.Next(); err != nil {
+			stream.Close()
+			return nil, fmt.Errorf("tarReader.Next: %w", err)
+		} else if count == idx {
+			return &readCloser{Reader: tarReader, Closer: stream}, nil
+		}
+	}
+}
+
+// openTarStream opens a tarball and removes its compression layers.
+func openTarStream(filePath string, format Format) (*stream, error) {
+	stream, err := openStream(filePath, format)
+	if err != nil {
+		return nil, err
+	}
+
+	if stream.container == nil || stream.container.format != FormatTar {
+		stream.Close()
+		return nil, fmt.Errorf("%w: not a tarball: %s", ErrNoFS, filePath)
+	}
+
+	return stream, nil
+}
@@ -0,0 +1,43 @@
+package xtractr_test
+
+import (
+	"io/fs"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOpenFS(t *testing.T) {
+	t.Parallel()
+
+	name := t.TempDir()
+	contents := map[string]string{"top.txt": "top", "sub/dir/deep.txt": "deep", "sub/mid.txt": "mid"}
+	zipFile := filepath.Join(name, "fs.zip")
+	tgzFile := filepath.Join(name, "fs.tar.gz")
+
+	assert.NoError(t, makeZipFile(zipFile, contents))
+	assert.NoError(t, makeTarGzFile(tgzFile, contents))
+
+	for _, archive := range []string{zipFile, tgzFile} {
+		fileSystem, closer, err := xtractr.OpenFS(archive, "")
+		if !assert.NoError(t, err, archive) {
+			continue
+		}
+
+		assert.NoError(t, fstest.TestFS(fileSystem, "top.txt", "sub/mid.txt", "sub/dir/deep.txt"), archive)
+
+		data, err := fs.ReadFile(fileSystem, "sub/dir/deep.txt")
+		assert.NoError(t, err)
+		assert.Equal(t, "deep", string(data))
+		assert.NoError(t, closer.Close())
+	}
+
+	fileSystem, closer, err := xtractr.OpenFS(testFile, "some_password")
+	if assert.NoError(t, err) {
+		defer closer.Close()
+		assert.NoError(t, fstest.TestFS(fileSystem, filesInTestArchive...))
+	}
+}
@@ -3,33 +3,26 @@ module github.com/fmzchao/xtractr
 go 1.19
 
 require (
+	github.com/andybalholm/brotli v1.0.6
 	github.com/bodgit/sevenzip v1.4.4
 	github.com/kdomanski/iso9660 v0.4.0
-	github.com/nwaples/rardecode v1.1.3
+	github.com/klauspost/compress v1.17.2
+	github.com/nwaples/rardecode/v2 v2.0.1
+	github.com/pierrec/lz4/v4 v4.1.18
 	github.com/stretchr/testify v1.8.4
+	github.com/ulikunitz/xz v0.5.11
 	github.com/yeka/zip v0.0.0-20180914125537-d046722c6feb
-	golift.io/xtractr v0.2.2
+	golang.org/x/text v0.14.0
 )
 
 require (
-	github.com/andybalholm/brotli v1.0.6 // indirect
 	github.com/bodgit/plumbing v1.3.0 // indirect
 	github.com/bodgit/windows v1.0.1 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
-	github.com/dsnet/compress v0.0.2-0.20210315054119-f66993602bf5 // indirect
-	github.com/golang/snappy v0.0.2 // indirect
 	github.com/hashicorp/errwrap v1.1.0 // indirect
 	github.com/hashicorp/go-multierror v1.1.1 // indirect
-	github.com/klauspost/compress v1.17.2 // indirect
-	github.com/klauspost/pgzip v1.2.5 // indirect
-	github.com/mholt/archiver/v3 v3.5.1 // indirect
-	github.com/nwaples/rardecode/v2 v2.0.0-beta.2 // indirect
-	github.com/pierrec/lz4/v4 v4.1.18 // indirect
 	github.com/pmezard/go-difflib v1.0.0 // indirect
-	github.com/ulikunitz/xz v0.5.11 // indirect
-	github.com/xi2/xz v0.0.0-20171230120015-48954b6210f8 // indirect
 	go4.org v0.0.0-20230225012048-214862532bf5 // indirect
 	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519 // indirect
-	golang.org/x/text v0.14.0 // indirect
 	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
@@ -68,15 +68,62 @@ func (x *XFile) uniso(isoFile *iso9660.File, parent string) (int64, []string, er
 }
 
 func (x *XFile) unisofile(isoFile *iso9660.File, fileName string) (int64, []string, error) {
-	destFile := x.clean(fileName)
-	//nolint:gocritic // this 1-argument filepath.Join removes a ./ prefix should there be one.
-	if !strings.HasPrefix(destFile, filepath.Join(x.OutputDir)) {
-		// The file being written is trying to write outside of our base path. Malicious ISO?
-		return 0, nil, fmt.Errorf("%s: %w: %s != %s (from: %s)",
-			x.FilePath, ErrInvalidPath, destFile, x.OutputDir, isoFile.Name())
+	destFile, err := x.outputPath(fileName)
+	if err != nil || destFile == "" {
+		return 0, nil, err
 	}
 
-	size, err := writeFile(destFile, isoFile.Reader(), x.FileMode, x.DirMode)
+	size, err := x.writeFile(destFile, isoFile.Reader(), x.FileMode, x.DirMode)
 
 	return size, []string{destFile}, err
 }
+
+// listISO lists the contents of an ISO image. Names use the same root folder as ExtractISO.
+func listISO(xFile *XFile) ([]Entry, error) {
+	openISO, err := os.Open(xFile.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open iso file: %s: %w", xFile.FilePath, err)
+	}
+	defer openISO.Close()
+
+	iso, err := iso9660.OpenImage(openISO)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open iso image: %s: %w", xFile.FilePath, err)
+	}
+
+	root, err := iso.RootDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open iso root: %s: %w", xFile.FilePath, err)
+	}
+
+	return xFile.listISO(root, "")
+}
+
+func (x *XFile) listISO(isoFile *iso9660.File, parent string) ([]Entry, error) {
+	itemName := filepath.Join(parent, isoFile.Name())
+	if isoFile.Name() == string([]byte{0}) { // rename root folder.
+		itemName = strings.TrimSuffix(strings.TrimSuffix(filepath.Base(x.FilePath), ".iso"), ".ISO")
+	}
+
+	if !isoFile.IsDir() {
+		return []Entry{newEntry(itemName, isoFile.Size(), isoFile.Size(), isoFile.Mode(), isoFile.ModTime())}, nil
+	}
+
+	children, err := isoFile.GetChildren()
+	if err != nil {
+		return nil, fmt.Errorf("getting children for %s: %w", isoFile.Name(), err)
+	}
+
+	entries := []Entry{newEntry(itemName, 0, 0, isoFile.Mode(), isoFile.ModTime())}
+
+	for _, child := range children {
+		childEntries, err := x.listISO(child, itemName)
+		if err != nil {
+			return entries, err
+		}
+
+		entries = append(entries, childEntries...)
+	}
+
+	return entries, nil
+}
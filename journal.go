@@ -0,0 +1,362 @@
+package xtractr
+
+/* Code to record queued extractions in a file, so they survive a restart. */
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Journal events, one per line in the journal file.
+const (
+	journalQueued   = "queued"
+	journalStarted  = "started"
+	journalFinished = "finished"
+)
+
+const (
+	// journalFileMode is used for the journal file. It may contain archive passwords.
+	journalFileMode = 0o600
+	// journalCompactLines is how many lines are appended to the journal before it's rewritten
+	// with only the jobs that did not finish. The file is also rewritten when every job finished.
+	journalCompactLines = 1000
+)
+
+// journal appends a JSON line to a file every time a job is queued, started or finished.
+type journal struct {
+	sync.Mutex
+	file *os.File
+	path string
+	last uint64
+	// lines were written to the file since it was rewritten.
+	lines int
+	// open are the jobs that did not finish, with the last event seen for each.
+	open map[uint64]*journalRecord
+}
+
+// journalRecord is one line in the journal file.
+type journalRecord struct {
+	ID    uint64      `json:"id"`
+	Event string      `json:"event"`
+	Time  time.Time   `json:"time"`
+	Job   *journalJob `json:"job,omitempty"`
+	// Restores is the id of the job a restored job replaces. That job is
+	// done once this record is written, so it's never restored twice.
+	Restores uint64 `json:"restores,omitempty"`
+}
+
+// journalJob is the part of an Xtract that can be saved. Callbacks, contexts and password providers are lost.
+type journalJob struct {
+	Name             string     `json:"name,omitempty"`
+	Password         string     `json:"password,omitempty"`
+	Passwords        []string   `json:"passwords,omitempty"`
+	Path             string     `json:"path"`
+	ExcludeSuffix    Exclude    `json:"excludeSuffix,omitempty"`
+	DetectByContent  bool       `json:"detectByContent,omitempty"`
+	SFX              bool       `json:"sfx,omitempty"`
+	DisableRecursion bool       `json:"disableRecursion,omitempty"`
+	RecurseISO       bool       `json:"recurseIso,omitempty"`
+	ExtractTo        string     `json:"extractTo,omitempty"`
+	TempFolder       bool       `json:"tempFolder,omitempty"`
+	DeleteOrig       bool       `json:"deleteOrig,omitempty"`
+	LogFile          bool       `json:"logFile,omitempty"`
+	Limits           Limits     `json:"limits"`
+	ExpandDeb        bool       `json:"expandDeb,omitempty"`
+	NameCharset      string     `json:"nameCharset,omitempty"`
+	NamePolicy       NamePolicy `json:"namePolicy"`
+	TestOnly         bool       `json:"testOnly,omitempty"`
+}
+
+// openJournal reads the journal file and returns the jobs that never finished.
+// The file is rewritten with only those jobs, and new events are appended to it.
+func openJournal(path string) (*journal, []*journalRecord, error) {
+	unfinished, last, err := readJournal(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(unfinished) == 0 {
+		last = 0
+	}
+
+	j := &journal{path: path, last: last, open: make(map[uint64]*journalRecord)}
+	for _, record := range unfinished {
+		j.open[record.ID] = record
+	}
+
+	if err := j.compact(); err != nil {
+		return nil, nil, err
+	}
+
+	return j, unfinished, nil
+}
+
+// readJournal returns the jobs in a journal file that were queued, but never finished,
+// and the last id used. The Event of each returned record is the last one seen for the job.
+func readJournal(path string) ([]*journalRecord, uint64, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, nil
+	} else if err != nil {
+		return nil, 0, fmt.Errorf("opening journal: %w", err)
+	}
+	defer file.Close()
+
+	last := uint64(0)
+
+	jobs := make(map[uint64]*journalRecord)
+	order := []uint64{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, bufio.MaxScanTokenSize), 1024*1024) //nolint:gomnd
+
+	for scanner.Scan() {
+		record := &journalRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			continue // a partial line, written as the process died.
+		}
+
+		if record.ID > last {
+			last = record.ID
+		}
+
+		switch job, ok := jobs[record.ID]; {
+		case record.Event == journalQueued && record.Job != nil:
+			jobs[record.ID] = record
+			order = append(order, record.ID)
+			delete(jobs, record.Restores)
+		case !ok:
+			continue
+		case record.Event == journalFinished:
+			delete(jobs, record.ID)
+		default:
+			job.Event = record.Event
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("reading journal: %w", err)
+	}
+
+	unfinished := []*journalRecord{}
+
+	for _, id := range order {
+		if record, ok := jobs[id]; ok {
+			unfinished = append(unfinished, record)
+		}
+	}
+
+	return unfinished, last, nil
+}
+
+// write adds an event to the journal. The job is only saved with the queued event.
+func (j *journal) write(ext *Xtract, event string) error {
+	if j == nil {
+		return nil
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	record := &journalRecord{ID: ext.journalID, Event: event, Time: time.Now()}
+
+	if event == journalQueued {
+		j.last++
+		ext.journalID = j.last
+		record.ID = j.last
+		record.Job = newJournalJob(ext)
+		record.Restores = ext.restores
+	}
+
+	if err := writeJournalRecord(j.file, record); err != nil {
+		return err
+	}
+
+	if err := j.file.Sync(); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+
+	j.lines++
+
+	switch open := j.open[record.ID]; {
+	case event == journalQueued:
+		j.open[record.ID] = record
+		delete(j.open, record.Restores)
+	case open == nil: // not a job in this journal.
+	case event == journalFinished:
+		delete(j.open, record.ID)
+	default:
+		open.Event = event
+	}
+
+	if len(j.open) == 0 || j.lines >= journalCompactLines+2*len(j.open) {
+		return j.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites the journal with only the jobs that did not finish, so it does not grow
+// forever. The new file is written next to the old one, then renamed over it.
+func (j *journal) compact() error {
+	ids := make([]uint64, 0, len(j.open))
+	for id := range j.open {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(a, b int) bool { return ids[a] < ids[b] })
+
+	temp := j.path + ".tmp"
+
+	file, err := os.OpenFile(temp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, journalFileMode)
+	if err != nil {
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+
+	lines := 0
+
+	for _, id := range ids {
+		open := j.open[id]
+		records := []*journalRecord{{ID: id, Event: journalQueued, Time: open.Time, Job: open.Job}}
+
+		if open.Event == journalStarted {
+			records = append(records, &journalRecord{ID: id, Event: journalStarted, Time: open.Time})
+		}
+
+		for _, record := range records {
+			if err := writeJournalRecord(file, record); err != nil {
+				file.Close()
+				return err
+			}
+
+			lines++
+		}
+	}
+
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+
+	if err := os.Rename(temp, j.path); err != nil {
+		file.Close()
+		return fmt.Errorf("compacting journal: %w", err)
+	}
+
+	if j.file != nil {
+		j.file.Close()
+	}
+
+	j.file, j.lines = file, lines
+
+	return nil
+}
+
+// writeJournalRecord writes a record to the journal file as one line of JSON.
+func writeJournalRecord(file *os.File, record *journalRecord) error {
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("encoding journal: %w", err)
+	}
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing journal: %w", err)
+	}
+
+	return nil
+}
+
+// close closes the journal file.
+func (j *journal) close() error {
+	if j == nil {
+		return nil
+	}
+
+	j.Lock()
+	defer j.Unlock()
+
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("closing journal: %w", err)
+	}
+
+	return nil
+}
+
+func newJournalJob(ext *Xtract) *journalJob {
+	return &journalJob{
+		Name:             ext.Name,
+		Password:         ext.Password,
+		Passwords:        ext.Passwords,
+		Path:             ext.Path,
+		ExcludeSuffix:    ext.ExcludeSuffix,
+		DetectByContent:  ext.DetectByContent,
+		SFX:              ext.SFX,
+		DisableRecursion: ext.DisableRecursion,
+		RecurseISO:       ext.RecurseISO,
+		ExtractTo:        ext.ExtractTo,
+		TempFolder:       ext.TempFolder,
+		DeleteOrig:       ext.DeleteOrig,
+		LogFile:          ext.LogFile,
+		Limits:           ext.Limits,
+		ExpandDeb:        ext.ExpandDeb,
+		NameCharset:      ext.NameCharset,
+		NamePolicy:       ext.NamePolicy,
+		TestOnly:         ext.TestOnly,
+	}
+}
+
+// xtract turns a saved job back into an Xtract.
+func (j *journalJob) xtract() *Xtract {
+	return &Xtract{
+		Name:      j.Name,
+		Password:  j.Password,
+		Passwords: j.Passwords,
+		Filter: Filter{
+			Path:            j.Path,
+			ExcludeSuffix:   j.ExcludeSuffix,
+			DetectByContent: j.DetectByContent,
+			SFX:             j.SFX,
+		},
+		DisableRecursion: j.DisableRecursion,
+		RecurseISO:       j.RecurseISO,
+		ExtractTo:        j.ExtractTo,
+		TempFolder:       j.TempFolder,
+		DeleteOrig:       j.DeleteOrig,
+		LogFile:          j.LogFile,
+		Limits:           j.Limits,
+		ExpandDeb:        j.ExpandDeb,
+		NameCharset:      j.NameCharset,
+		NamePolicy:       j.NamePolicy,
+		TestOnly:         j.TestOnly,
+	}
+}
+
+// restoreJournal queues the jobs that never finished. Jobs that were interrupted
+// have their temporary output folder removed first. Runs in Start() after the queue is running.
+func (x *Xtractr) restoreJournal(unfinished []*journalRecord) {
+	for _, record := range unfinished {
+		ext := record.Job.xtract()
+
+		if record.Event == journalStarted && !ext.TestOnly {
+			x.config.Printf("Cleaning up interrupted extraction: %s", ext.Path)
+			x.DeleteFiles(x.outputPath(ext))
+		}
+
+		if x.config.Restore != nil {
+			x.config.Restore(ext)
+		}
+
+		// The job gets a new id when it's queued. Its queued record replaces the old job
+		// in one write, so a crash never loses the job, or restores it twice.
+		ext.restores = record.ID
+
+		if _, err := x.Extract(ext); err != nil {
+			x.config.Printf("Error: Restoring Journal: %v", err)
+		}
+	}
+}
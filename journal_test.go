@@ -0,0 +1,115 @@
+package xtractr_test
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJournal(t *testing.T) {
+	t.Parallel()
+
+	path := testSetupTestDir(t)
+	defer os.RemoveAll(path)
+
+	// Pretend the process died while this job was extracting.
+	output := path + xtractr.DefaultSuffix
+	assert.NoError(t, os.MkdirAll(output, xtractr.DefaultDirMode))
+	assert.NoError(t, os.WriteFile(filepath.Join(output, "partial.txt"), []byte("junk"), xtractr.DefaultFileMode))
+
+	journal := filepath.Join(t.TempDir(), "journal.json")
+	lines := fmt.Sprintf(`{"id":1,"event":"queued","job":{"path":%q,"password":"some_password"}}
+{"id":2,"event":"queued","job":{"path":"finished/job"}}
+{"id":1,"event":"started"}
+{"id":2,"event":"started"}
+{"id":2,"event":"finished"}
+{"id":3,"event":"queu`, path)
+	assert.NoError(t, os.WriteFile(journal, []byte(lines), 0o600))
+
+	restored := []*xtractr.Xtract{}
+	responses := make(chan *xtractr.Response)
+	queue := xtractr.NewQueue(&xtractr.Config{
+		Logger:  &testLogger{t: t},
+		Journal: journal,
+		Restore: func(ext *xtractr.Xtract) {
+			restored = append(restored, ext)
+			ext.CBChannel = responses
+
+			// The journal was rewritten with only the unfinished job in it.
+			data, err := os.ReadFile(journal)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, strings.Count(string(data), "\n"), "queued and started")
+			assert.NotContains(t, string(data), "finished/job")
+		},
+	})
+
+	assert.Equal(t, 1, len(restored), "only the unfinished job must be restored")
+
+	for resp := range responses {
+		if resp.Done {
+			assert.NoError(t, resp.Error)
+			assert.Equal(t, testDataSize*4, resp.Size)
+
+			break
+		}
+	}
+
+	queue.Stop()
+	assert.NoFileExists(t, filepath.Join(path, "partial.txt"), "the interrupted output must be removed first")
+
+	data, err := os.ReadFile(journal)
+	assert.NoError(t, err)
+	assert.Empty(t, string(data), "every job finished, so the journal starts over")
+	assert.NoDirExists(t, output)
+
+	// Everything finished, so nothing is restored on the next start.
+	assert.NoError(t, queue.Start())
+	queue.Stop()
+	assert.Equal(t, 1, len(restored))
+}
+
+func TestJournalRestoreCrash(t *testing.T) {
+	t.Parallel()
+
+	path := testSetupTestDir(t)
+	defer os.RemoveAll(path)
+
+	// Job 1 was restored as job 2, then the process died before job 2 started.
+	journal := filepath.Join(t.TempDir(), "journal.json")
+	lines := fmt.Sprintf(`{"id":1,"event":"queued","job":{"path":%[1]q,"password":"some_password"}}
+{"id":1,"event":"started"}
+{"id":2,"event":"queued","job":{"path":%[1]q,"password":"some_password"},"restores":1}
+`, path)
+	assert.NoError(t, os.WriteFile(journal, []byte(lines), 0o600))
+
+	restored := []*xtractr.Xtract{}
+	responses := make(chan *xtractr.Response)
+	queue := xtractr.NewQueue(&xtractr.Config{
+		Logger:  &testLogger{t: t},
+		Journal: journal,
+		Restore: func(ext *xtractr.Xtract) {
+			restored = append(restored, ext)
+			ext.CBChannel = responses
+		},
+	})
+
+	assert.Equal(t, 1, len(restored), "the job must be restored once")
+
+	for resp := range responses {
+		if resp.Done {
+			assert.NoError(t, resp.Error)
+			break
+		}
+	}
+
+	queue.Stop()
+
+	data, err := os.ReadFile(journal)
+	assert.NoError(t, err)
+	assert.Empty(t, string(data), "the restored job finished")
+}
@@ -0,0 +1,144 @@
+package xtractr
+
+/* Code to stop hostile archives (zip bombs) from filling the disk. */
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ratioFloor is how much must be written from an archive before MaxRatio is enforced.
+// Small archives with very compressible files have high ratios, and are harmless.
+const ratioFloor = 1024 * 1024
+
+// Limits protect against archives that expand to unreasonable sizes or counts.
+// Zero values mean no limit. When a limit is crossed, extraction stops with
+// ErrSizeLimit, ErrEntryLimit, ErrRatioLimit or ErrDepthLimit and the files
+// written so far are removed. Limits are enforced for the built-in formats.
+type Limits struct {
+	// Maximum bytes written. For a queued Xtract, this covers all of its archives.
+	MaxTotalSize int64
+	// Maximum bytes written for any single file.
+	MaxFileSize int64
+	// Maximum number of files written. For a queued Xtract, this covers all of its archives.
+	MaxEntries int
+	// Maximum ratio of bytes written to the size of the archive file. Enforced
+	// after the first MB is written. Multi-volume archives use their first volume.
+	MaxRatio float64
+	// Maximum depth of archives inside archives extracted by the queue. Only used by Xtract.
+	// 0 keeps the default of extracting one level of nested archives.
+	MaxDepth int
+}
+
+// limiter tracks how much has been written, so Limits can be enforced.
+// One limiter is shared by every archive in a queued Xtract.
+type limiter struct {
+	Limits
+	limiterCount
+	input int64 // size of the current archive file.
+}
+
+// limiterCount is what a limiter counted. It's saved before a password is tried.
+type limiterCount struct {
+	total   int64 // bytes written, all archives.
+	entries int   // files written, all archives.
+	written int64 // bytes written from the current archive.
+}
+
+// limitWriter passes writes to a file, and stops them when a limit would be
+// crossed or the context is cancelled.
+type limitWriter struct {
+	io.Writer
+	limit *limiter
+	ctx   context.Context //nolint:containedctx
+	size  int64
+}
+
+// newLimiter returns a limiter for the provided limits.
+func newLimiter(limits Limits) *limiter {
+	return &limiter{Limits: limits}
+}
+
+// startArchive is called before each archive is extracted, so the ratio is calculated per archive.
+func (l *limiter) startArchive(path string) {
+	l.input, l.written = 0, 0
+
+	if info, err := os.Stat(path); err == nil {
+		l.input = info.Size()
+	}
+}
+
+// save returns what was counted so far.
+func (l *limiter) save() limiterCount {
+	return l.limiterCount
+}
+
+// restore forgets what was counted after save. Used when a password was wrong,
+// so the files written with it do not count toward the limits.
+func (l *limiter) restore(count limiterCount) {
+	l.limiterCount = count
+}
+
+// addEntry counts a file about to be written.
+func (l *limiter) addEntry() error {
+	if l.entries++; l.MaxEntries > 0 && l.entries > l.MaxEntries {
+		return fmt.Errorf("%w: more than %d files", ErrEntryLimit, l.MaxEntries)
+	}
+
+	return nil
+}
+
+// check returns an error if writing count more bytes to a file of fileSize bytes crosses a limit.
+func (l *limiter) check(fileSize, count int64) error {
+	switch written := l.written + count; {
+	case l.MaxFileSize > 0 && fileSize+count > l.MaxFileSize:
+		return fmt.Errorf("%w: file larger than %d bytes", ErrSizeLimit, l.MaxFileSize)
+	case l.MaxTotalSize > 0 && l.total+count > l.MaxTotalSize:
+		return fmt.Errorf("%w: more than %d bytes written", ErrSizeLimit, l.MaxTotalSize)
+	case l.MaxRatio > 0 && l.input > 0 && written > ratioFloor && float64(written)/float64(l.input) > l.MaxRatio:
+		return fmt.Errorf("%w: expanded more than %.0f times", ErrRatioLimit, l.MaxRatio)
+	default:
+		return nil
+	}
+}
+
+func (w *limitWriter) Write(data []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err //nolint:wrapcheck
+	}
+
+	if err := w.limit.check(w.size, int64(len(data))); err != nil {
+		return 0, err
+	}
+
+	size, err := w.Writer.Write(data)
+	w.size += int64(size)
+	w.limit.total += int64(size)
+	w.limit.written += int64(size)
+
+	return size, err //nolint:wrapcheck
+}
+
+// isLimitError returns true if an extraction stopped because it crossed a limit.
+func isLimitError(err error) bool {
+	return errors.Is(err, ErrSizeLimit) || errors.Is(err, ErrEntryLimit) ||
+		errors.Is(err, ErrRatioLimit) || errors.Is(err, ErrDepthLimit)
+}
+
+// isStopError returns true if an extraction crossed a limit or its context was cancelled.
+// These errors are never retried with another password, and partial output is removed.
+func isStopError(err error) bool {
+	return isLimitError(err) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// limiter returns the limiter for this extraction, creating it from Limits if needed.
+func (x *XFile) limiter() *limiter {
+	if x.limit == nil {
+		x.limit = newLimiter(x.Limits)
+	}
+
+	return x.limit
+}
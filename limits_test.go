@@ -0,0 +1,86 @@
+package xtractr_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+	"github.com/yeka/zip"
+)
+
+func TestLimits(t *testing.T) {
+	t.Parallel()
+
+	name := t.TempDir()
+	bomb := filepath.Join(name, "bomb.zip")
+	assert.NoError(t, makeZipFile(bomb, map[string]string{"zeros.txt": strings.Repeat("0", 4*1024*1024)}))
+
+	tests := []struct {
+		limits xtractr.Limits
+		err    error
+	}{
+		{limits: xtractr.Limits{MaxRatio: 10}, err: xtractr.ErrRatioLimit},
+		{limits: xtractr.Limits{MaxFileSize: 1024 * 1024}, err: xtractr.ErrSizeLimit},
+		{limits: xtractr.Limits{MaxTotalSize: 1024}, err: xtractr.ErrSizeLimit},
+		{limits: xtractr.Limits{MaxEntries: 1}},
+	}
+
+	for _, test := range tests {
+		output := filepath.Join(name, "output")
+		_, files, _, err := xtractr.ExtractFile(&xtractr.XFile{
+			FilePath:  bomb,
+			OutputDir: output,
+			FileMode:  xtractr.DefaultFileMode,
+			DirMode:   xtractr.DefaultDirMode,
+			Limits:    test.limits,
+		})
+
+		if test.err == nil {
+			assert.NoError(t, err)
+			assert.Equal(t, 1, len(files))
+		} else {
+			assert.ErrorIs(t, err, test.err)
+			assert.NoFileExists(t, filepath.Join(output, "zeros.txt"), "partial files must be removed")
+		}
+
+		os.RemoveAll(output)
+	}
+
+	output := filepath.Join(name, "rar")
+	_, files, _, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:  testFile,
+		OutputDir: output,
+		Password:  "some_password",
+		FileMode:  xtractr.DefaultFileMode,
+		DirMode:   xtractr.DefaultDirMode,
+		Limits:    xtractr.Limits{MaxEntries: 3},
+	})
+	assert.ErrorIs(t, err, xtractr.ErrEntryLimit)
+	assert.Equal(t, 0, len(files))
+
+	for _, file := range filesInTestArchive {
+		assert.NoFileExists(t, filepath.Join(output, file), "files written before the limit must be removed")
+	}
+}
+
+func TestLimitsPasswords(t *testing.T) {
+	t.Parallel()
+
+	name := t.TempDir()
+	archive := filepath.Join(name, "secret.zip")
+	assert.NoError(t, makeEncryptedZip(archive, "secret", zip.StandardEncryption))
+
+	// Every attempt writes both files. Only the attempt with the right password counts.
+	size, files, _, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:  archive,
+		OutputDir: filepath.Join(name, "output"),
+		Passwords: []string{"wrong", "also wrong", "still wrong", "secret"},
+		Limits:    xtractr.Limits{MaxEntries: 2, MaxTotalSize: int64(len("secret") * 100)},
+	})
+	assert.NoError(t, err, "failed passwords must not count toward the limits")
+	assert.Equal(t, int64(len("secret")*100), size)
+	assert.Equal(t, 2, len(files))
+}
@@ -0,0 +1,59 @@
+package xtractr
+
+/* Code to list the contents of an archive without extracting it. */
+
+import (
+	"os"
+	"time"
+)
+
+// Entry is a file or folder inside an archive.
+type Entry struct {
+	// Name (path) of the item inside the archive.
+	Name string
+	// Uncompressed size of the item. -1 if the archive does not say.
+	Size int64
+	// Compressed size of the item. -1 if the archive does not say.
+	CompressedSize int64
+	// File mode (permissions and type) of the item.
+	Mode os.FileMode
+	// Last modification time of the item. Zero if the archive does not say.
+	ModTime time.Time
+	// IsDir is true if the item is a folder.
+	IsDir bool
+	// IsSymlink is true if the item is a symbolic link.
+	IsSymlink bool
+	// Encrypted is true if the item's data is protected by a password.
+	Encrypted bool
+}
+
+// ListFile returns the files and folders inside an archive without extracting it.
+// Only headers are read when the format allows it. Compressed tarballs must be
+// decompressed to find their headers, but no data is written to disk.
+// The format is detected the same way as ExtractFile.
+func ListFile(xFile *XFile) ([]Entry, error) {
+	reg, err := registrationFor(xFile.FilePath, xFile.SFX)
+	if err != nil {
+		return nil, err
+	}
+
+	xFile.tries = xFile.passwordList()
+	defer func() { xFile.tries = nil }()
+
+	entries, err := reg.extractor.List(xFile)
+
+	return entries, archiveError(err, xFile.hasPassword())
+}
+
+// newEntry returns an Entry with the type fields filled in from a file mode.
+func newEntry(name string, size, compressed int64, mode os.FileMode, modTime time.Time) Entry {
+	return Entry{
+		Name:           name,
+		Size:           size,
+		CompressedSize: compressed,
+		Mode:           mode,
+		ModTime:        modTime,
+		IsDir:          mode.IsDir(),
+		IsSymlink:      mode&os.ModeSymlink != 0,
+	}
+}
@@ -0,0 +1,46 @@
+package xtractr_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListFile(t *testing.T) {
+	t.Parallel()
+
+	entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: testFile, Password: "some_password"})
+	assert.NoError(t, err)
+	assert.Equal(t, len(filesInTestArchive), len(entries))
+
+	size := int64(0)
+	for _, entry := range entries {
+		size += entry.Size
+		assert.Contains(t, filesInTestArchive, entry.Name)
+		assert.Less(t, entry.CompressedSize, entry.Size, "the test archive is compressed")
+	}
+
+	assert.Equal(t, testDataSize, size, "listed sizes must add up to the extracted size")
+
+	name := t.TempDir()
+	zipFile := filepath.Join(name, "list.zip")
+	tgzFile := filepath.Join(name, "list.tgz")
+	contents := map[string]string{"one.txt": "1", "two/three.txt": "333"}
+
+	assert.NoError(t, makeZipFile(zipFile, contents))
+	assert.NoError(t, makeTarGzFile(tgzFile, contents))
+
+	for _, archive := range []string{zipFile, tgzFile} {
+		entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: archive})
+		assert.NoError(t, err)
+		assert.Equal(t, len(contents), len(entries), archive)
+
+		for _, entry := range entries {
+			assert.Equal(t, int64(len(contents[entry.Name])), entry.Size, entry.Name)
+			assert.False(t, entry.IsDir)
+			assert.False(t, entry.Encrypted)
+		}
+	}
+}
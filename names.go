@@ -0,0 +1,165 @@
+package xtractr
+
+/* Change the names of files written from archives, so every file system can have them. */
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// NamePolicy changes the names of files written from an archive. It applies to every format.
+// The zero value writes names as they are. A name that leaves OutputDir is never written.
+type NamePolicy struct {
+	// Portable replaces characters Windows does not allow in names (<>:"\|?* and control
+	// characters) with an underscore, adds an underscore to reserved Windows names (CON,
+	// NUL, COM1, …) and removes dots and spaces from the end of names.
+	Portable bool
+	// MaxLength shortens every part of a path that is longer than this many bytes. A shortened
+	// name ends with the MD5 of the name and keeps its extension, so it's the same every time.
+	// 255 is the limit of most file systems. Zero for no limit.
+	MaxLength int
+	// NFC normalizes names to Unicode NFC. Archives made on macOS often have NFD names.
+	NFC bool
+	// SkipMacOS skips the __MACOSX folder and the ._ (AppleDouble) files macOS puts in archives.
+	SkipMacOS bool
+}
+
+// maxExtension is the longest extension a shortened name keeps.
+const maxExtension = 16
+
+//nolint:gochecknoglobals
+var (
+	// reservedNames are device names Windows does not allow as a name, with or without an extension.
+	reservedNames = map[string]bool{
+		"CON": true, "PRN": true, "AUX": true, "NUL": true,
+		"COM0": true, "COM1": true, "COM2": true, "COM3": true, "COM4": true,
+		"COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+		"LPT0": true, "LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true,
+		"LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+	}
+)
+
+// apply returns name changed by the policy. Each part of the path is changed on its own.
+func (p *NamePolicy) apply(name string) string {
+	if !p.Portable && p.MaxLength <= 0 && !p.NFC {
+		return name
+	}
+
+	if p.NFC {
+		name = norm.NFC.String(name)
+	}
+
+	parts := strings.Split(filepath.ToSlash(name), "/")
+	for idx, part := range parts {
+		if part == "" || part == "." || part == ".." {
+			continue
+		}
+
+		if p.Portable {
+			part = portableName(part)
+		}
+
+		if p.MaxLength > 0 {
+			part = shortName(part, p.MaxLength)
+		}
+
+		parts[idx] = part
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// skip returns true if the policy skips a file named name.
+func (p *NamePolicy) skip(name string) bool {
+	if !p.SkipMacOS {
+		return false
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == "__MACOSX" || strings.HasPrefix(part, "._") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// portableName returns a name Windows allows.
+func portableName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r < ' ' || strings.ContainsRune(`<>:"\|?*`, r) {
+			return '_'
+		}
+
+		return r
+	}, name)
+
+	if name = strings.TrimRight(name, ". "); name == "" {
+		return "_"
+	}
+
+	stem := name
+	if idx := strings.IndexByte(name, '.'); idx != -1 {
+		stem = name[:idx]
+	}
+
+	if reservedNames[strings.ToUpper(strings.TrimRight(stem, " "))] {
+		return stem + "_" + name[len(stem):]
+	}
+
+	return name
+}
+
+// shortName returns name if it fits in limit bytes. A longer name is cut, and
+// ends with its MD5 and its extension, like: a-very-long-na~<md5>.txt.
+func shortName(name string, limit int) string {
+	if len(name) <= limit {
+		return name
+	}
+
+	hash := "~" + CalculateMD5(name)
+	if limit <= len(hash) {
+		return hash[len(hash)-limit:]
+	}
+
+	ext := filepath.Ext(name)
+	if len(ext) > maxExtension || len(hash)+len(ext) >= limit {
+		ext = ""
+	}
+
+	keep := limit - len(hash) - len(ext)
+	for keep > 0 && !utf8.RuneStart(name[keep]) {
+		keep--
+	}
+
+	return name[:keep] + hash + ext
+}
+
+// outputPath returns where a file named name in an archive is written: inside OutputDir,
+// with the NamePolicy applied. Returns an empty path if the policy skips the file,
+// and ErrInvalidPath if the name leaves OutputDir.
+func (x *XFile) outputPath(name string) (string, error) {
+	if x.NamePolicy.skip(name) {
+		return "", nil
+	}
+
+	wfile := x.clean(name)
+	if !x.within(wfile) {
+		// The file being written is trying to write outside of our base path. Malicious archive?
+		return "", fmt.Errorf("%w: %s (from: %s)", ErrInvalidPath, wfile, name)
+	}
+
+	return wfile, nil
+}
+
+// within returns true if path is OutputDir, or inside it. A folder next
+// to OutputDir that starts with the same name (/out2 for /out) is not.
+func (x *XFile) within(path string) bool {
+	rel, err := filepath.Rel(filepath.Clean(x.OutputDir), path)
+
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
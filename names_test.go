@@ -0,0 +1,115 @@
+package xtractr_test
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamePolicy(t *testing.T) {
+	t.Parallel()
+
+	long := strings.Repeat("a", 300) + ".txt"
+	contents := map[string]string{
+		"CON.txt":             "reserved",
+		"what?<now>.txt":      "illegal",
+		"notes. ":             "trailing",
+		"dir./file.txt":       "folder",
+		"café.txt":           "nfd",
+		long:                  "long",
+		"__MACOSX/._file.txt": "metadata",
+		"dir./._file.txt":     "metadata",
+	}
+	want := []string{
+		"CON_.txt",
+		"what__now_.txt",
+		"notes",
+		filepath.Join("dir", "file.txt"),
+		"café.txt",
+		strings.Repeat("a", 255-len("~.txt")-32) + "~" + xtractr.CalculateMD5(long) + ".txt",
+	}
+	policy := xtractr.NamePolicy{Portable: true, MaxLength: 255, NFC: true, SkipMacOS: true}
+
+	// The same names are written from every format.
+	dir := t.TempDir()
+	archives := []string{filepath.Join(dir, "names.zip"), filepath.Join(dir, "names.tar.gz")}
+	assert.NoError(t, makeZipFile(archives[0], contents))
+	assert.NoError(t, makeTarGzFile(archives[1], contents))
+
+	for _, archive := range archives {
+		output := filepath.Join(dir, filepath.Base(archive)+".out")
+		_, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: archive, OutputDir: output, NamePolicy: policy})
+		assert.NoError(t, err, archive)
+
+		written := []string{}
+
+		for _, file := range files {
+			assert.FileExists(t, file, "the file reported is the file written")
+
+			rel, _ := filepath.Rel(output, file)
+			written = append(written, rel)
+		}
+
+		assert.ElementsMatch(t, want, written, archive)
+	}
+}
+
+func TestNamePolicyZero(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "names.zip")
+	assert.NoError(t, makeZipFile(archive, map[string]string{"CON.txt": "as is", "__MACOSX/._file.txt": "as is"}))
+
+	_, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: archive, OutputDir: filepath.Join(dir, "out")})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "out", "CON.txt"),
+		filepath.Join(dir, "out", "__MACOSX", "._file.txt"),
+	}, files)
+}
+
+func TestExtractOutsideOutputDir(t *testing.T) {
+	t.Parallel()
+
+	// out2 starts with the same name as the output folder, out.
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "evil.zip")
+	assert.NoError(t, makeZipFile(archive, map[string]string{"../out2/evil.txt": "evil"}))
+
+	_, _, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: archive, OutputDir: filepath.Join(dir, "out")})
+	assert.ErrorIs(t, err, xtractr.ErrInvalidPath)
+	assert.NoFileExists(t, filepath.Join(dir, "out2", "evil.txt"))
+
+	_, err = os.Stat(filepath.Join(dir, "out2"))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestExtractZipWithPasswordPolicy(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "names.zip")
+	assert.NoError(t, makeZipFile(archive, map[string]string{"what?.txt": "portable", "__MACOSX/._file.txt": "skipped"}))
+
+	// The deprecated function keeps skipping macOS metadata and cleaning names.
+	_, files, err := xtractr.ExtractZipWithPassword(&xtractr.XFile{FilePath: archive, OutputDir: filepath.Join(dir, "out")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "out", "what_.txt")}, files)
+
+	// A policy that was set is used as it is.
+	_, files, err = xtractr.ExtractZipWithPassword(&xtractr.XFile{
+		FilePath:   archive,
+		OutputDir:  filepath.Join(dir, "nfc"),
+		NamePolicy: xtractr.NamePolicy{NFC: true},
+	})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		filepath.Join(dir, "nfc", "what?.txt"),
+		filepath.Join(dir, "nfc", "__MACOSX", "._file.txt"),
+	}, files)
+}
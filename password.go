@@ -0,0 +1,222 @@
+package xtractr
+
+/* Code to find passwords for encrypted archives. */
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// PasswordProvider returns passwords to try for an archive. It's asked once for each
+// archive, with the archive's path. The passwords are tried in order, after
+// Password and Passwords. Use this to look up passwords from somewhere else.
+type PasswordProvider interface {
+	Passwords(archive string) []string
+}
+
+// StaticPasswords is a PasswordProvider that returns the same passwords for every archive.
+type StaticPasswords []string
+
+// PasswordFile is a PasswordProvider that reads passwords from a file, one per line.
+// Blank lines are skipped. The file is read every time passwords are needed,
+// so it can be updated while the queue runs.
+type PasswordFile string
+
+// SidecarPasswords is a PasswordProvider that reads passwords from files next to an
+// archive: <archive>.pass (ie. movie.rar.pass or movie.pass), then password.txt.
+// Each file has one password per line.
+type SidecarPasswords struct{}
+
+// FolderPassword is a PasswordProvider that pulls a password out of the name of the
+// folder an archive is in, and the folders above it. The first submatch of the regular
+// expression is the password. A nil Regexp uses DefaultFolderPassword, which finds
+// "secret" in a folder named "Some.Download{{secret}}".
+type FolderPassword struct {
+	*regexp.Regexp
+}
+
+// PasswordProviders combines providers. Passwords from all of them are tried, in order.
+type PasswordProviders []PasswordProvider
+
+// DefaultFolderPassword matches a password wrapped in double braces: {{password}}.
+var DefaultFolderPassword = regexp.MustCompile(`\{\{(.+?)\}\}`) //nolint:gochecknoglobals
+
+// passwordCache remembers the passwords that worked for each folder. Archives in the
+// same folder, like the next volume, and archives extracted from them try these first.
+type passwordCache struct {
+	sync.Mutex
+	dirs map[string][]string
+}
+
+// Passwords returns the static list.
+func (p StaticPasswords) Passwords(string) []string {
+	return p
+}
+
+// Passwords returns the lines in the file, or nothing if the file can't be read.
+func (p PasswordFile) Passwords(string) []string {
+	return readPasswordFile(string(p))
+}
+
+// Passwords returns the lines in the sidecar files for an archive.
+func (SidecarPasswords) Passwords(archive string) []string {
+	noExt := strings.TrimSuffix(archive, filepath.Ext(archive))
+	passwords := readPasswordFile(archive + ".pass")
+	passwords = append(passwords, readPasswordFile(noExt+".pass")...)
+
+	return append(passwords, readPasswordFile(filepath.Join(filepath.Dir(archive), "password.txt"))...)
+}
+
+// Passwords returns the passwords found in the names of the archive's folders, nearest first.
+func (p FolderPassword) Passwords(archive string) []string {
+	match := p.Regexp
+	if match == nil {
+		match = DefaultFolderPassword
+	}
+
+	passwords := []string{}
+
+	for dir := filepath.Dir(archive); ; dir = filepath.Dir(dir) {
+		if found := match.FindStringSubmatch(filepath.Base(dir)); len(found) > 1 && found[1] != "" {
+			passwords = append(passwords, found[1])
+		}
+
+		if parent := filepath.Dir(dir); parent == dir {
+			return passwords
+		}
+	}
+}
+
+// Passwords returns the passwords from every provider.
+func (p PasswordProviders) Passwords(archive string) []string {
+	passwords := []string{}
+
+	for _, provider := range p {
+		if provider != nil {
+			passwords = append(passwords, provider.Passwords(archive)...)
+		}
+	}
+
+	return passwords
+}
+
+// readPasswordFile returns the non-blank lines in a file.
+func readPasswordFile(path string) []string {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+
+	passwords := []string{}
+	scanner := bufio.NewScanner(file)
+
+	for scanner.Scan() {
+		if line := strings.TrimRight(scanner.Text(), "\r"); strings.TrimSpace(line) != "" {
+			passwords = append(passwords, line)
+		}
+	}
+
+	return passwords
+}
+
+// get returns the passwords that worked in a folder.
+func (c *passwordCache) get(dir string) []string {
+	c.Lock()
+	defer c.Unlock()
+
+	return append([]string(nil), c.dirs[dir]...)
+}
+
+// add remembers a password that worked in the provided folders. The newest is tried first.
+func (c *passwordCache) add(password string, dirs ...string) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.dirs == nil {
+		c.dirs = make(map[string][]string)
+	}
+
+	for _, dir := range dirs {
+		list := []string{password}
+
+		for _, existing := range c.dirs[dir] {
+			if existing != password {
+				list = append(list, existing)
+			}
+		}
+
+		c.dirs[dir] = list
+	}
+}
+
+// passwordCache returns the password cache for this extraction, creating it if needed.
+func (x *XFile) passwordCache() *passwordCache {
+	if x.passwords == nil {
+		x.passwords = &passwordCache{}
+	}
+
+	return x.passwords
+}
+
+// passwordWorked is called by extractors when a password opens an archive.
+// It's tried first for other archives in the same folder, and in the output folder.
+func (x *XFile) passwordWorked(password string) {
+	if password != "" {
+		x.passwordCache().add(password, filepath.Dir(x.FilePath), x.OutputDir)
+	}
+}
+
+// hasPassword returns true if any password was provided.
+func (x *XFile) hasPassword() bool {
+	return len(x.passwordList()) > 1
+}
+
+// firstPassword returns the password most likely to work: one that worked in this folder
+// before, or the first one provided. The PasswordProvider is not asked.
+func (x *XFile) firstPassword() string {
+	if worked := x.passwordCache().get(filepath.Dir(x.FilePath)); len(worked) > 0 {
+		return worked[0]
+	}
+
+	if x.Password == "" && len(x.Passwords) > 0 {
+		return x.Passwords[0]
+	}
+
+	return x.Password
+}
+
+// passwordList returns the passwords to try, in order: passwords that worked in this
+// folder before, Password, Passwords, then the PasswordProvider's. The last one is always
+// a blank password. Duplicates are removed. ExtractFile, ListFile and TestFile make
+// the list once for each archive, and it's reused until they return.
+func (x *XFile) passwordList() []string {
+	if x.tries != nil {
+		return x.tries
+	}
+
+	seen := map[string]bool{"": true}
+	passwords := []string{}
+	add := func(list ...string) {
+		for _, password := range list {
+			if !seen[password] {
+				seen[password] = true
+				passwords = append(passwords, password)
+			}
+		}
+	}
+
+	add(x.passwordCache().get(filepath.Dir(x.FilePath))...)
+	add(x.Password)
+	add(x.Passwords...)
+
+	if x.PasswordProvider != nil {
+		add(x.PasswordProvider.Passwords(x.FilePath)...)
+	}
+
+	return append(passwords, "")
+}
@@ -0,0 +1,134 @@
+package xtractr_test
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+// onceProvider returns its passwords the first time it's asked, and nothing after that.
+type onceProvider struct{ passwords []string }
+
+func (p *onceProvider) Passwords(string) []string {
+	passwords := p.passwords
+	p.passwords = nil
+
+	return passwords
+}
+
+// countProvider counts how many times it's asked for passwords.
+type countProvider struct {
+	passwords []string
+	count     int
+}
+
+func (p *countProvider) Passwords(string) []string {
+	p.count++
+	return p.passwords
+}
+
+func TestPasswordProviderOnce(t *testing.T) {
+	t.Parallel()
+
+	provider := &countProvider{passwords: []string{"wrong", "also wrong"}}
+	xFile := &xtractr.XFile{FilePath: testFile, OutputDir: t.TempDir(), PasswordProvider: provider}
+
+	_, _, _, err := xtractr.ExtractFile(xFile)
+	assert.ErrorIs(t, err, xtractr.ErrWrongPassword)
+	assert.Equal(t, 1, provider.count, "the provider is asked once for each archive")
+
+	_, _, err = xtractr.TestFile(xFile)
+	assert.ErrorIs(t, err, xtractr.ErrWrongPassword)
+	assert.Equal(t, 2, provider.count)
+
+	_, err = xtractr.ListFile(xFile)
+	assert.ErrorIs(t, err, xtractr.ErrWrongPassword)
+	assert.Equal(t, 3, provider.count)
+
+	// The list is made again for the next call, with the provider's new passwords.
+	provider.passwords = []string{"some_password"}
+	size, _, _, err := xtractr.ExtractFile(xFile)
+	assert.NoError(t, err)
+	assert.Equal(t, testDataSize, size)
+	assert.Equal(t, 4, provider.count)
+}
+
+func TestPasswordProviders(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "Some.Download{{folder}}", "sub{{nearest}}")
+	archive := filepath.Join(dir, "movie.rar")
+	assert.NoError(t, os.MkdirAll(dir, xtractr.DefaultDirMode))
+	assert.NoError(t, os.WriteFile(archive+".pass", []byte("one\r\n\ntwo\n"), xtractr.DefaultFileMode))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "movie.pass"), []byte("three"), xtractr.DefaultFileMode))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "password.txt"), []byte("four\n"), xtractr.DefaultFileMode))
+
+	assert.Equal(t, []string{"a", "b"}, xtractr.StaticPasswords{"a", "b"}.Passwords(archive))
+	assert.Equal(t, []string{"four"}, xtractr.PasswordFile(filepath.Join(dir, "password.txt")).Passwords(archive))
+	assert.Empty(t, xtractr.PasswordFile(filepath.Join(dir, "missing.txt")).Passwords(archive))
+	assert.Equal(t, []string{"one", "two", "three", "four"}, xtractr.SidecarPasswords{}.Passwords(archive))
+	assert.Equal(t, []string{"nearest", "folder"}, xtractr.FolderPassword{}.Passwords(archive))
+	assert.Equal(t, []string{"Download"},
+		xtractr.FolderPassword{Regexp: regexp.MustCompile(`^Some\.(\w+)`)}.Passwords(archive))
+	assert.Equal(t, []string{"a", "nearest", "folder"},
+		xtractr.PasswordProviders{xtractr.StaticPasswords{"a"}, nil, xtractr.FolderPassword{}}.Passwords(archive))
+}
+
+func TestPasswordProviderExtract(t *testing.T) {
+	t.Parallel()
+
+	dir := filepath.Join(t.TempDir(), "Download{{some_password}}")
+	testData, err := os.ReadFile(testFile)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(dir, xtractr.DefaultDirMode))
+	assert.NoError(t, makeFile(testData, filepath.Join(dir, "archive.rar")))
+
+	size, _, _, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:         filepath.Join(dir, "archive.rar"),
+		OutputDir:        filepath.Join(dir, "output"),
+		FileMode:         xtractr.DefaultFileMode,
+		DirMode:          xtractr.DefaultDirMode,
+		Passwords:        []string{"wrong"},
+		PasswordProvider: xtractr.FolderPassword{},
+	})
+	assert.NoError(t, err, "the password must be found in the folder name")
+	assert.Equal(t, testDataSize, size)
+}
+
+func TestPasswordCache(t *testing.T) {
+	t.Parallel()
+
+	queue := xtractr.NewQueue(&xtractr.Config{Logger: &testLogger{t: t}})
+	defer queue.Stop()
+
+	path := filepath.Join(t.TempDir(), "archives")
+	testData, err := os.ReadFile(testFile)
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(path, xtractr.DefaultDirMode))
+	assert.NoError(t, makeFile(testData, filepath.Join(path, "first.rar")))
+	assert.NoError(t, makeFile(testData, filepath.Join(path, "second.rar")))
+
+	xFile := &xtractr.Xtract{
+		Filter:           xtractr.Filter{Path: path},
+		TempFolder:       true,
+		PasswordProvider: &onceProvider{passwords: []string{"wrong", "some_password"}},
+		CBChannel:        make(chan *xtractr.Response),
+	}
+
+	_, err = queue.Extract(xFile)
+	assert.NoError(t, err)
+
+	for resp := range xFile.CBChannel {
+		if resp.Done {
+			// The provider only helps the first archive. The second uses the cached password.
+			assert.NoError(t, resp.Error)
+			assert.Equal(t, testDataSize*2, resp.Size)
+
+			break
+		}
+	}
+}
@@ -0,0 +1,358 @@
+package xtractr
+
+/* Code to peel compression layers off a file until a container (tar, ar, cpio) or a raw file remains. */
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxCompressionLayers stops peeling layers off a stream. Anything left is written as a file.
+const maxCompressionLayers = 8
+
+// compression is a compression layer that can be removed from a stream.
+type compression struct {
+	format Format
+	// suffixes are trimmed from the file name when this layer is removed.
+	// A suffix written as ".tgz:.tar" is replaced instead, so a tarball shorthand becomes .tar.
+	suffixes []string
+	// magic finds this layer by content. Layers without magic are only found by suffix.
+	magic      []byte
+	decompress decompressor
+	// size returns the uncompressed size from the file's trailer, or -1. Optional.
+	size func(file *os.File) int64
+}
+
+// container is an archive format that can be read from a stream.
+// What's left of a stream after its compression layers are removed is checked for these.
+type container struct {
+	format  Format
+	detect  func(header []byte) bool
+	extract func(x *XFile, stream io.Reader) (int64, []string, error)
+	list    func(x *XFile, stream io.Reader) ([]Entry, error)
+	test    func(x *XFile, stream io.Reader) (int64, []string, error)
+}
+
+// stream is a file with its compression layers removed. Read it to get the data inside.
+type stream struct {
+	*bufio.Reader
+	file    *os.File
+	closers []io.Closer
+	// name is the file's name with the suffixes of the removed layers trimmed.
+	name string
+	// layers are the compression formats removed, outermost first.
+	layers []*compression
+	// container is the archive format found inside. nil means the stream is a single file.
+	container *container
+}
+
+// compressions are checked in order; the first matching magic wins.
+//
+//nolint:gochecknoglobals
+var compressions = []*compression{
+	{
+		format:     FormatGzip,
+		suffixes:   []string{".tgz:.tar", ".gz"},
+		magic:      []byte("\x1f\x8b"),
+		decompress: newGzipReader,
+		size:       gzipSize,
+	},
+	{
+		format:     FormatBzip2,
+		suffixes:   []string{".tbz2:.tar", ".tbz:.tar", ".bz2", ".bz"},
+		magic:      []byte("BZh"),
+		decompress: newBzip2Reader,
+	},
+	{format: FormatXZ, suffixes: []string{".txz:.tar", ".xz"}, magic: []byte("\xfd7zXZ\x00"), decompress: newXZReader},
+	{
+		format:     FormatZstd,
+		suffixes:   []string{".tzst:.tar", ".zst", ".zstd"},
+		magic:      []byte("\x28\xb5\x2f\xfd"),
+		decompress: newZstdReader,
+	},
+	{format: FormatLZ4, suffixes: []string{".lz4"}, magic: []byte("\x04\x22\x4d\x18"), decompress: newLZ4Reader},
+	// lzma and brotli have no reliable magic bytes.
+	{format: FormatLZMA, suffixes: []string{".lzma"}, decompress: newLZMAReader},
+	{format: FormatBrotli, suffixes: []string{".br"}, decompress: newBrotliReader},
+}
+
+// containers returns the archive formats checked, in order, after the compression layers are removed.
+// It's a function, not a list, because the ar container peels the tarballs inside Debian packages.
+func containers() []*container {
+	return []*container{
+		{
+			format:  FormatTar,
+			detect:  isTarHeader,
+			extract: (*XFile).extractTar,
+			list:    (*XFile).listTarStream,
+			test:    (*XFile).testTar,
+		},
+		{
+			format:  FormatAr,
+			detect:  isArHeader,
+			extract: (*XFile).unar,
+			list:    (*XFile).listAr,
+			test:    (*XFile).testAr,
+		},
+		{
+			format:  FormatCpio,
+			detect:  isCpioHeader,
+			extract: (*XFile).uncpio,
+			list:    (*XFile).listCpio,
+			test:    (*XFile).testCpio,
+		},
+	}
+}
+
+// openStream opens a file and removes its compression layers. The formats in first are
+// removed first, without checking the file's content; they may end with a container format.
+// After that, layers are found by their magic bytes, or by suffix for layers without magic.
+// Close the stream when done.
+func openStream(filePath string, first ...Format) (*stream, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+
+	s, err := peel(file, filepath.Base(filePath), first)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	s.file = file
+	s.closers = append([]io.Closer{file}, s.closers...)
+
+	return s, nil
+}
+
+// peel removes compression layers from a reader, and finds the container inside.
+func peel(reader io.Reader, name string, first []Format) (*stream, error) {
+	s := &stream{Reader: bufio.NewReaderSize(reader, tarBlockSize), name: name}
+
+	for len(s.layers) < maxCompressionLayers {
+		var layer *compression
+
+		if len(first) > 0 {
+			if s.container = containerFor(first[0]); s.container != nil {
+				return s, nil
+			}
+
+			layer, first = compressionFor(first[0]), first[1:]
+		} else {
+			header, _ := s.Peek(tarBlockSize)
+			layer = detectCompression(header, s.name)
+		}
+
+		if layer == nil {
+			break
+		}
+
+		decompressed, err := layer.decompress(s.Reader)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+
+		s.closers = append(s.closers, decompressed)
+		s.layers = append(s.layers, layer)
+		s.name = layer.trim(s.name)
+		s.Reader = bufio.NewReaderSize(decompressed, tarBlockSize)
+	}
+
+	header, _ := s.Peek(tarBlockSize)
+	s.container = detectContainer(header)
+
+	return s, nil
+}
+
+// Close closes the decompressors and the file, innermost first.
+func (s *stream) Close() error {
+	var err error
+
+	for idx := len(s.closers) - 1; idx >= 0; idx-- {
+		if closeErr := s.closers[idx].Close(); err == nil {
+			err = closeErr
+		}
+	}
+
+	return err //nolint:wrapcheck
+}
+
+// compressionFor returns the compression layer for a format, or nil.
+func compressionFor(format Format) *compression {
+	for _, layer := range compressions {
+		if layer.format == format {
+			return layer
+		}
+	}
+
+	return nil
+}
+
+// containerFor returns the container for a format, or nil.
+func containerFor(format Format) *container {
+	for _, found := range containers() {
+		if found.format == format {
+			return found
+		}
+	}
+
+	return nil
+}
+
+// detectCompression returns the compression layer a header or a file name belongs to, or nil.
+func detectCompression(header []byte, name string) *compression {
+	for _, layer := range compressions {
+		if len(layer.magic) > 0 && bytes.HasPrefix(header, layer.magic) {
+			return layer
+		}
+	}
+
+	for _, layer := range compressions {
+		if len(layer.magic) == 0 && layer.trim(name) != name {
+			return layer
+		}
+	}
+
+	return nil
+}
+
+// detectContainer returns the container format a header belongs to, or nil.
+func detectContainer(header []byte) *container {
+	for _, found := range containers() {
+		if found.detect(header) {
+			return found
+		}
+	}
+
+	return nil
+}
+
+// trim removes this layer's suffix from a file name.
+func (c *compression) trim(name string) string {
+	lower := strings.ToLower(name)
+
+	for _, suffix := range c.suffixes {
+		suffix, replace, _ := strings.Cut(suffix, ":")
+		if strings.HasSuffix(lower, suffix) {
+			return name[:len(name)-len(suffix)] + replace
+		}
+	}
+
+	return name
+}
+
+// detectStream returns a Detect function that is true if a header is the start of a tarball,
+// under any number of compression layers. It tells a compressed tarball from a compressed file.
+func detectStream(first ...Format) func(header []byte) bool {
+	return func(header []byte) bool {
+		s, err := peel(bytes.NewReader(header), "", first)
+		if err != nil {
+			return false
+		}
+		defer s.Close()
+
+		return s.container != nil && s.container.format == FormatTar
+	}
+}
+
+// isTarHeader returns true if the header begins with a ustar tar header.
+func isTarHeader(header []byte) bool {
+	return len(header) >= tarBlockSize && bytes.Equal(header[257:262], []byte("ustar"))
+}
+
+// extractStream extracts a file through the pipeline: its compression layers are removed,
+// then the container inside is extracted. If there is no container, the decompressed data
+// is written as a single file, named after the archive without its compression suffixes.
+func (x *XFile) extractStream(first ...Format) (int64, []string, error) {
+	stream, err := openStream(x.FilePath, first...)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer stream.Close()
+
+	if stream.container != nil {
+		return stream.container.extract(x, stream)
+	}
+
+	// Get the absolute path of the file were writing.
+	wfile := x.clean(stream.name)
+
+	size, err := x.writeFile(wfile, stream, x.FileMode, x.DirMode)
+	if err != nil {
+		return size, nil, err
+	}
+
+	return size, []string{wfile}, nil
+}
+
+// listStream returns a list function that lists a file through the pipeline.
+func listStream(first ...Format) func(xFile *XFile) ([]Entry, error) {
+	return func(xFile *XFile) ([]Entry, error) {
+		stream, err := openStream(xFile.FilePath, first...)
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close()
+
+		if stream.container != nil {
+			entries, err := stream.container.list(xFile, stream)
+			for idx := range entries {
+				if len(stream.layers) == 0 { // Not compressed, so these are the same.
+					entries[idx].CompressedSize = entries[idx].Size
+				}
+			}
+
+			return entries, err
+		}
+
+		info, err := stream.file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("os.Stat: %w", err)
+		}
+
+		// Only the outer layer's trailer can be read without decompressing the file.
+		size := int64(-1)
+		if len(stream.layers) == 1 && stream.layers[0].size != nil {
+			size = stream.layers[0].size(stream.file)
+		}
+
+		return []Entry{newEntry(stream.name, size, info.Size(), xFile.FileMode, info.ModTime())}, nil
+	}
+}
+
+// testStream returns a test function that reads a file through the pipeline.
+// Each compression library verifies the checksums its format has.
+func testStream(first ...Format) func(xFile *XFile) (int64, []string, error) {
+	return func(xFile *XFile) (int64, []string, error) {
+		stream, err := openStream(xFile.FilePath, first...)
+		if err != nil {
+			return 0, nil, err
+		}
+		defer stream.Close()
+
+		if stream.container != nil {
+			return stream.container.test(xFile, stream)
+		}
+
+		failed := &IntegrityError{Archive: xFile.FilePath}
+		size := failed.discard(stream.name, stream)
+
+		return size, []string{xFile.FilePath}, failed.err()
+	}
+}
+
+func (x *XFile) extractTar(stream io.Reader) (int64, []string, error) {
+	return x.untar(tar.NewReader(stream))
+}
+
+func (x *XFile) listTarStream(stream io.Reader) ([]Entry, error) {
+	return x.listTar(tar.NewReader(stream))
+}
@@ -0,0 +1,84 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPipeline(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	data := bytes.Repeat([]byte("layered data\n"), 500)
+	gzipWriter := func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+	zstdWriter := func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+
+	// A zstd tarball, gzipped: two compression layers around a container.
+	zstdTar := &bytes.Buffer{}
+	assert.NoError(t, writeCompressed(zstdTar, zstdWriter, makeTar(t, map[string][]byte{"dir/file.txt": data})))
+
+	stacked := filepath.Join(dir, "stacked.tar.zst.gz")
+	assert.NoError(t, makeCompressedFile(stacked, gzipWriter, zstdTar.Bytes()))
+
+	format, err := xtractr.DetectFormat(stacked)
+	assert.NoError(t, err)
+	assert.Equal(t, xtractr.FormatTarGzip, format, "a tarball under any layers is a compressed tarball")
+
+	size, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: stacked, OutputDir: filepath.Join(dir, "stacked")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	assert.Equal(t, []string{filepath.Join(dir, "stacked", "dir", "file.txt")}, files)
+
+	entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: stacked})
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+
+	// A zip file, gzipped: zip needs random access, so it's written out for the queue to extract.
+	zipFile := filepath.Join(dir, "inner.zip")
+	assert.NoError(t, makeZipFile(zipFile, map[string]string{"a.txt": "a"}))
+
+	zipData, err := os.ReadFile(zipFile)
+	assert.NoError(t, err)
+
+	gzippedZip := filepath.Join(dir, "inner.zip.gz")
+	assert.NoError(t, makeCompressedFile(gzippedZip, gzipWriter, zipData))
+
+	size, files, _, err = xtractr.ExtractFile(&xtractr.XFile{FilePath: gzippedZip, OutputDir: filepath.Join(dir, "zip")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(zipData)), size)
+	assert.Equal(t, []string{filepath.Join(dir, "zip", "inner.zip")}, files)
+
+	entries, err = xtractr.ListFile(&xtractr.XFile{FilePath: gzippedZip})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"inner.zip"}, []string{entries[0].Name})
+	assert.Equal(t, int64(len(zipData)), entries[0].Size, "the gzip trailer has the size")
+
+	// The shorthand suffix becomes .tar when there is no tarball inside.
+	notTar := filepath.Join(dir, "file.tgz")
+	assert.NoError(t, makeCompressedFile(notTar, gzipWriter, data))
+
+	_, files, err = xtractr.ExtractGzip(&xtractr.XFile{FilePath: notTar, OutputDir: filepath.Join(dir, "tgz")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "tgz", "file.tar")}, files)
+}
+
+func writeCompressed(buf *bytes.Buffer, writer func(io.Writer) (io.WriteCloser, error), data []byte) error {
+	compressor, err := writer(buf)
+	if err != nil {
+		return err
+	}
+
+	if _, err := compressor.Write(data); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	return compressor.Close() //nolint:wrapcheck
+}
@@ -0,0 +1,205 @@
+package xtractr
+
+/* Code to report progress while files are written. */
+
+import (
+	"io"
+	"time"
+)
+
+// Progress is sent to a progress callback while an archive is extracted.
+type Progress struct {
+	// Archive being extracted.
+	Archive string
+	// File currently being written, inside the output folder.
+	Entry string
+	// Bytes written from Archive so far.
+	Wrote int64
+	// Expected bytes to write, from the archive's headers. 0 when unknown.
+	Total int64
+	// When this archive began extracting.
+	Started time.Time
+	// Bytes written per second, since Started.
+	Throughput float64
+}
+
+// tracker counts bytes as they are written, and calls the progress callback.
+type tracker struct {
+	Progress
+	callback func(Progress)
+	interval time.Duration
+	last     time.Time
+}
+
+// jobProgress tracks bytes written for all the archives in a queued Xtract.
+type jobProgress struct {
+	total int64 // expected bytes, from the headers of the archives found.
+	done  int64 // bytes written from archives that are finished.
+}
+
+// progressReader passes reads to a file in an archive, and counts them.
+type progressReader struct {
+	io.Reader
+	track *tracker
+	entry string
+}
+
+// ETA returns the estimated time until the archive is extracted. 0 when unknown.
+func (p Progress) ETA() time.Duration {
+	return eta(p.Wrote, p.Total, p.Throughput)
+}
+
+// eta returns how long it takes to write the rest of total at a throughput in bytes per second.
+func eta(wrote, total int64, throughput float64) time.Duration {
+	if total <= wrote || throughput <= 0 {
+		return 0
+	}
+
+	return time.Duration(float64(total-wrote) / throughput * float64(time.Second))
+}
+
+// startArchive is called before each archive is extracted. It resets the counters and
+// reads the archive's headers to find the expected total. Nothing is done without a callback.
+func (t *tracker) startArchive(xFile *XFile, reg *registration) {
+	if t.callback == nil {
+		return
+	}
+
+	t.Progress = Progress{Archive: xFile.FilePath, Started: time.Now(), Total: expectedSize(xFile, reg)}
+	t.last = time.Time{}
+}
+
+// expectedSize adds up the sizes of the files in an archive, from its headers or central directory.
+// Nothing is decompressed, so it's 0 (unknown) for formats that can't do that, like tarballs.
+// The headers are read once, with the password that worked in this folder, or the first one.
+func expectedSize(xFile *XFile, reg *registration) int64 {
+	ext, ok := reg.extractor.(*extractor)
+	if !ok || ext.size == nil {
+		return 0
+	}
+
+	attempt := *xFile
+	attempt.Password, attempt.Passwords = xFile.firstPassword(), nil
+
+	size, err := ext.size(&attempt)
+	if err != nil {
+		return 0
+	}
+
+	return size
+}
+
+// add counts bytes written to an entry, and calls the callback once per interval.
+func (t *tracker) add(entry string, count int) {
+	t.Entry = entry
+	t.Wrote += int64(count)
+
+	if now := time.Now(); now.Sub(t.last) >= t.interval {
+		t.send(now)
+	}
+}
+
+// finish calls the callback one last time, after an archive is extracted.
+func (t *tracker) finish() {
+	if t.callback != nil {
+		t.send(time.Now())
+	}
+}
+
+func (t *tracker) send(now time.Time) {
+	t.last = now
+
+	if elapsed := now.Sub(t.Started).Seconds(); elapsed > 0 {
+		t.Throughput = float64(t.Wrote) / elapsed
+	}
+
+	t.callback(t.Progress)
+}
+
+func (r *progressReader) Read(data []byte) (int, error) {
+	size, err := r.Reader.Read(data)
+	if size > 0 {
+		r.track.add(r.entry, size)
+	}
+
+	return size, err //nolint:wrapcheck
+}
+
+// newJobProgress reads the headers of every archive in a queued Xtract to find the expected
+// total. The total is 0 (unknown) if the size of any archive is unknown.
+func newJobProgress(resp *Response) *jobProgress {
+	job := &jobProgress{}
+
+	for _, archives := range resp.Archives {
+		for _, archive := range archives {
+			xFile := &XFile{
+				FilePath:  archive,
+				Password:  resp.X.Password,
+				Passwords: resp.X.Passwords,
+				passwords: resp.passwords,
+				SFX:       resp.X.SFX,
+			}
+
+			reg, err := registrationFor(archive, xFile.SFX)
+			if err != nil {
+				return &jobProgress{}
+			}
+
+			size := expectedSize(xFile, reg)
+			if size == 0 {
+				return &jobProgress{}
+			}
+
+			job.total += size
+		}
+	}
+
+	return job
+}
+
+// progress returns a progress callback for an archive's XFile that calls the Xtract's
+// Progress callback with a Response for the whole job. Returns nil if there is no callback.
+func (x *Xtractr) progress(resp *Response) func(Progress) {
+	if resp.X.Progress == nil || resp.job == nil {
+		return nil
+	}
+
+	return func(progress Progress) {
+		update := &Response{
+			X:        resp.X,
+			Started:  resp.Started,
+			Output:   resp.Output,
+			Queued:   len(x.queue),
+			Size:     resp.job.done + progress.Wrote,
+			Elapsed:  time.Since(resp.Started),
+			Progress: progress,
+		}
+
+		if seconds := update.Elapsed.Seconds(); seconds > 0 {
+			update.ETA = eta(update.Size, resp.job.total, float64(update.Size)/seconds)
+		}
+
+		resp.X.Progress(update)
+	}
+}
+
+// tracker returns the progress tracker for this extraction, creating it if needed.
+func (x *XFile) tracker() *tracker {
+	if x.track == nil {
+		x.track = &tracker{callback: x.Progress, interval: x.ProgressInterval}
+		if x.track.interval <= 0 {
+			x.track.interval = DefaultProgressInterval
+		}
+	}
+
+	return x.track
+}
+
+// reader wraps a reader for a file being written, so progress is reported while it's read.
+func (x *XFile) reader(fpath string, fdata io.Reader) io.Reader {
+	if x.Progress == nil {
+		return fdata
+	}
+
+	return &progressReader{Reader: fdata, track: x.tracker(), entry: fpath}
+}
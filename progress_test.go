@@ -0,0 +1,90 @@
+package xtractr_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProgress(t *testing.T) {
+	t.Parallel()
+
+	updates := []xtractr.Progress{}
+	size, _, _, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:         testFile,
+		OutputDir:        t.TempDir(),
+		Password:         "some_password",
+		FileMode:         xtractr.DefaultFileMode,
+		DirMode:          xtractr.DefaultDirMode,
+		Progress:         func(p xtractr.Progress) { updates = append(updates, p) },
+		ProgressInterval: time.Nanosecond,
+	})
+	assert.NoError(t, err)
+	assert.Greater(t, len(updates), 1, "progress must be reported while files are written")
+
+	last := updates[len(updates)-1]
+	assert.Equal(t, testFile, last.Archive)
+	assert.Equal(t, size, last.Wrote, "the last update must include every byte written")
+	assert.Equal(t, testDataSize, last.Total, "the total must be read from the archive headers")
+	assert.Equal(t, time.Duration(0), last.ETA(), "nothing is left to write")
+}
+
+func TestProgressUnknownTotal(t *testing.T) {
+	t.Parallel()
+
+	// A tarball's sizes are only found by decompressing it, so the total is unknown.
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "files.tar.gz")
+	assert.NoError(t, makeTarGzFile(archive, map[string]string{"one.txt": "one", "two.txt": "two"}))
+
+	var last xtractr.Progress
+
+	size, _, _, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:  archive,
+		OutputDir: filepath.Join(dir, "out"),
+		Progress:  func(p xtractr.Progress) { last = p },
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, size, last.Wrote)
+	assert.Zero(t, last.Total)
+	assert.Zero(t, last.ETA())
+}
+
+func TestQueueProgress(t *testing.T) {
+	t.Parallel()
+
+	queue := xtractr.NewQueue(&xtractr.Config{Logger: &testLogger{t: t}})
+	defer queue.Stop()
+
+	updates := []*xtractr.Response{}
+	xFile := &xtractr.Xtract{
+		Filter:           xtractr.Filter{Path: testSetupTestDir(t)},
+		Password:         "some_password",
+		TempFolder:       true,
+		Progress:         func(resp *xtractr.Response) { updates = append(updates, resp) },
+		ProgressInterval: time.Nanosecond,
+		CBChannel:        make(chan *xtractr.Response),
+	}
+
+	_, err := queue.Extract(xFile)
+	assert.NoError(t, err)
+
+	for resp := range xFile.CBChannel {
+		if !resp.Done {
+			continue
+		}
+
+		assert.NoError(t, resp.Error)
+		assert.NotEmpty(t, updates, "progress must be reported")
+		assert.Equal(t, resp.Size, updates[len(updates)-1].Size, "progress must add up all archives")
+
+		break
+	}
+
+	os.RemoveAll(xFile.Path)
+	os.RemoveAll(xFile.Path + xtractr.DefaultSuffix)
+}
@@ -3,6 +3,7 @@ package xtractr
 /* This file contains methods that support the extract queuing system. */
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -18,10 +19,14 @@ import (
 type Xtract struct {
 	// Unused in this app; exposed for calling library.
 	Name string
-	// Archive password. Only supported with RAR and 7zip files. Prepended to Passwords.
+	// Archive password. Only supported with RAR, 7zip and ZIP files. Prepended to Passwords.
 	Password string
-	// Archive passwords (try multiple). Only supported with RAR and 7zip files.
+	// Archive passwords (try multiple). Only supported with RAR, 7zip and ZIP files.
 	Passwords []string
+	// PasswordProvider is asked for more passwords to try for each archive. Optional.
+	// Passwords that work are remembered, and tried first for the other archives
+	// in the same folder and for the archives extracted from them.
+	PasswordProvider PasswordProvider
 	// Folder path and filters describing where and how to find archives.
 	Filter
 	// Set DisableRecursion to true if you want to avoid extracting archives inside archives.
@@ -38,10 +43,38 @@ type Xtract struct {
 	DeleteOrig bool
 	// Create a log (.txt) file of the extraction information.
 	LogFile bool
+	// Limits protect against hostile archives (zip bombs). Zero values mean no limit.
+	// MaxTotalSize and MaxEntries apply to all archives in this Xtract, combined.
+	Limits
+	// Set ExpandDeb to true to extract the tarballs inside Debian packages (.deb) in one step.
+	// See XFile.ExpandDeb. Without it, the tarballs are extracted by recursion instead.
+	ExpandDeb bool
+	// NameCharset decodes zip file names that are not UTF-8. See XFile.NameCharset.
+	NameCharset string
+	// NamePolicy changes the names of the files written. See XFile.NamePolicy.
+	NamePolicy NamePolicy
+	// Set TestOnly to true to run an integrity test on the archives instead of extracting them.
+	// Nothing is written, moved or deleted. Response.Size is the amount of data tested.
+	// See TestFile for details.
+	TestOnly bool
+	// Context cancels this extraction. Extraction stops between files and while writing a file.
+	// Partial output is removed, and Response.Error is the context's error, ie. context.Canceled.
+	// An Xtract that is cancelled while it waits in the queue is not started. Optional.
+	Context context.Context //nolint:containedctx
+	// Progress is called while files are written, once per ProgressInterval for each archive.
+	// The Response has the Progress of the current archive, the bytes written so far
+	// (Size) and an ETA for all the archives found. Optional.
+	Progress func(*Response)
+	// How often to call Progress. Default is DefaultProgressInterval.
+	ProgressInterval time.Duration
 	// Callback Function, runs twice per queued item.
 	CBFunction func(*Response)
 	// Callback Channel, msg sent twice per queued item.
 	CBChannel chan *Response
+	// journalID identifies this job in the Config.Journal file.
+	journalID uint64
+	// restores is the journalID of the unfinished job this one was restored from.
+	restores uint64
 }
 
 // Response is sent to the call-back function. The first CBFunction call is just
@@ -71,6 +104,17 @@ type Response struct {
 	Error error
 	// Copied from input data.
 	X *Xtract
+	// Progress of the archive being extracted. Only set in Xtract.Progress callbacks.
+	Progress Progress
+	// Estimated time until all the archives are extracted. Only set in Xtract.Progress callbacks.
+	// 0 when unknown. Archives found inside archives are not included in the estimate.
+	ETA time.Duration
+	// passwords remembers which passwords worked in each folder.
+	passwords *passwordCache
+	// job tracks data written, so X.Progress can report on all archives.
+	job *jobProgress
+	// limit tracks data written, so X.Limits can be enforced across all archives.
+	limit *limiter
 }
 
 // Extract is how external code begins an extraction process against a path.
@@ -81,12 +125,26 @@ func (x *Xtractr) Extract(extract *Xtract) (int, error) {
 		return -1, ErrQueueStopped
 	}
 
+	if err := x.journal.write(extract, journalQueued); err != nil {
+		x.config.Printf("Error: %v", err)
+	}
+
 	queueSize := len(x.queue) + 1
 	x.queue <- extract // goes to processQueue()
 
 	return queueSize, nil
 }
 
+// outputPath returns the temporary folder a job extracts into.
+func (x *Xtractr) outputPath(ext *Xtract) string {
+	output := strings.TrimRight(ext.Filter.Path, `/\`) + x.config.Suffix
+	if ext.ExtractTo != "" {
+		output = filepath.Join(ext.ExtractTo, filepath.Base(output))
+	}
+
+	return output
+}
+
 // processQueue runs in a go routine, 'x.Parallel' times,
 // and watches for things to extract.
 func (x *Xtractr) processQueue() {
@@ -100,18 +158,23 @@ func (x *Xtractr) processQueue() {
 // extract is where the real work begins and files get extracted.
 // This is fired off from processQueue() in a go routine.
 func (x *Xtractr) extract(ext *Xtract) {
+	if ext.Context != nil && ext.Context.Err() != nil {
+		x.finishExtract(&Response{X: ext, Started: time.Now()}, ext.Context.Err())
+		return
+	}
+
+	if err := x.journal.write(ext, journalStarted); err != nil {
+		x.config.Printf("Error: %v", err)
+	}
+
 	resp := &Response{
 		X:        ext,
 		Started:  time.Now(),
-		Output:   strings.TrimRight(ext.Filter.Path, `/\`) + x.config.Suffix, // tmp folder.
+		Output:   x.outputPath(ext), // tmp folder.
 		Archives: FindCompressedFiles(ext.Filter),
 		Queued:   len(x.queue),
 	}
 
-	if ext.ExtractTo != "" {
-		resp.Output = filepath.Join(ext.ExtractTo, filepath.Base(resp.Output))
-	}
-
 	if len(resp.Archives) < 1 { // no archives to xtract, bail out.
 		x.finishExtract(resp, ErrNoCompressedFiles)
 
@@ -128,21 +191,69 @@ func (x *Xtractr) extract(ext *Xtract) {
 
 	// Create another pointer to avoid race conditions in the callbacks above.
 	resp2 := &Response{
-		X:        ext,
-		Started:  resp.Started,
-		Output:   resp.Output,
-		Archives: make(map[string][]string),
-		Extras:   make(map[string][]string),
+		X:         ext,
+		Started:   resp.Started,
+		Output:    resp.Output,
+		Archives:  make(map[string][]string),
+		Extras:    make(map[string][]string),
+		limit:     newLimiter(ext.Limits),
+		passwords: &passwordCache{},
 	}
 
 	for k, v := range resp.Archives {
 		resp2.Archives[k] = append(resp2.Archives[k], v...)
 	}
 
+	if ext.Progress != nil {
+		resp2.job = newJobProgress(resp2)
+	}
+
+	if ext.TestOnly {
+		x.finishExtract(resp2, x.testArchives(resp2))
+		return
+	}
+
 	// e.log("Starting: %d archives - %v", len(resp.Archives), ex.SearchPath)
 	x.finishExtract(resp2, x.decompressFolders(resp2))
 }
 
+// testArchives runs an integrity test on every archive found, instead of extracting them.
+func (x *Xtractr) testArchives(resp *Response) error {
+	for parentDir, archives := range resp.Archives {
+		allArchives := []string{}
+
+		for _, archive := range archives {
+			x.config.Debugf("Testing File: %v", archive)
+
+			if ctx := resp.X.Context; ctx != nil && ctx.Err() != nil {
+				return ctx.Err() //nolint:wrapcheck
+			}
+
+			size, archives, err := TestFile(&XFile{
+				FilePath:         archive,
+				Password:         resp.X.Password,
+				Passwords:        resp.X.Passwords,
+				PasswordProvider: resp.X.PasswordProvider,
+				NameCharset:      resp.X.NameCharset,
+				passwords:        resp.passwords,
+			})
+			resp.Size += size
+
+			if len(archives) != 0 {
+				allArchives = append(allArchives, archives...)
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		resp.Archives[parentDir] = allArchives
+	}
+
+	return nil
+}
+
 // decompressFolders extracts each folder individually,
 // or the extracted files may be copied back to where they were extracted from.
 // If the extracted data is not being coppied back, then the tempDir (output) paths match the input paths.
@@ -159,20 +270,33 @@ func (x *Xtractr) decompressFolders(resp *Response) error {
 		subResp := &Response{
 			X: &Xtract{
 				Filter: Filter{
-					Path:          subDir,
-					ExcludeSuffix: resp.X.Filter.ExcludeSuffix,
+					Path:            subDir,
+					ExcludeSuffix:   resp.X.Filter.ExcludeSuffix,
+					DetectByContent: resp.X.Filter.DetectByContent,
+					SFX:             resp.X.Filter.SFX,
 				},
-				Name:       resp.X.Name,
-				Password:   resp.X.Password,
-				Passwords:  resp.X.Passwords,
-				ExtractTo:  resp.X.ExtractTo,
-				DeleteOrig: resp.X.DeleteOrig,
-				TempFolder: resp.X.TempFolder,
-				LogFile:    resp.X.LogFile,
+				Name:             resp.X.Name,
+				Password:         resp.X.Password,
+				Passwords:        resp.X.Passwords,
+				PasswordProvider: resp.X.PasswordProvider,
+				ExtractTo:        resp.X.ExtractTo,
+				DeleteOrig:       resp.X.DeleteOrig,
+				TempFolder:       resp.X.TempFolder,
+				LogFile:          resp.X.LogFile,
+				Limits:           resp.X.Limits,
+				ExpandDeb:        resp.X.ExpandDeb,
+				NameCharset:      resp.X.NameCharset,
+				NamePolicy:       resp.X.NamePolicy,
+				Context:          resp.X.Context,
+				Progress:         resp.X.Progress,
+				ProgressInterval: resp.X.ProgressInterval,
 			},
-			Started:  resp.Started,
-			Output:   output,
-			Archives: map[string][]string{subDir: resp.Archives[subDir]},
+			Started:   resp.Started,
+			Output:    output,
+			Archives:  map[string][]string{subDir: resp.Archives[subDir]},
+			limit:     resp.limit,
+			job:       resp.job,
+			passwords: resp.passwords,
 		}
 
 		err := x.decompressFiles(subResp)
@@ -202,6 +326,14 @@ func (x *Xtractr) finishExtract(resp *Response, err error) {
 		x.cleanTempFolder(resp)
 	}
 
+	if err != nil && resp.X.Context != nil && resp.X.Context.Err() != nil {
+		err = resp.X.Context.Err() // report cancellations plainly.
+	}
+
+	if err := x.journal.write(resp.X, journalFinished); err != nil {
+		x.config.Printf("Error: %v", err)
+	}
+
 	resp.Error = err
 	resp.Elapsed = time.Since(resp.Started)
 	resp.Done = true
@@ -255,36 +387,93 @@ func (x *Xtractr) decompressFiles(resp *Response) error {
 		return x.cleanupProcessedArchives(resp)
 	}
 
-	// Now do it again with the output folder.
-	resp.Extras = FindCompressedFiles(Filter{
-		Path:          resp.Output,
-		ExcludeSuffix: resp.X.ExcludeSuffix,
-	})
-	nre := &Response{
-		X: &Xtract{
-			Password:  resp.X.Password,
-			Passwords: resp.X.Passwords,
-		},
-		Started:  resp.Started,
-		Output:   resp.Output,
-		Archives: resp.Extras,
+	// Now do it again with the output folder, once for each level of nested archives.
+	maxDepth := resp.X.MaxDepth
+	if maxDepth < 1 {
+		maxDepth = 1
 	}
-	err := x.decompressArchives(nre)
-	// Combine the new Response with the existing response.
-	resp.Extras = nre.Archives
-	resp.Size += nre.Size
 
-	if nre.NewFiles != nil {
-		resp.NewFiles = append(resp.NewFiles, nre.NewFiles...)
-	}
+	resp.Extras = make(map[string][]string)
+	seen := make(map[string]bool)
 
-	if err != nil {
-		return err
+	for depth := 1; ; depth++ {
+		extras := unseenArchives(FindCompressedFiles(Filter{
+			Path:            resp.Output,
+			ExcludeSuffix:   resp.X.ExcludeSuffix,
+			DetectByContent: resp.X.DetectByContent,
+			SFX:             resp.X.SFX,
+		}), seen)
+
+		if len(extras) == 0 {
+			break
+		} else if depth > maxDepth && resp.X.MaxDepth < 1 {
+			break // Only one level of nested archives is extracted by default.
+		} else if depth > maxDepth {
+			x.DeleteFiles(resp.Output) // clean up the mess after an error and bail.
+			return fmt.Errorf("%w: archives nested more than %d levels deep", ErrDepthLimit, resp.X.MaxDepth)
+		}
+
+		nre := &Response{
+			X: &Xtract{
+				Password:         resp.X.Password,
+				Passwords:        resp.X.Passwords,
+				PasswordProvider: resp.X.PasswordProvider,
+				Limits:           resp.X.Limits,
+				ExpandDeb:        resp.X.ExpandDeb,
+				NameCharset:      resp.X.NameCharset,
+				NamePolicy:       resp.X.NamePolicy,
+				Filter:           Filter{SFX: resp.X.SFX},
+				Context:          resp.X.Context,
+				Progress:         resp.X.Progress,
+				ProgressInterval: resp.X.ProgressInterval,
+			},
+			Started:   resp.Started,
+			Output:    resp.Output,
+			Archives:  extras,
+			limit:     resp.limit,
+			job:       resp.job,
+			passwords: resp.passwords,
+		}
+		err := x.decompressArchives(nre)
+		// Combine the new Response with the existing response.
+		resp.Size += nre.Size
+
+		for k, v := range nre.Archives {
+			resp.Extras[k] = append(resp.Extras[k], v...)
+			for _, archive := range v {
+				seen[archive] = true
+			}
+		}
+
+		if nre.NewFiles != nil {
+			resp.NewFiles = append(resp.NewFiles, nre.NewFiles...)
+		}
+
+		if err != nil {
+			return err
+		}
 	}
 
 	return x.cleanupProcessedArchives(resp)
 }
 
+// unseenArchives removes archives that were already extracted from a list of found archives.
+// The remaining archives are marked as seen.
+func unseenArchives(found map[string][]string, seen map[string]bool) map[string][]string {
+	archives := make(map[string][]string)
+
+	for folder, files := range found {
+		for _, file := range files {
+			if !seen[file] {
+				seen[file] = true
+				archives[folder] = append(archives[folder], file)
+			}
+		}
+	}
+
+	return archives
+}
+
 func (x *Xtractr) decompressArchives(resp *Response) error {
 	for parentDir, archives := range resp.Archives {
 		allArchives := []string{}
@@ -321,13 +510,28 @@ func (x *Xtractr) processArchive(filename string, resp *Response) (int64, []stri
 	x.config.Debugf("Extracting File: %v to %v", filename, resp.Output)
 
 	bytes, files, archives, err := ExtractFile(&XFile{ // extract the file.
-		FilePath:  filename,
-		OutputDir: resp.Output,
-		FileMode:  x.config.FileMode,
-		DirMode:   x.config.DirMode,
-		Passwords: resp.X.Passwords,
-		Password:  resp.X.Password,
+		FilePath:         filename,
+		OutputDir:        resp.Output,
+		FileMode:         x.config.FileMode,
+		DirMode:          x.config.DirMode,
+		Passwords:        resp.X.Passwords,
+		Password:         resp.X.Password,
+		PasswordProvider: resp.X.PasswordProvider,
+		Limits:           resp.X.Limits,
+		ExpandDeb:        resp.X.ExpandDeb,
+		NameCharset:      resp.X.NameCharset,
+		NamePolicy:       resp.X.NamePolicy,
+		SFX:              resp.X.SFX,
+		limit:            resp.limit,
+		ctx:              resp.X.Context,
+		passwords:        resp.passwords,
+		Progress:         x.progress(resp),
+		ProgressInterval: resp.X.ProgressInterval,
 	})
+	if resp.job != nil {
+		resp.job.done += bytes
+	}
+
 	if err != nil {
 		x.DeleteFiles(resp.Output) // clean up the mess after an error and bail.
 	}
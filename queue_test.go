@@ -1,10 +1,12 @@
 package xtractr_test
 
 import (
+	"context"
 	"github.com/fmzchao/xtractr"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -120,6 +122,70 @@ func TestNoTempFolder(t *testing.T) {
 	os.RemoveAll(xFile.Path + xtractr.DefaultSuffix)
 }
 
+func TestCancel(t *testing.T) {
+	t.Parallel()
+
+	queue := xtractr.NewQueue(&xtractr.Config{Logger: &testLogger{t: t}})
+	defer queue.Stop()
+
+	name := t.TempDir()
+	assert.NoError(t, makeZipFile(filepath.Join(name, "large.zip"), map[string]string{
+		"large.bin": strings.Repeat("large file ", 1024*1024),
+	}))
+
+	// Cancel the job after a megabyte of the large file is written.
+	ctx := newCopyCanceler(filepath.Join(name+xtractr.DefaultSuffix, "large.bin"), 1024*1024)
+	defer ctx.cancel()
+
+	xFile := &xtractr.Xtract{
+		Filter:    xtractr.Filter{Path: name},
+		Context:   ctx,
+		CBChannel: make(chan *xtractr.Response),
+	}
+
+	_, err := queue.Extract(xFile)
+	assert.NoError(t, err)
+
+	for resp := range xFile.CBChannel {
+		if !resp.Done {
+			continue
+		}
+
+		assert.Equal(t, context.Canceled, resp.Error, "cancelled jobs must report context.Canceled")
+		assert.Equal(t, int64(0), resp.Size, "nothing should be extracted after cancelling")
+		assert.NoDirExists(t, resp.Output, "partial output must be removed")
+
+		break
+	}
+
+	assert.True(t, ctx.stopped, "the job must be cancelled while the large file is written")
+}
+
+// copyCanceler is a context that cancels itself once a file grows past a size.
+// Err is checked while files are written, so this cancels an extraction part way through a copy.
+type copyCanceler struct {
+	context.Context //nolint:containedctx
+	cancel          context.CancelFunc
+	file            string
+	size            int64
+	stopped         bool
+}
+
+func newCopyCanceler(file string, size int64) *copyCanceler {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &copyCanceler{Context: ctx, cancel: cancel, file: file, size: size}
+}
+
+func (c *copyCanceler) Err() error {
+	if info, err := os.Stat(c.file); err == nil && info.Size() >= c.size && c.Context.Err() == nil {
+		c.stopped = true
+		c.cancel()
+	}
+
+	return c.Context.Err() //nolint:wrapcheck
+}
+
 // testSetupTestDir creates a temp directory with 4 copies of a rar archive in it.
 func testSetupTestDir(t *testing.T) string {
 	t.Helper()
@@ -6,85 +6,207 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
+	"io/fs"
 	"os"
-	"path/filepath"
-	"strings"
 
-	"github.com/nwaples/rardecode"
+	"github.com/nwaples/rardecode/v2"
 )
 
+// rarVolumes is the file system the rar reader opens volumes with. It records each volume
+// it opens, so they can be returned as the archives that were processed.
+type rarVolumes struct {
+	files   []string
+	missing bool // a volume could not be found.
+}
+
+// ExtractRAR extracts a rar archive. RAR versions 1.5 through 5 are supported, including
+// encrypted headers and multi-volume sets (name.part01.rar or name.rar, name.r00).
+// Pass the first volume; the rest are found by name. Every volume read is returned as an archive.
 func ExtractRAR(xFile *XFile) (int64, []string, []string, error) {
-	if len(xFile.Passwords) == 0 && xFile.Password == "" {
+	passwords := xFile.passwordList()
+	if len(passwords) == 1 {
 		return extractRAR(xFile)
 	}
 
-	// Try all the passwords.
-	passwords := xFile.Passwords
+	limit := xFile.limiter() // shared by every attempt.
 
-	if xFile.Password != "" { // If a single password is provided, try it first.
-		passwords = append([]string{xFile.Password}, xFile.Passwords...)
-	}
+	// Try all the passwords. The last one is blank: no password.
+	for idx, password := range passwords[:len(passwords)-1] {
+		attempt := *xFile
+		attempt.Password, attempt.Passwords = password, nil
 
-	for idx, password := range passwords {
-		size, files, archives, err := extractRAR(&XFile{
-			FilePath:  xFile.FilePath,
-			OutputDir: xFile.OutputDir,
-			FileMode:  xFile.FileMode,
-			DirMode:   xFile.DirMode,
-			Password:  password,
-		})
+		counted := limit.save()
+
+		size, files, archives, err := extractRAR(&attempt)
 		if err == nil {
+			xFile.passwordWorked(password)
 			return size, files, archives, nil
-		} else {
-			fmt.Println(err)
 		}
 
 		// https://github.com/nwaples/rardecode/issues/28
-		if strings.Contains(err.Error(), "incorrect password") {
+		if errors.Is(err, ErrWrongPassword) {
+			limit.restore(counted)
 			continue
 		}
 
-		return size, files, archives, fmt.Errorf("used password %d of %d: %w", idx+1, len(passwords), err)
+		return size, files, archives, fmt.Errorf("used password %d of %d: %w", idx+1, len(passwords)-1, err)
 	}
 
 	// No password worked, try without a password.
-	return extractRAR(&XFile{
-		FilePath:  xFile.FilePath,
-		OutputDir: xFile.OutputDir,
-		FileMode:  xFile.FileMode,
-		DirMode:   xFile.DirMode,
-	})
+	attempt := *xFile
+	attempt.Password, attempt.Passwords = "", nil
+
+	size, files, archives, err := extractRAR(&attempt)
+
+	return size, files, archives, wrongPassword(err)
 }
 
-// ExtractRAR extracts a rar file. to a destination. This wraps github.com/nwaples/rardecode.
+// extractRAR extracts a rar file. to a destination. This wraps github.com/nwaples/rardecode/v2.
 func extractRAR(xFile *XFile) (int64, []string, []string, error) {
-	rarReader, err := rardecode.OpenReader(xFile.FilePath, xFile.Password)
+	rarReader, volumes, err := openRAR(xFile, xFile.Password)
 	if err != nil {
-		return 0, nil, nil, fmt.Errorf("rardecode.OpenReader: %w", err)
+		return 0, nil, volumes.files, archiveError(err, xFile.Password != "")
 	}
 	defer rarReader.Close()
 
-	size, files, err := xFile.unrar(rarReader)
+	size, files, err := xFile.unrar(rarReader.Reader)
+	if volumes.missing {
+		// The next volume may be opened while a file is copied, not only by Next().
+		err = volumeError(err)
+	}
+
+	err = archiveError(err, xFile.Password != "")
 	if err != nil {
-		lastFile := xFile.FilePath
-		if volumes := rarReader.Volumes(); len(volumes) > 0 {
-			lastFile = volumes[len(volumes)-1]
+		return size, files, volumes.files, fmt.Errorf("%s: %w", volumes.last(xFile.FilePath), err)
+	}
+
+	return size, files, volumes.files, nil
+}
+
+// rarArchive is a rar archive opened from its volumes, or from a self-extracting archive.
+type rarArchive struct {
+	*rardecode.Reader
+	io.Closer
+}
+
+// openRAR opens a rar archive. The volumes it reads are recorded in the returned rarVolumes.
+func openRAR(xFile *XFile, password string) (*rarArchive, *rarVolumes, error) {
+	volumes := &rarVolumes{}
+	opts := []rardecode.Option{rardecode.FileSystem(volumes)}
+
+	if password != "" {
+		opts = append(opts, rardecode.Password(password))
+	}
+
+	if xFile.sfxOffset != 0 {
+		file, section, err := xFile.openSFX()
+		if err != nil {
+			return nil, volumes, err
 		}
 
-		return size, files, rarReader.Volumes(), fmt.Errorf("%s: %w", lastFile, err)
+		rarReader, err := rardecode.NewReader(section, opts...)
+		if err != nil {
+			file.Close()
+			return nil, volumes, fmt.Errorf("rardecode.NewReader: %w", err)
+		}
+
+		volumes.files = []string{xFile.FilePath}
+
+		return &rarArchive{Reader: rarReader, Closer: file}, volumes, nil
+	}
+
+	rarReader, err := rardecode.OpenReader(xFile.FilePath, opts...)
+	if err != nil {
+		return nil, volumes, fmt.Errorf("rardecode.OpenReader: %w", err)
 	}
 
-	return size, files, rarReader.Volumes(), nil
+	return &rarArchive{Reader: &rarReader.Reader, Closer: rarReader}, volumes, nil
 }
 
-func (x *XFile) unrar(rarReader *rardecode.ReadCloser) (int64, []string, error) {
-	defer func() {
-		if r := recover(); r != nil {
-			log.Printf("Program recovered from error: %v\n", r)
+// Open opens a volume, and records it.
+func (v *rarVolumes) Open(name string) (fs.File, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		v.missing = errors.Is(err, fs.ErrNotExist)
+		return nil, err //nolint:wrapcheck
+	}
+
+	for _, volume := range v.files {
+		if volume == name {
+			return file, nil
 		}
-	}()
+	}
 
+	v.files = append(v.files, name)
+
+	return file, nil
+}
+
+// last returns the last volume opened, or the provided path if none were.
+func (v *rarVolumes) last(path string) string {
+	if len(v.files) == 0 {
+		return path
+	}
+
+	return v.files[len(v.files)-1]
+}
+
+// sizeRAR adds up the sizes of the files in a rar archive from their headers. Unlike
+// listRAR, nothing in a solid archive is decompressed to get from one header to the next.
+func sizeRAR(xFile *XFile) (int64, error) {
+	opts := []rardecode.Option{}
+	if xFile.Password != "" {
+		opts = append(opts, rardecode.Password(xFile.Password))
+	}
+
+	files, err := rardecode.List(xFile.FilePath, opts...)
+	if err != nil {
+		return 0, fmt.Errorf("rardecode.List: %w", err)
+	}
+
+	total := int64(0)
+
+	for _, file := range files {
+		if !file.UnKnownSize && !file.IsDir {
+			total += file.UnPackedSize
+		}
+	}
+
+	return total, nil
+}
+
+// listRAR lists the contents of a rar archive by reading its file headers.
+func listRAR(xFile *XFile) ([]Entry, error) {
+	rarReader, _, err := openRAR(xFile, xFile.Password)
+	if err != nil {
+		return nil, err
+	}
+	defer rarReader.Close()
+
+	entries := []Entry{}
+
+	for {
+		header, err := rarReader.Next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return entries, nil
+		case err != nil:
+			return entries, fmt.Errorf("rarReader.Next: %w", volumeError(err))
+		case header == nil:
+			return entries, fmt.Errorf("%w: %s", ErrInvalidHead, xFile.FilePath)
+		}
+
+		entry := newEntry(header.Name, header.UnPackedSize, header.PackedSize, header.Mode(), header.ModificationTime)
+		if entry.Encrypted = header.Encrypted; header.UnKnownSize {
+			entry.Size = -1
+		}
+
+		entries = append(entries, entry)
+	}
+}
+
+func (x *XFile) unrar(rarReader *rardecode.Reader) (int64, []string, error) {
 	files := []string{}
 	size := int64(0)
 
@@ -93,47 +215,32 @@ func (x *XFile) unrar(rarReader *rardecode.ReadCloser) (int64, []string, error)
 
 		switch {
 		case errors.Is(err, io.EOF):
-			// 如果是正常的 EOF，结束循环
 			return size, files, nil
 		case err != nil:
-			if errors.Is(err, io.ErrUnexpectedEOF) || strings.Contains(err.Error(), "copying io") || strings.Contains(err.Error(), "bad header crc") {
-				// 如果是 unexpected EOF，忽略这个错误并继续
-				return size, files, nil
-			}
-			// 其他错误，退出并返回错误
-			return size, files, fmt.Errorf("rarReader.Next: %w", err)
+			return size, files, fmt.Errorf("rarReader.Next: %w", archiveError(volumeError(err), x.Password != ""))
 		case header == nil:
-			// 如果 header 为 nil，返回错误
 			return size, files, fmt.Errorf("%w: %s", ErrInvalidHead, x.FilePath)
 		}
 
-		wfile := x.clean(header.Name)
-		//nolint:gocritic // this 1-argument filepath.Join removes a ./ prefix should there be one.
-		if !strings.HasPrefix(wfile, filepath.Join(x.OutputDir)) {
-			// The file being written is trying to write outside of our base path. Malicious archive?
-			return size, files, fmt.Errorf("%s: %w: %s != %s (from: %s)",
-				x.FilePath, ErrInvalidPath, wfile, x.OutputDir, header.Name)
+		wfile, err := x.outputPath(header.Name)
+		if err != nil {
+			return size, files, fmt.Errorf("%s: %w", x.FilePath, err)
+		} else if wfile == "" {
+			continue
 		}
 
 		if header.IsDir {
 			if err = os.MkdirAll(wfile, x.DirMode); err != nil {
-				//return size, files, fmt.Errorf("os.MkdirAll: %w", err)
-				log.Printf("Error creating directory: %v", err)
-				continue
+				return size, files, fmt.Errorf("os.MkdirAll: %w", err)
 			}
 
 			continue
 		}
 
-		if err = os.MkdirAll(filepath.Dir(wfile), x.DirMode); err != nil {
-			log.Printf("Error creating directory: %v", err)
-			continue
-			//return size, files, fmt.Errorf("os.MkdirAll: %w", err)
-		}
-
-		fSize, err := writeFile(wfile, rarReader, x.FileMode, x.DirMode)
-		if err != nil && (!strings.Contains(err.Error(), "unexpected EOF")) && (!strings.Contains(err.Error(), "copying io")) && (!strings.Contains(err.Error(), "bad header crc")) {
-			return size, files, err
+		fSize, err := x.writeFile(wfile, rarReader, x.FileMode, x.DirMode)
+		if err != nil {
+			os.Remove(wfile) // partial.
+			return size + fSize, files, fmt.Errorf("%s: %w", header.Name, archiveError(err, x.Password != ""))
 		}
 
 		files = append(files, wfile)
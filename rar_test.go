@@ -1,7 +1,12 @@
 package xtractr_test
 
 import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/fmzchao/xtractr"
@@ -28,3 +33,105 @@ func TestExtractRAR(t *testing.T) {
 	assert.Equal(t, 1, len(archives))
 	assert.Equal(t, len(filesInTestArchive), len(files))
 }
+
+func TestExtractRARVolumes(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	data := []byte(strings.Repeat("multi-volume rar data. ", 100))
+	first := filepath.Join(dir, "set.part1.rar")
+
+	assert.NoError(t, makeRAR5Volumes(filepath.Join(dir, "set.part%d.rar"), "file.txt", data, 3))
+
+	size, files, archives, err := xtractr.ExtractRAR(&xtractr.XFile{FilePath: first, OutputDir: filepath.Join(dir, "out")})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	assert.Equal(t, []string{filepath.Join(dir, "out", "file.txt")}, files)
+	assert.Equal(t, []string{first, filepath.Join(dir, "set.part2.rar"), filepath.Join(dir, "set.part3.rar")}, archives)
+
+	written, err := os.ReadFile(filepath.Join(dir, "out", "file.txt"))
+	assert.NoError(t, err)
+	assert.Equal(t, data, written)
+
+	assert.NoError(t, os.Remove(filepath.Join(dir, "set.part3.rar")))
+
+	_, _, archives, err = xtractr.ExtractRAR(&xtractr.XFile{FilePath: first, OutputDir: filepath.Join(dir, "out2")})
+	assert.ErrorIs(t, err, xtractr.ErrMissingVolume)
+	assert.Equal(t, []string{first, filepath.Join(dir, "set.part2.rar")}, archives)
+}
+
+func TestExtractRARTruncated(t *testing.T) {
+	t.Parallel()
+
+	data, err := os.ReadFile(testFile)
+	assert.NoError(t, err)
+
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "cut.rar")
+	assert.NoError(t, os.WriteFile(archive, data[:len(data)*2/3], 0o600))
+
+	_, _, _, err = xtractr.ExtractRAR(&xtractr.XFile{
+		FilePath:  archive,
+		OutputDir: filepath.Join(dir, "out"),
+		Password:  "some_password",
+	})
+	assert.ErrorIs(t, err, xtractr.ErrTruncated)
+}
+
+// makeRAR5Volumes writes a multi-volume RAR5 set holding one stored (uncompressed) file.
+// The pattern is formatted with each volume number, starting at 1.
+func makeRAR5Volumes(pattern, name string, data []byte, volumes int) error {
+	chunk := (len(data) + volumes - 1) / volumes
+
+	for vol := 0; vol < volumes; vol++ {
+		end := (vol + 1) * chunk
+		if end > len(data) {
+			end = len(data)
+		}
+
+		flags, last := uint64(0x02), uint64(0) // has data; last volume.
+		if vol > 0 {
+			flags |= 0x08 // continued from the previous volume.
+		}
+
+		if vol < volumes-1 {
+			flags, last = flags|0x10, 1 // continued in the next volume; not the last volume.
+		}
+
+		file := rar5Vint(nil, 2, flags, uint64(end-vol*chunk), 0x04, uint64(len(data)), 0o644) // has crc32.
+		file = binary.LittleEndian.AppendUint32(file, crc32.ChecksumIEEE(data))
+		file = append(rar5Vint(file, 0, 1, uint64(len(name))), name...) // stored, unix.
+
+		out := []byte("Rar!\x1a\x07\x01\x00")
+		out = appendRAR5Block(out, rar5Vint(nil, 1, 0, 1)) // multi-volume archive.
+		out = append(appendRAR5Block(out, file), data[vol*chunk:end]...)
+		out = appendRAR5Block(out, rar5Vint(nil, 5, 0, last))
+
+		if err := os.WriteFile(fmt.Sprintf(pattern, vol+1), out, 0o600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// rar5Vint appends numbers in the variable length format used in RAR5 headers.
+func rar5Vint(buf []byte, nums ...uint64) []byte {
+	for _, num := range nums {
+		for ; num >= 0x80; num >>= 7 {
+			buf = append(buf, byte(num)|0x80)
+		}
+
+		buf = append(buf, byte(num))
+	}
+
+	return buf
+}
+
+// appendRAR5Block appends a header block: crc32, size, then the header.
+func appendRAR5Block(out, header []byte) []byte {
+	header = append(rar5Vint(nil, uint64(len(header))), header...)
+	out = binary.LittleEndian.AppendUint32(out, crc32.ChecksumIEEE(header))
+
+	return append(out, header...)
+}
@@ -0,0 +1,457 @@
+package xtractr
+
+/* Code to register archive formats with ExtractFile and FindCompressedFiles. */
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"sync"
+)
+
+// Extractor is implemented by every archive format this library can read.
+// Use RegisterFormat to add your own, or to replace a built-in format.
+type Extractor interface {
+	// Detect is called with the first bytes of a file after one of the format's
+	// signatures matched. Formats without signatures are always asked.
+	// Return true if this Extractor can read the file.
+	Detect(header []byte) bool
+	// List returns the entries in an archive without extracting them.
+	List(xFile *XFile) ([]Entry, error)
+	// Extract writes an archive's contents into xFile.OutputDir.
+	// Returns size of extracted data, list of extracted files, list of archives processed, and/or error.
+	Extract(xFile *XFile) (int64, []string, []string, error)
+}
+
+// Signature is a magic byte sequence found at a specific offset in a file.
+type Signature struct {
+	Offset int
+	Magic  []byte
+}
+
+// registration ties a format's name, suffixes and signatures to its Extractor.
+type registration struct {
+	format     Format
+	suffixes   []string
+	signatures []Signature
+	extractor  Extractor
+}
+
+// extractor adapts this library's extract and list functions to the Extractor interface.
+type extractor struct {
+	detect  func(header []byte) bool
+	list    func(xFile *XFile) ([]Entry, error)
+	extract func(xFile *XFile) (int64, []string, []string, error)
+	test    func(xFile *XFile) (int64, []string, error)
+	fs      func(xFile *XFile) (fs.FS, io.Closer, error)
+	// size adds up the sizes of the files in an archive from its headers or central
+	// directory, without decompressing anything. Nil if the format can't do that.
+	size func(xFile *XFile) (int64, error)
+}
+
+//nolint:gochecknoglobals
+var (
+	registryLock sync.RWMutex
+	// registry is checked in order; the first matching signature wins.
+	registry = []*registration{
+		{
+			format:   FormatZIP,
+			suffixes: []string{".zip", ".zip.001"},
+			signatures: []Signature{
+				{Magic: []byte("PK\x03\x04")},
+				{Magic: []byte("PK\x05\x06")}, // empty archive.
+				{Magic: []byte("PK\x07\x08")}, // spanned archive.
+			},
+			extractor: &extractor{list: listZIP, extract: extractZIPVolumes, test: testZIP, fs: fsZIP, size: listSize(listZIP)},
+		},
+		{
+			format:     FormatRAR5,
+			signatures: []Signature{{Magic: []byte("Rar!\x1a\x07\x01\x00")}},
+			extractor:  &extractor{list: listRAR, extract: ExtractRAR, test: testRAR, fs: fsRAR, size: sizeRAR},
+		},
+		{
+			format:     FormatRAR,
+			suffixes:   []string{".rar", ".r00"},
+			signatures: []Signature{{Magic: []byte("Rar!\x1a\x07\x00")}},
+			extractor:  &extractor{list: listRAR, extract: ExtractRAR, test: testRAR, fs: fsRAR, size: sizeRAR},
+		},
+		{
+			format:     FormatCAB,
+			suffixes:   []string{".cab"},
+			signatures: []Signature{{Magic: []byte(cabMagic)}},
+			extractor:  &extractor{list: listCAB, extract: ExtractCAB, test: testCAB, size: listSize(listCAB)},
+		},
+		{
+			format:     Format7z,
+			suffixes:   []string{".7z", ".7z.001"},
+			signatures: []Signature{{Magic: []byte("7z\xbc\xaf\x27\x1c")}},
+			extractor:  &extractor{list: list7z, extract: Extract7z, test: test7z, fs: fs7z, size: listSize(list7z)},
+		},
+		{
+			format:     FormatTarGzip,
+			suffixes:   []string{".tar.gz", ".tgz"},
+			signatures: []Signature{{Magic: []byte("\x1f\x8b")}},
+			extractor: &extractor{
+				detect:  detectStream(FormatGzip),
+				list:    listStream(FormatGzip),
+				extract: withArchive(ExtractTarGzip),
+				test:    testStream(FormatGzip),
+				fs:      fsTar(FormatGzip),
+			},
+		},
+		{
+			format:     FormatGzip,
+			suffixes:   []string{".gz"},
+			signatures: []Signature{{Magic: []byte("\x1f\x8b")}},
+			extractor:  &extractor{list: listStream(FormatGzip), extract: withArchive(ExtractGzip), test: testStream(FormatGzip)},
+		},
+		{
+			format:     FormatTarBzip2,
+			suffixes:   []string{".tar.bz2", ".tbz2", ".tbz", ".tar.bz"},
+			signatures: []Signature{{Magic: []byte("BZh")}},
+			extractor: &extractor{
+				detect:  detectStream(FormatBzip2),
+				list:    listStream(FormatBzip2),
+				extract: withArchive(ExtractTarBzip),
+				test:    testStream(FormatBzip2),
+				fs:      fsTar(FormatBzip2),
+			},
+		},
+		{
+			format:     FormatBzip2,
+			suffixes:   []string{".bz2", ".bz"},
+			signatures: []Signature{{Magic: []byte("BZh")}},
+			extractor:  &extractor{list: listStream(FormatBzip2), extract: withArchive(ExtractBzip), test: testStream(FormatBzip2)},
+		},
+		{
+			format:     FormatTarXZ,
+			suffixes:   []string{".tar.xz", ".txz"},
+			signatures: []Signature{{Magic: []byte("\xfd7zXZ\x00")}},
+			extractor: &extractor{
+				detect:  detectStream(FormatXZ),
+				list:    listStream(FormatXZ),
+				extract: withArchive(ExtractTarXZ),
+				test:    testStream(FormatXZ),
+				fs:      fsTar(FormatXZ),
+			},
+		},
+		{
+			format:     FormatXZ,
+			suffixes:   []string{".xz"},
+			signatures: []Signature{{Magic: []byte("\xfd7zXZ\x00")}},
+			extractor: &extractor{
+				list:    listStream(FormatXZ),
+				extract: withArchive(ExtractXZ),
+				test:    testStream(FormatXZ),
+			},
+		},
+		{
+			format:     FormatTarZstd,
+			suffixes:   []string{".tar.zst", ".tzst", ".tar.zstd"},
+			signatures: []Signature{{Magic: []byte("\x28\xb5\x2f\xfd")}},
+			extractor: &extractor{
+				detect:  detectStream(FormatZstd),
+				list:    listStream(FormatZstd),
+				extract: withArchive(ExtractTarZstd),
+				test:    testStream(FormatZstd),
+				fs:      fsTar(FormatZstd),
+			},
+		},
+		{
+			format:     FormatZstd,
+			suffixes:   []string{".zst", ".zstd"},
+			signatures: []Signature{{Magic: []byte("\x28\xb5\x2f\xfd")}},
+			extractor: &extractor{
+				list:    listStream(FormatZstd),
+				extract: withArchive(ExtractZstd),
+				test:    testStream(FormatZstd),
+			},
+		},
+		{
+			format:     FormatTarLZ4,
+			suffixes:   []string{".tar.lz4"},
+			signatures: []Signature{{Magic: []byte("\x04\x22\x4d\x18")}},
+			extractor: &extractor{
+				detect:  detectStream(FormatLZ4),
+				list:    listStream(FormatLZ4),
+				extract: withArchive(ExtractTarLZ4),
+				test:    testStream(FormatLZ4),
+				fs:      fsTar(FormatLZ4),
+			},
+		},
+		{
+			format:     FormatLZ4,
+			suffixes:   []string{".lz4"},
+			signatures: []Signature{{Magic: []byte("\x04\x22\x4d\x18")}},
+			extractor: &extractor{
+				list:    listStream(FormatLZ4),
+				extract: withArchive(ExtractLZ4),
+				test:    testStream(FormatLZ4),
+			},
+		},
+		{
+			format:     FormatTarLZMA,
+			suffixes:   []string{".tar.lzma"},
+			signatures: []Signature{{Magic: []byte("\x5d\x00\x00")}},
+			extractor: &extractor{
+				detect:  detectStream(FormatLZMA),
+				list:    listStream(FormatLZMA),
+				extract: withArchive(ExtractTarLZMA),
+				test:    testStream(FormatLZMA),
+				fs:      fsTar(FormatLZMA),
+			},
+		},
+		{
+			format:     FormatLZMA,
+			suffixes:   []string{".lzma"},
+			signatures: []Signature{{Magic: []byte("\x5d\x00\x00")}},
+			extractor: &extractor{
+				detect:  detectLZMA,
+				list:    listStream(FormatLZMA),
+				extract: withArchive(ExtractLZMA),
+				test:    testStream(FormatLZMA),
+			},
+		},
+		{
+			format:     FormatISO,
+			suffixes:   []string{".iso"},
+			signatures: []Signature{{Offset: 0x8001, Magic: []byte("CD001")}},
+			extractor:  &extractor{list: listISO, extract: withArchive(ExtractISO), test: testISO, fs: fsISO, size: listSize(listISO)},
+		},
+		{
+			format:     FormatSquashFS,
+			suffixes:   squashfsSuffixes,
+			signatures: []Signature{{Magic: []byte(squashfsMagic)}},
+			extractor: &extractor{
+				list:    listSquashFS,
+				extract: withArchive(ExtractSquashFS),
+				test:    testSquashFS,
+				size:    listSize(listSquashFS),
+			},
+		},
+		{
+			format:     FormatTar,
+			suffixes:   []string{".tar"},
+			signatures: []Signature{{Offset: 257, Magic: []byte("ustar")}}, //nolint:gomnd
+			extractor: &extractor{
+				list:    listStream(FormatTar),
+				extract: withArchive(ExtractTar),
+				test:    testStream(FormatTar),
+				fs:      fsTar(FormatTar),
+			},
+		},
+		{
+			format:     FormatAr,
+			suffixes:   []string{".deb", ".udeb", ".ar"},
+			signatures: []Signature{{Magic: []byte(arMagic)}},
+			extractor:  &extractor{list: listStream(FormatAr), extract: withArchive(ExtractAr), test: testStream(FormatAr)},
+		},
+		{
+			format:     FormatRPM,
+			suffixes:   []string{".rpm"},
+			signatures: []Signature{{Magic: rpmLeadMagic}},
+			extractor:  &extractor{list: listRPM, extract: withArchive(ExtractRPM), test: testRPM},
+		},
+		{
+			format:   FormatCpio,
+			suffixes: []string{".cpio"},
+			signatures: []Signature{
+				{Magic: []byte(cpioNewcMagic)}, {Magic: []byte(cpioCRCMagic)}, {Magic: []byte(cpioOdcMagic)},
+			},
+			extractor: &extractor{list: listStream(FormatCpio), extract: withArchive(ExtractCpio), test: testStream(FormatCpio)},
+		},
+		{
+			// brotli has no signature, so tarballs are only found by their suffix. Decoding
+			// the header of every unknown file to look for a tarball is too expensive.
+			format:   FormatTarBrotli,
+			suffixes: []string{".tar.br"},
+			extractor: &extractor{
+				detect:  noDetect,
+				list:    listStream(FormatBrotli),
+				extract: withArchive(ExtractTarBrotli),
+				test:    testStream(FormatBrotli),
+				fs:      fsTar(FormatBrotli),
+			},
+		},
+		{
+			format:   FormatBrotli,
+			suffixes: []string{".br"},
+			extractor: &extractor{
+				detect:  noDetect,
+				list:    listStream(FormatBrotli),
+				extract: withArchive(ExtractBrotli),
+				test:    testStream(FormatBrotli),
+			},
+		},
+	}
+)
+
+// RegisterFormat adds an archive format to ExtractFile, DetectFormat and FindCompressedFiles.
+// Files are matched by their signatures (magic bytes) first, then by suffix.
+// Suffixes must include the leading dot, ie. ".zip" or ".tar.gz".
+// Registering a format that already exists (including a built-in one) replaces it.
+func RegisterFormat(format Format, extractor Extractor, suffixes []string, signatures ...Signature) {
+	reg := &registration{
+		format:     format,
+		extractor:  extractor,
+		signatures: signatures,
+		suffixes:   make([]string, len(suffixes)),
+	}
+
+	for idx, suffix := range suffixes {
+		reg.suffixes[idx] = strings.ToLower(suffix)
+	}
+
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	for idx, existing := range registry {
+		if existing.format == format {
+			registry[idx] = reg
+			return
+		}
+	}
+
+	registry = append(registry, reg)
+}
+
+// ExtractorFor returns the Extractor registered for a format, or nil if there is none.
+func ExtractorFor(format Format) Extractor {
+	if reg := getRegistration(format); reg != nil {
+		return reg.extractor
+	}
+
+	return nil
+}
+
+// registrations returns a copy of the registry, so Extractors are called without the lock held.
+func registrations() []*registration {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	return append([]*registration(nil), registry...)
+}
+
+// getRegistration returns the registration for a format, or nil if there is none.
+func getRegistration(format Format) *registration {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
+	for _, reg := range registry {
+		if reg.format == format && reg.extractor != nil {
+			return reg
+		}
+	}
+
+	return nil
+}
+
+// registrationFor returns the registration for a file, detected by content first, then by suffix.
+// If sfx is true, a program is checked for an archive last.
+func registrationFor(path string, sfx bool) (*registration, error) {
+	format, _ := DetectFormat(path)
+	if reg := getRegistration(format); reg != nil {
+		return reg, nil
+	}
+
+	if reg := getRegistration(FormatFromName(path)); reg != nil {
+		return reg, nil
+	}
+
+	if sfx && isSFX(path) {
+		return sfxRegistration, nil
+	}
+
+	return nil, fmt.Errorf("%w: %s", ErrUnknownArchiveType, path)
+}
+
+// matches returns true if the header contains one of the format's signatures
+// and the format's Extractor agrees it can read it.
+func (r *registration) matches(header []byte) bool {
+	if len(r.signatures) == 0 {
+		return r.extractor != nil && r.extractor.Detect(header)
+	}
+
+	for _, sig := range r.signatures {
+		if len(header) < sig.Offset+len(sig.Magic) ||
+			!bytes.Equal(header[sig.Offset:sig.Offset+len(sig.Magic)], sig.Magic) {
+			continue
+		}
+
+		return r.extractor == nil || r.extractor.Detect(header)
+	}
+
+	return false
+}
+
+// suffixLen returns the length of the longest suffix that matches lowerName, or 0.
+func (r *registration) suffixLen(lowerName string) int {
+	longest := 0
+
+	for _, suffix := range r.suffixes {
+		if len(suffix) > longest && strings.HasSuffix(lowerName, suffix) {
+			longest = len(suffix)
+		}
+	}
+
+	return longest
+}
+
+// listSize returns a size function that adds up the sizes of the files list returns.
+// Only for formats that list from headers or a central directory.
+func listSize(list func(*XFile) ([]Entry, error)) func(*XFile) (int64, error) {
+	return func(xFile *XFile) (int64, error) {
+		entries, err := list(xFile)
+		if err != nil {
+			return 0, err
+		}
+
+		total := int64(0)
+
+		for _, entry := range entries {
+			if entry.Size > 0 {
+				total += entry.Size
+			}
+		}
+
+		return total, nil
+	}
+}
+
+// withArchive adapts extract functions that do not return an archive list.
+func withArchive(extract func(*XFile) (int64, []string, error)) func(*XFile) (int64, []string, []string, error) {
+	return func(xFile *XFile) (int64, []string, []string, error) {
+		size, files, err := extract(xFile)
+		return size, files, []string{xFile.FilePath}, err
+	}
+}
+
+func (e *extractor) Detect(header []byte) bool {
+	return e.detect == nil || e.detect(header)
+}
+
+func (e *extractor) List(xFile *XFile) ([]Entry, error) {
+	return e.list(xFile)
+}
+
+func (e *extractor) Extract(xFile *XFile) (int64, []string, []string, error) {
+	return e.extract(xFile)
+}
+
+func (e *extractor) Test(xFile *XFile) (int64, []string, error) {
+	if e.test == nil {
+		return 0, nil, fmt.Errorf("%w: %s", ErrNoTester, xFile.FilePath)
+	}
+
+	return e.test(xFile)
+}
+
+func (e *extractor) OpenFS(xFile *XFile) (fs.FS, io.Closer, error) {
+	if e.fs == nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrNoFS, xFile.FilePath)
+	}
+
+	return e.fs(xFile)
+}
@@ -0,0 +1,94 @@
+package xtractr_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+// testFormat is an in-house "archive" that contains a single file: everything after the magic.
+type testFormat struct{}
+
+func (testFormat) Detect(header []byte) bool { return len(header) > 4 }
+
+func (testFormat) List(xFile *xtractr.XFile) ([]xtractr.Entry, error) {
+	info, err := os.Stat(xFile.FilePath)
+	if err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	return []xtractr.Entry{{Name: "payload", Size: info.Size() - 4}}, nil
+}
+
+func (testFormat) Extract(xFile *xtractr.XFile) (int64, []string, []string, error) {
+	data, err := os.ReadFile(xFile.FilePath)
+	if err != nil {
+		return 0, nil, nil, err //nolint:wrapcheck
+	}
+
+	output := filepath.Join(xFile.OutputDir, "payload")
+	if err := os.WriteFile(output, data[4:], xFile.FileMode); err != nil {
+		return 0, nil, nil, err //nolint:wrapcheck
+	}
+
+	return int64(len(data) - 4), []string{output}, []string{xFile.FilePath}, nil
+}
+
+// registeringFormat registers another format while it detects its own.
+type registeringFormat struct{ testFormat }
+
+func (registeringFormat) Detect([]byte) bool {
+	xtractr.RegisterFormat("test-registered", testFormat{}, []string{".registered"}, xtractr.Signature{Magic: []byte("REG?")})
+	return true
+}
+
+func TestDetectHeaderUnlocked(t *testing.T) {
+	t.Parallel()
+
+	const format = xtractr.Format("test-registering")
+
+	xtractr.RegisterFormat(format, registeringFormat{}, nil, xtractr.Signature{Magic: []byte("REG!")})
+
+	detected := make(chan xtractr.Format)
+	go func() { detected <- xtractr.DetectHeader([]byte("REG!data")) }()
+
+	select {
+	case got := <-detected:
+		assert.Equal(t, format, got)
+		assert.NotNil(t, xtractr.ExtractorFor("test-registered"), "Detect must be able to register a format")
+	case <-time.After(10 * time.Second):
+		t.Fatal("DetectHeader deadlocked: the registry was locked while Detect ran")
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	t.Parallel()
+
+	const format = xtractr.Format("test-xyz")
+
+	xtractr.RegisterFormat(format, testFormat{}, []string{".XYZ"}, xtractr.Signature{Magic: []byte("XYZ!")})
+	assert.NotNil(t, xtractr.ExtractorFor(format))
+
+	name := t.TempDir()
+	archive := filepath.Join(name, "custom.dat")
+	assert.NoError(t, os.WriteFile(archive, []byte("XYZ!hello"), xtractr.DefaultFileMode))
+
+	detected, err := xtractr.DetectFormat(archive)
+	assert.NoError(t, err)
+	assert.Equal(t, format, detected, "the registered signature must be detected")
+	assert.Equal(t, format, xtractr.FormatFromName("some/file.xyz"), "suffixes are not case sensitive")
+
+	size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:  archive,
+		OutputDir: name,
+		FileMode:  xtractr.DefaultFileMode,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+	assert.Equal(t, []string{filepath.Join(name, "payload")}, files)
+	assert.Equal(t, []string{archive}, archives)
+}
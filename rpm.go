@@ -0,0 +1,402 @@
+package xtractr
+
+/* Code to read RPM packages: their header tags, and the cpio payload with the files. */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"time"
+)
+
+const (
+	// rpmLeadSize is the size of the (obsolete) lead at the start of every RPM package.
+	rpmLeadSize = 96
+	// rpmIndexSize is the size of each tag in a header's index.
+	rpmIndexSize = 16
+	// rpmMaxHeader protects against a header that claims to be huge.
+	rpmMaxHeader = 256 * 1024 * 1024
+)
+
+// RPM header tags read by ListRPM.
+const (
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagEpoch             = 1003
+	rpmTagSummary           = 1004
+	rpmTagLicense           = 1014
+	rpmTagArch              = 1022
+	rpmTagOldFileNames      = 1027
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileMTimes        = 1034
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadCompressor = 1125
+	rpmTagLongFileSizes     = 5008
+)
+
+// RPM header tag data types.
+const (
+	rpmTypeInt8        = 2
+	rpmTypeInt16       = 3
+	rpmTypeInt32       = 4
+	rpmTypeInt64       = 5
+	rpmTypeString      = 6
+	rpmTypeStringArray = 8
+	rpmTypeI18NString  = 9
+)
+
+//nolint:gochecknoglobals
+var (
+	rpmLeadMagic   = []byte("\xed\xab\xee\xdb")
+	rpmHeaderMagic = []byte("\x8e\xad\xe8\x01")
+	// rpmCompressors are the payload compressors named in RPM headers.
+	rpmCompressors = map[string]Format{
+		"gzip":  FormatGzip,
+		"bzip2": FormatBzip2,
+		"xz":    FormatXZ,
+		"zstd":  FormatZstd,
+		"lzma":  FormatLZMA,
+	}
+)
+
+// RPMPackage is the information in an RPM package's header.
+type RPMPackage struct {
+	Name    string
+	Version string
+	Release string
+	// Epoch is 0 when the package does not have one.
+	Epoch   int64
+	Arch    string
+	Summary string
+	License string
+	// PayloadCompressor is how the files are compressed: gzip, bzip2, xz, zstd or lzma.
+	PayloadCompressor string
+	// Files in the package, from the header. CompressedSize is -1.
+	Files []Entry
+	// Tags has every tag in the header, by tag number. Strings are string,
+	// string arrays are []string, numbers are []int64 and binary data is []byte.
+	Tags map[int]interface{}
+}
+
+// rpmHeader is a parsed header section: an index of tags, and the data they point into.
+type rpmHeader struct {
+	tags map[int]interface{}
+}
+
+// ExtractRPM extracts the files in an RPM package's payload.
+func ExtractRPM(xFile *XFile) (int64, []string, error) {
+	payload, err := openRPM(xFile.FilePath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer payload.Close()
+
+	return xFile.uncpio(payload)
+}
+
+// ListRPM reads the header of an RPM package: its name, version, and the files in it.
+// The payload is not read, so this can be used to check a package before extracting it.
+func ListRPM(path string) (*RPMPackage, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer file.Close()
+
+	header, err := readRPMHeaders(bufio.NewReader(file))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return header.pkg(), nil
+}
+
+// listRPM lists the files in an RPM package, from its header.
+func listRPM(xFile *XFile) ([]Entry, error) {
+	pkg, err := ListRPM(xFile.FilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return pkg.Files, nil
+}
+
+// testRPM reads every file in an RPM package's payload.
+func testRPM(xFile *XFile) (int64, []string, error) {
+	payload, err := openRPM(xFile.FilePath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer payload.Close()
+
+	return xFile.testCpio(payload)
+}
+
+// openRPM opens an RPM package, skips its headers, and returns the decompressed payload.
+func openRPM(filePath string) (*stream, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+
+	reader := bufio.NewReader(file)
+
+	header, err := readRPMHeaders(reader)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	// The header names the compressor. If it doesn't, it's found by magic.
+	first := []Format{}
+	if format, ok := rpmCompressors[header.str(rpmTagPayloadCompressor)]; ok {
+		first = append(first, format)
+	}
+
+	payload, err := peel(reader, "", first)
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: payload: %w", filePath, err)
+	}
+
+	payload.file = file
+	payload.closers = append([]io.Closer{file}, payload.closers...)
+
+	return payload, nil
+}
+
+// readRPMHeaders reads the lead, the signature and the header from an RPM package.
+// The reader is left at the start of the payload.
+func readRPMHeaders(reader io.Reader) (*rpmHeader, error) {
+	lead := make([]byte, rpmLeadSize)
+	if _, err := io.ReadFull(reader, lead); err != nil || !bytes.HasPrefix(lead, rpmLeadMagic) {
+		return nil, fmt.Errorf("%w: missing rpm lead", ErrInvalidHead)
+	}
+
+	// The signature's tags (digests and PGP signatures) are not used.
+	_, size, err := readRPMHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("rpm signature: %w", err)
+	}
+
+	// The signature is padded to a multiple of 8 bytes.
+	if _, err := io.CopyN(io.Discard, reader, (8-size%8)%8); err != nil { //nolint:gomnd
+		return nil, fmt.Errorf("rpm signature: %w", noEOF(err))
+	}
+
+	header, _, err := readRPMHeader(reader)
+	if err != nil {
+		return nil, fmt.Errorf("rpm header: %w", err)
+	}
+
+	return header, nil
+}
+
+// readRPMHeader reads one header section, and returns it with its size.
+func readRPMHeader(reader io.Reader) (*rpmHeader, int64, error) {
+	intro := make([]byte, 16) //nolint:gomnd // magic, reserved, index count, data size.
+	if _, err := io.ReadFull(reader, intro); err != nil {
+		return nil, 0, fmt.Errorf("reading: %w", noEOF(err))
+	}
+
+	if !bytes.HasPrefix(intro, rpmHeaderMagic) {
+		return nil, 0, fmt.Errorf("%w: bad rpm header magic", ErrInvalidHead)
+	}
+
+	count := int64(binary.BigEndian.Uint32(intro[8:12]))
+	dataSize := int64(binary.BigEndian.Uint32(intro[12:16]))
+	size := int64(len(intro)) + count*rpmIndexSize + dataSize
+
+	if size > rpmMaxHeader {
+		return nil, 0, fmt.Errorf("%w: rpm header is %d bytes", ErrInvalidHead, size)
+	}
+
+	index := make([]byte, count*rpmIndexSize)
+	data := make([]byte, dataSize)
+
+	if _, err := io.ReadFull(reader, index); err != nil {
+		return nil, 0, fmt.Errorf("reading index: %w", noEOF(err))
+	}
+
+	if _, err := io.ReadFull(reader, data); err != nil {
+		return nil, 0, fmt.Errorf("reading data: %w", noEOF(err))
+	}
+
+	header := &rpmHeader{tags: make(map[int]interface{})}
+
+	for idx := int64(0); idx < count; idx++ {
+		entry := index[idx*rpmIndexSize:]
+		tag := int(binary.BigEndian.Uint32(entry[0:4]))
+		kind := binary.BigEndian.Uint32(entry[4:8])
+		offset := int64(binary.BigEndian.Uint32(entry[8:12]))
+		items := int64(binary.BigEndian.Uint32(entry[12:16]))
+
+		if offset > dataSize {
+			return nil, 0, fmt.Errorf("%w: rpm tag %d is outside the header", ErrInvalidHead, tag)
+		}
+
+		header.tags[tag] = rpmTagValue(kind, data[offset:], items)
+	}
+
+	return header, size, nil
+}
+
+// rpmTagValue decodes a tag's data. Data that runs past the end of the header is cut short.
+func rpmTagValue(kind uint32, data []byte, items int64) interface{} {
+	switch kind {
+	case rpmTypeString, rpmTypeStringArray, rpmTypeI18NString:
+		strs := []string{}
+
+		for ; items > 0 && len(data) > 0; items-- {
+			end := bytes.IndexByte(data, 0)
+			if end < 0 {
+				end = len(data)
+			}
+
+			strs = append(strs, string(data[:end]))
+			data = data[min64(int64(end+1), int64(len(data))):]
+		}
+
+		if kind == rpmTypeString && len(strs) > 0 {
+			return strs[0]
+		}
+
+		return strs
+	case rpmTypeInt8, rpmTypeInt16, rpmTypeInt32, rpmTypeInt64:
+		width := map[uint32]int64{rpmTypeInt8: 1, rpmTypeInt16: 2, rpmTypeInt32: 4, rpmTypeInt64: 8}[kind]
+		items = min64(items, int64(len(data))/width)
+		nums := make([]int64, items)
+
+		for idx := range nums {
+			value := data[int64(idx)*width:]
+
+			switch width {
+			case 1:
+				nums[idx] = int64(value[0])
+			case 2: //nolint:gomnd
+				nums[idx] = int64(binary.BigEndian.Uint16(value))
+			case 4: //nolint:gomnd
+				nums[idx] = int64(binary.BigEndian.Uint32(value))
+			default:
+				nums[idx] = int64(binary.BigEndian.Uint64(value))
+			}
+		}
+
+		return nums
+	default: // binary data, and chars.
+		return data[:min64(items, int64(len(data)))]
+	}
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
+
+// str returns a string tag, or the first string in an array or translated string.
+func (h *rpmHeader) str(tag int) string {
+	switch value := h.tags[tag].(type) {
+	case string:
+		return value
+	case []string:
+		if len(value) > 0 {
+			return value[0]
+		}
+	}
+
+	return ""
+}
+
+// strs returns a string array tag.
+func (h *rpmHeader) strs(tag int) []string {
+	value, _ := h.tags[tag].([]string)
+	return value
+}
+
+// nums returns a number array tag.
+func (h *rpmHeader) nums(tag int) []int64 {
+	value, _ := h.tags[tag].([]int64)
+	return value
+}
+
+// pkg returns the package information in the header.
+func (h *rpmHeader) pkg() *RPMPackage {
+	pkg := &RPMPackage{
+		Name:              h.str(rpmTagName),
+		Version:           h.str(rpmTagVersion),
+		Release:           h.str(rpmTagRelease),
+		Arch:              h.str(rpmTagArch),
+		Summary:           h.str(rpmTagSummary),
+		License:           h.str(rpmTagLicense),
+		PayloadCompressor: h.str(rpmTagPayloadCompressor),
+		Files:             h.files(),
+		Tags:              h.tags,
+	}
+
+	if epoch := h.nums(rpmTagEpoch); len(epoch) > 0 {
+		pkg.Epoch = epoch[0]
+	}
+
+	return pkg
+}
+
+// files returns the file list in the header. The names are relative, like the names in the payload.
+func (h *rpmHeader) files() []Entry {
+	names := h.strs(rpmTagOldFileNames)
+
+	if base := h.strs(rpmTagBaseNames); len(base) > 0 {
+		dirs, indexes := h.strs(rpmTagDirNames), h.nums(rpmTagDirIndexes)
+		names = make([]string, len(base))
+
+		for idx, name := range base {
+			if idx < len(indexes) && indexes[idx] >= 0 && indexes[idx] < int64(len(dirs)) {
+				name = dirs[indexes[idx]] + name
+			}
+
+			names[idx] = name
+		}
+	}
+
+	sizes := h.nums(rpmTagLongFileSizes)
+	if len(sizes) == 0 {
+		sizes = h.nums(rpmTagFileSizes)
+	}
+
+	modes, mtimes := h.nums(rpmTagFileModes), h.nums(rpmTagFileMTimes)
+	entries := make([]Entry, len(names))
+
+	for idx, name := range names {
+		size, mode, mtime := int64(-1), os.FileMode(0), time.Time{}
+
+		if idx < len(sizes) {
+			size = sizes[idx]
+		}
+
+		if idx < len(modes) {
+			mode = unixMode(uint32(modes[idx]))
+		}
+
+		if idx < len(mtimes) {
+			mtime = time.Unix(mtimes[idx], 0)
+		}
+
+		if mode.IsDir() {
+			size = 0
+		}
+
+		entries[idx] = newEntry("."+path.Clean("/"+name), size, -1, mode, mtime)
+	}
+
+	return entries
+}
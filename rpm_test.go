@@ -0,0 +1,167 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExtractRPM(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rpm := filepath.Join(dir, "hello-1.0-1.x86_64.rpm")
+	assert.NoError(t, makeRPM(rpm, []cpioFile{
+		{name: "./usr", mode: 0o40755},
+		{name: "./usr/bin", mode: 0o40755},
+		{name: "./usr/bin/hello", mode: 0o100755, data: "#!/bin/sh\necho hi\n"},
+		{name: "./usr/bin/hi", mode: 0o120777, data: "hello"},
+	}))
+
+	format, err := xtractr.DetectFormat(rpm)
+	assert.NoError(t, err)
+	assert.Equal(t, xtractr.FormatRPM, format)
+
+	pkg, err := xtractr.ListRPM(rpm)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", pkg.Name)
+	assert.Equal(t, "1.0", pkg.Version)
+	assert.Equal(t, "1", pkg.Release)
+	assert.Equal(t, "x86_64", pkg.Arch)
+	assert.Equal(t, "gzip", pkg.PayloadCompressor)
+
+	names := []string{}
+	for _, entry := range pkg.Files {
+		names = append(names, entry.Name)
+	}
+
+	assert.Equal(t, []string{"./usr/bin/hello", "./usr/bin/hi"}, names)
+	assert.Equal(t, int64(len("#!/bin/sh\necho hi\n")), pkg.Files[0].Size)
+
+	entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: rpm})
+	assert.NoError(t, err)
+	assert.Equal(t, pkg.Files, entries)
+
+	size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: rpm, OutputDir: filepath.Join(dir, "out")})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{rpm}, archives)
+	assert.Equal(t, int64(len("#!/bin/sh\necho hi\n")), size)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "out", "usr", "bin", "hello"),
+		filepath.Join(dir, "out", "usr", "bin", "hi"),
+	}, files)
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: rpm})
+	assert.NoError(t, err)
+}
+
+func TestExtractRPMPathSafety(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	rpm := filepath.Join(dir, "evil.rpm")
+	assert.NoError(t, makeRPM(rpm, []cpioFile{{name: "../../evil", mode: 0o100644, data: "evil"}}))
+
+	_, _, err := xtractr.ExtractRPM(&xtractr.XFile{FilePath: rpm, OutputDir: filepath.Join(dir, "out")})
+	assert.ErrorIs(t, err, xtractr.ErrInvalidPath)
+	assert.NoFileExists(t, filepath.Join(dir, "evil"))
+}
+
+// makeRPM writes a minimal RPM package: the lead, an empty signature,
+// a header with a few tags, and a gzip compressed newc cpio payload.
+func makeRPM(fileName string, files []cpioFile) error {
+	buf := &bytes.Buffer{}
+	lead := make([]byte, 96) //nolint:gomnd
+	copy(lead, "\xed\xab\xee\xdb\x03\x00")
+	buf.Write(lead)
+	buf.Write(rpmHeader(nil))
+
+	for buf.Len()%8 != 0 { // the signature is padded.
+		buf.WriteByte(0)
+	}
+
+	baseNames, dirNames, dirIndexes, sizes := []string{}, []string{}, []uint32{}, []uint32{}
+
+	for _, file := range files {
+		if file.mode&0o170000 == 0o40000 {
+			continue
+		}
+
+		dir, base := filepath.Split(file.name[1:])
+		baseNames = append(baseNames, base)
+		dirNames = append(dirNames, dir)
+		dirIndexes = append(dirIndexes, uint32(len(dirNames)-1))
+		sizes = append(sizes, uint32(len(file.data)))
+	}
+
+	buf.Write(rpmHeader([]rpmTag{
+		{tag: 1000, kind: 6, value: "hello"},    //nolint:gomnd
+		{tag: 1001, kind: 6, value: "1.0"},      //nolint:gomnd
+		{tag: 1002, kind: 6, value: "1"},        //nolint:gomnd
+		{tag: 1022, kind: 6, value: "x86_64"},   //nolint:gomnd
+		{tag: 1028, kind: 4, value: sizes},      //nolint:gomnd
+		{tag: 1116, kind: 4, value: dirIndexes}, //nolint:gomnd
+		{tag: 1117, kind: 8, value: baseNames},  //nolint:gomnd
+		{tag: 1118, kind: 8, value: dirNames},   //nolint:gomnd
+		{tag: 1125, kind: 6, value: "gzip"},     //nolint:gomnd
+	}))
+
+	err := writeCompressed(buf, func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }, makeCpio("070701", files))
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(fileName, buf.Bytes(), 0o600) //nolint:wrapcheck
+}
+
+type rpmTag struct {
+	tag   uint32
+	kind  uint32
+	value interface{}
+}
+
+// rpmHeader encodes an RPM header section.
+func rpmHeader(tags []rpmTag) []byte {
+	index, data := &bytes.Buffer{}, &bytes.Buffer{}
+
+	for _, tag := range tags {
+		count := 1
+		if tag.kind == 4 { //nolint:gomnd
+			for data.Len()%4 != 0 {
+				data.WriteByte(0)
+			}
+		}
+
+		offset := data.Len()
+
+		switch value := tag.value.(type) {
+		case string:
+			data.WriteString(value + "\x00")
+		case []string:
+			for _, str := range value {
+				data.WriteString(str + "\x00")
+			}
+
+			count = len(value)
+		case []uint32:
+			_ = binary.Write(data, binary.BigEndian, value)
+			count = len(value)
+		}
+
+		_ = binary.Write(index, binary.BigEndian, []uint32{tag.tag, tag.kind, uint32(offset), uint32(count)})
+	}
+
+	buf := bytes.NewBufferString("\x8e\xad\xe8\x01\x00\x00\x00\x00")
+	_ = binary.Write(buf, binary.BigEndian, []uint32{uint32(len(tags)), uint32(data.Len())})
+	buf.Write(index.Bytes())
+	buf.Write(data.Bytes())
+
+	return buf.Bytes()
+}
@@ -0,0 +1,198 @@
+package xtractr
+
+/* Self-extracting archives: a Windows program with an archive appended. */
+
+import (
+	"bytes"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+)
+
+// FormatSFX is a self-extracting archive. ExtractFile finds these by content when
+// XFile.SFX is true, and FindCompressedFiles finds them when Filter.SFX is true. The archive in it is
+// extracted with the zip, rar or 7z extractor. See DetectSFX.
+const FormatSFX Format = "sfx"
+
+// sfxSearch is how far past the end of the program an archive is looked for.
+// Some programs have a configuration or an installer's data before the archive.
+const sfxSearch = 1 << 20
+
+//nolint:gochecknoglobals
+var (
+	// sfxSignatures are the archives that may be in a self-extracting archive.
+	sfxSignatures = []struct {
+		format Format
+		magic  []byte
+	}{
+		{format: FormatZIP, magic: []byte("PK\x03\x04")},
+		{format: FormatRAR5, magic: []byte("Rar!\x1a\x07\x01\x00")},
+		{format: FormatRAR, magic: []byte("Rar!\x1a\x07\x00")},
+		{format: Format7z, magic: []byte("7z\xbc\xaf\x27\x1c")},
+	}
+	// sfxExtractors read the archive in a self-extracting archive. They
+	// are the built-in extractors, because they know to look for it.
+	sfxExtractors = map[Format]*extractor{
+		FormatZIP:  {list: listZIP, extract: extractZIPVolumes, test: testZIP, fs: fsZIP},
+		FormatRAR5: {list: listRAR, extract: ExtractRAR, test: testRAR, fs: fsRAR},
+		FormatRAR:  {list: listRAR, extract: ExtractRAR, test: testRAR, fs: fsRAR},
+		Format7z:   {list: list7z, extract: Extract7z, test: test7z, fs: fs7z},
+	}
+	// sfxRegistration is used by ExtractFile (and friends) for a self-extracting archive when XFile.SFX is true.
+	// It is not in the registry, so FindCompressedFiles does not return every program.
+	sfxRegistration = &registration{
+		format:    FormatSFX,
+		extractor: &extractor{list: listSFX, extract: ExtractSFX, test: testSFX, fs: fsSFX},
+	}
+)
+
+// DetectSFX returns the format and offset of the archive in a self-extracting archive:
+// a Windows program (PE) with a zip, rar or 7z archive appended to it. The archive is
+// looked for after the end of the program, so archives the program has in it are not found.
+// Returns FormatUnknown if the file is not a program, or no archive is appended to it.
+func DetectSFX(path string) (Format, int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return FormatUnknown, 0, fmt.Errorf("os.Open: %w", err)
+	}
+	defer file.Close()
+
+	size, err := fileSize(file)
+	if err != nil {
+		return FormatUnknown, 0, err
+	}
+
+	return detectSFX(file, size)
+}
+
+// detectSFX finds the end of a program, and the first archive signature after it.
+func detectSFX(reader io.ReaderAt, size int64) (Format, int64, error) {
+	magic := make([]byte, 2) //nolint:gomnd
+	if _, err := reader.ReadAt(magic, 0); err != nil || string(magic) != "MZ" {
+		return FormatUnknown, 0, nil
+	}
+
+	exe, err := pe.NewFile(reader)
+	if err != nil {
+		return FormatUnknown, 0, nil //nolint:nilerr // not a program.
+	}
+
+	start := int64(0)
+	for _, section := range exe.Sections {
+		if end := int64(section.Offset) + int64(section.Size); end > start {
+			start = end
+		}
+	}
+
+	if start >= size {
+		return FormatUnknown, 0, nil
+	}
+
+	end := start + sfxSearch
+	if end > size {
+		end = size
+	}
+
+	search := make([]byte, end-start)
+	if _, err := reader.ReadAt(search, start); err != nil && !errors.Is(err, io.EOF) {
+		return FormatUnknown, 0, fmt.Errorf("reading program: %w", err)
+	}
+
+	format, offset := FormatUnknown, -1
+
+	for _, sig := range sfxSignatures {
+		if idx := bytes.Index(search, sig.magic); idx != -1 && (offset == -1 || idx < offset) {
+			format, offset = sig.format, idx
+		}
+	}
+
+	if offset == -1 {
+		return FormatUnknown, 0, nil
+	}
+
+	return format, start + int64(offset), nil
+}
+
+// isSFX returns true if the file is a self-extracting archive.
+func isSFX(path string) bool {
+	format, _, err := DetectSFX(path)
+	return err == nil && format != FormatUnknown
+}
+
+// ExtractSFX extracts the archive in a self-extracting archive: a Windows program with
+// a zip, rar or 7z archive appended to it. Passwords work like they do for the archive.
+// A rar archive is one volume; the program can not be the first volume of a set.
+// Returns size of extracted data, list of extracted files, list of archives processed, and/or error.
+func ExtractSFX(xFile *XFile) (int64, []string, []string, error) {
+	ext, payload, err := sfxArchive(xFile)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+
+	return ext.Extract(payload)
+}
+
+func listSFX(xFile *XFile) ([]Entry, error) {
+	ext, payload, err := sfxArchive(xFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return ext.List(payload)
+}
+
+func testSFX(xFile *XFile) (int64, []string, error) {
+	ext, payload, err := sfxArchive(xFile)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return ext.test(payload)
+}
+
+func fsSFX(xFile *XFile) (fs.FS, io.Closer, error) {
+	ext, payload, err := sfxArchive(xFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ext.fs(payload)
+}
+
+// sfxArchive finds the archive in a self-extracting archive. Returns
+// the archive's extractor, and a copy of xFile that points to it.
+func sfxArchive(xFile *XFile) (*extractor, *XFile, error) {
+	format, offset, err := DetectSFX(xFile.FilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ext := sfxExtractors[format]
+	if ext == nil {
+		return nil, nil, fmt.Errorf("%w: no archive in program: %s", ErrUnknownArchiveType, xFile.FilePath)
+	}
+
+	payload := *xFile
+	payload.sfxOffset = offset
+
+	return ext, &payload, nil
+}
+
+// openSFX opens a self-extracting archive, and returns the part of it with the archive in it.
+func (x *XFile) openSFX() (*os.File, *io.SectionReader, error) {
+	file, err := os.Open(x.FilePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("os.Open: %w", err)
+	}
+
+	size, err := fileSize(file)
+	if err != nil {
+		file.Close()
+		return nil, nil, err
+	}
+
+	return file, io.NewSectionReader(file, x.sfxOffset, size-x.sfxOffset), nil
+}
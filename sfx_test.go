@@ -0,0 +1,187 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+	"github.com/yeka/zip"
+)
+
+func TestExtractSFXZIP(t *testing.T) {
+	t.Parallel()
+
+	const program = 0x1000
+
+	// The zip is appended as it is, and with its offsets changed to start at the program (zip -A).
+	for _, offset := range []int64{0, program} {
+		dir := t.TempDir()
+		exe := filepath.Join(dir, "setup.exe")
+		assert.NoError(t, makeSFX(exe, program, makeTestZip(t, offset)))
+
+		format, at, err := xtractr.DetectSFX(exe)
+		assert.NoError(t, err)
+		assert.Equal(t, xtractr.FormatZIP, format)
+		assert.Equal(t, int64(program), at, "the zip signature in the program is not the archive")
+
+		assert.Empty(t, xtractr.FindCompressedFiles(xtractr.Filter{Path: dir, DetectByContent: true}),
+			"programs are only read with Filter.SFX")
+		assert.Equal(t, []string{exe}, xtractr.FindCompressedFiles(xtractr.Filter{Path: dir, SFX: true})[dir])
+
+		_, _, _, err = xtractr.ExtractFile(&xtractr.XFile{FilePath: exe, OutputDir: filepath.Join(dir, "out")})
+		assert.ErrorIs(t, err, xtractr.ErrUnknownArchiveType, "programs are only read with XFile.SFX")
+
+		size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{
+			FilePath:  exe,
+			OutputDir: filepath.Join(dir, "out"),
+			SFX:       true,
+		})
+		assert.NoError(t, err, offset)
+		assert.Equal(t, int64(len("self-extracting")), size)
+		assert.Equal(t, []string{exe}, archives)
+		assert.Len(t, files, 1)
+
+		data, err := os.ReadFile(filepath.Join(dir, "out", "readme.txt"))
+		assert.NoError(t, err)
+		assert.Equal(t, "self-extracting", string(data))
+	}
+}
+
+func TestExtractSFXNested(t *testing.T) {
+	t.Parallel()
+
+	// A program in a zip, in a sub-folder, is found by the recursion with Filter.SFX.
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "setup.exe")
+	assert.NoError(t, makeSFX(exe, 0x1000, makeTestZip(t, 0)))
+	program, err := os.ReadFile(exe)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Remove(exe))
+
+	path := filepath.Join(dir, "download")
+	assert.NoError(t, os.MkdirAll(filepath.Join(path, "sub"), xtractr.DefaultDirMode))
+	assert.NoError(t, makeZipFile(filepath.Join(path, "sub", "outer.zip"), map[string]string{"setup.exe": string(program)}))
+
+	queue := xtractr.NewQueue(&xtractr.Config{Logger: &testLogger{t: t}})
+	defer queue.Stop()
+
+	xFile := &xtractr.Xtract{
+		Filter:     xtractr.Filter{Path: path, SFX: true},
+		TempFolder: true,
+		CBChannel:  make(chan *xtractr.Response),
+	}
+
+	_, err = queue.Extract(xFile)
+	assert.NoError(t, err)
+
+	for resp := range xFile.CBChannel {
+		if !resp.Done {
+			continue
+		}
+
+		assert.NoError(t, resp.Error)
+
+		readme := ""
+		for _, file := range resp.NewFiles {
+			if filepath.Base(file) == "readme.txt" {
+				readme = file
+			}
+		}
+
+		data, err := os.ReadFile(readme)
+		assert.NoError(t, err, "the program in the zip file must be extracted")
+		assert.Equal(t, "self-extracting", string(data))
+
+		break
+	}
+}
+
+func TestExtractSFXRAR(t *testing.T) {
+	t.Parallel()
+
+	rar, err := os.ReadFile(testFile)
+	assert.NoError(t, err)
+
+	// The rar library only looks in the first 1MB for an archive.
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "setup.exe")
+	assert.NoError(t, makeSFX(exe, 2<<20, rar))
+
+	entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: exe, Password: "some_password", SFX: true})
+	assert.NoError(t, err)
+	assert.Len(t, entries, len(filesInTestArchive))
+
+	size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{
+		FilePath:  exe,
+		OutputDir: filepath.Join(dir, "out"),
+		Password:  "some_password",
+		SFX:       true,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, testDataSize, size)
+	assert.Equal(t, []string{exe}, archives)
+	assert.Len(t, files, len(filesInTestArchive))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: exe, Password: "some_password", SFX: true})
+	assert.NoError(t, err)
+}
+
+func TestExtractSFXProgram(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	exe := filepath.Join(dir, "program.exe")
+	assert.NoError(t, makeSFX(exe, 0x1000, nil))
+
+	format, _, err := xtractr.DetectSFX(exe)
+	assert.NoError(t, err)
+	assert.Equal(t, xtractr.FormatUnknown, format)
+
+	assert.Empty(t, xtractr.FindCompressedFiles(xtractr.Filter{Path: dir, SFX: true}))
+
+	for _, sfx := range []bool{false, true} {
+		_, _, _, err = xtractr.ExtractFile(&xtractr.XFile{FilePath: exe, OutputDir: filepath.Join(dir, "out"), SFX: sfx})
+		assert.ErrorIs(t, err, xtractr.ErrUnknownArchiveType, "a program without an archive is not one")
+	}
+}
+
+// makeSFX writes a Windows program with one section that fills it, then appends the archive.
+// The section has a zip signature in it, that is not an archive.
+func makeSFX(path string, size int, archive []byte) error {
+	const sectionHeader = 0x58
+
+	program := make([]byte, size)
+	copy(program, "MZ")
+	binary.LittleEndian.PutUint32(program[0x3c:], 0x40)
+	copy(program[0x40:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(program[0x44:], 0x14c) // i386.
+	binary.LittleEndian.PutUint16(program[0x46:], 1)     // sections.
+	copy(program[sectionHeader:], ".text")
+	binary.LittleEndian.PutUint32(program[sectionHeader+16:], uint32(size-0x200))
+	binary.LittleEndian.PutUint32(program[sectionHeader+20:], 0x200)
+	copy(program[0x200:], "PK\x03\x04 is in here")
+
+	return os.WriteFile(path, append(program, archive...), 0o600) //nolint:wrapcheck
+}
+
+// makeTestZip returns a zip file with one file in it, with its offsets moved by offset.
+func makeTestZip(t *testing.T, offset int64) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buf)
+	zipWriter.SetOffset(offset)
+
+	writer, err := zipWriter.Create("readme.txt")
+	assert.NoError(t, err)
+
+	_, err = writer.Write([]byte("self-extracting"))
+	assert.NoError(t, err)
+	assert.NoError(t, zipWriter.Close())
+
+	return buf.Bytes()
+}
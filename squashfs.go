@@ -0,0 +1,842 @@
+package xtractr
+
+/* Code to extract SquashFS 4.0 images, like firmware root file systems and AppImage payloads. */
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+const (
+	squashfsMagic = "hsqs"
+	// squashfsMetaSize is the uncompressed size of a metadata block. Blocks with this bit are stored.
+	squashfsMetaSize         = 8192
+	squashfsMetaUncompressed = 0x8000
+	// squashfsBlockUncompressed marks a data block or fragment that is stored.
+	squashfsBlockUncompressed = 1 << 24
+	squashfsNoFragment        = 0xffffffff
+	squashfsMaxBlockSize      = 1024 * 1024
+	// squashfsFragmentsPerBlock is how many fragment table entries fit in a metadata block.
+	squashfsFragmentsPerBlock = squashfsMetaSize / 16
+)
+
+// SquashFS compression IDs.
+const (
+	squashfsGzip = 1
+	squashfsLZMA = 2
+	squashfsLZO  = 3
+	squashfsXZ   = 4
+	squashfsLZ4  = 5
+	squashfsZstd = 6
+)
+
+// SquashFS inode types. The extended types are the basic type + 7.
+const (
+	squashfsDir      = 1
+	squashfsFile     = 2
+	squashfsSymlink  = 3
+	squashfsBlockDev = 4
+	squashfsCharDev  = 5
+	squashfsFIFO     = 6
+	squashfsSocket   = 7
+	squashfsExtended = 7
+)
+
+//nolint:gochecknoglobals
+var squashfsSuffixes = []string{".squashfs", ".sqsh", ".sfs"}
+
+// squashfsSuper is the superblock at the start of an image.
+type squashfsSuper struct {
+	Magic       [4]byte
+	Inodes      uint32
+	ModTime     uint32
+	BlockSize   uint32
+	Fragments   uint32
+	Compression uint16
+	BlockLog    uint16
+	Flags       uint16
+	IDs         uint16
+	Major       uint16
+	Minor       uint16
+	Root        uint64
+	BytesUsed   uint64
+	IDTable     uint64
+	XattrTable  uint64
+	InodeTable  uint64
+	DirTable    uint64
+	FragTable   uint64
+	ExportTable uint64
+}
+
+// squashfs is an open SquashFS image.
+type squashfs struct {
+	img   *os.File
+	super squashfsSuper
+	// decompress decompresses a block, up to size bytes.
+	decompress func(src []byte, size int) ([]byte, error)
+	zstd       *zstd.Decoder
+	// meta caches decompressed metadata blocks by position. fragment caches the last fragment block.
+	meta     map[int64]*squashfsMetaBlock
+	fragment struct {
+		index uint32
+		data  []byte
+	}
+}
+
+type squashfsMetaBlock struct {
+	data []byte
+	next int64
+}
+
+// squashfsInode is the part of an inode needed to extract it.
+type squashfsInode struct {
+	kind    uint16
+	mode    os.FileMode
+	modTime time.Time
+	// Directories.
+	dirBlock  uint32
+	dirOffset uint16
+	dirSize   uint32
+	// Files.
+	start      uint64
+	size       uint64
+	fragment   uint32
+	fragOffset uint32
+	blocks     []uint32
+	// Symlinks.
+	target string
+}
+
+// squashfsDirEntry is an entry in a directory listing.
+type squashfsDirEntry struct {
+	name  string
+	inode uint64
+}
+
+// metaReader reads consecutive metadata blocks as one stream.
+type metaReader struct {
+	s   *squashfs
+	pos int64
+	buf []byte
+}
+
+// squashfsFileReader reads a file's data blocks, then its fragment.
+type squashfsFileReader struct {
+	s         *squashfs
+	inode     *squashfsInode
+	block     int
+	pos       int64
+	remaining int64
+	buf       []byte
+}
+
+// ExtractSquashFS writes a SquashFS image's contents to disk. Like ExtractISO, the files
+// are written into a folder named after the image. gzip, lzma, xz, lz4 and zstd images are
+// supported. Permissions are kept, and symlinks are created if they point inside the output folder
+// without going through another symlink. Nothing is written through a symlink. See squashfsLinks.
+func ExtractSquashFS(xFile *XFile) (int64, []string, error) {
+	sqfs, err := openSquashFS(xFile.FilePath)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer sqfs.Close()
+
+	files := []string{}
+	size := int64(0)
+	dirs := map[string]os.FileMode{}
+	links := &squashfsLinks{XFile: xFile, links: map[string]bool{}, traversed: map[string]bool{}}
+
+	err = sqfs.walk(xFile.squashfsRoot(), func(name string, inode *squashfsInode) error {
+		destFile, err := xFile.outputPath(name)
+		if err != nil || destFile == "" {
+			return err
+		}
+
+		if links.through(destFile) {
+			return fmt.Errorf("%w: %s is written through a symlink (from: %s)", ErrInvalidPath, destFile, name)
+		}
+
+		switch {
+		case inode.mode.IsDir():
+			dirs[destFile] = inode.mode.Perm()
+			if err := os.MkdirAll(destFile, xFile.DirMode); err != nil {
+				return fmt.Errorf("os.MkdirAll: %w", err)
+			}
+		case inode.mode.IsRegular():
+			fSize, err := xFile.writeFile(destFile, sqfs.open(inode), inode.mode.Perm(), xFile.DirMode)
+			if size += fSize; err != nil {
+				return err
+			}
+
+			files = append(files, destFile)
+
+			if err := os.Chmod(destFile, inode.mode.Perm()); err != nil {
+				return fmt.Errorf("os.Chmod: %w", err)
+			}
+		case inode.mode&os.ModeSymlink != 0:
+			if links.create(destFile, inode.target) {
+				files = append(files, destFile)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return size, files, fmt.Errorf("%s: %w", xFile.FilePath, err)
+	}
+
+	// Folders get their permissions last, so read-only folders can be written into first.
+	// The owner keeps full access, so the output can be moved and deleted.
+	for dir, perm := range dirs {
+		if err := os.Chmod(dir, perm|0o700); err != nil { //nolint:gomnd
+			return size, files, fmt.Errorf("os.Chmod: %w", err)
+		}
+	}
+
+	return size, files, nil
+}
+
+// listSquashFS lists the contents of a SquashFS image. Names use the same root folder as ExtractSquashFS.
+func listSquashFS(xFile *XFile) ([]Entry, error) {
+	sqfs, err := openSquashFS(xFile.FilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer sqfs.Close()
+
+	entries := []Entry{}
+	err = sqfs.walk(xFile.squashfsRoot(), func(name string, inode *squashfsInode) error {
+		entries = append(entries, newEntry(name, int64(inode.size), -1, inode.mode, inode.modTime))
+		return nil
+	})
+
+	if err != nil {
+		return entries, fmt.Errorf("%s: %w", xFile.FilePath, err)
+	}
+
+	return entries, nil
+}
+
+// testSquashFS decompresses every file in a SquashFS image. SquashFS has no checksums.
+func testSquashFS(xFile *XFile) (int64, []string, error) {
+	sqfs, err := openSquashFS(xFile.FilePath)
+	if err != nil {
+		return 0, []string{xFile.FilePath}, err
+	}
+	defer sqfs.Close()
+
+	failed := &IntegrityError{Archive: xFile.FilePath}
+	size := int64(0)
+
+	err = sqfs.walk(xFile.squashfsRoot(), func(name string, inode *squashfsInode) error {
+		if inode.mode.IsRegular() {
+			size += failed.discard(name, sqfs.open(inode))
+		}
+
+		return nil
+	})
+	if err != nil {
+		return size, []string{xFile.FilePath}, fmt.Errorf("%s: %w", xFile.FilePath, err)
+	}
+
+	return size, []string{xFile.FilePath}, failed.err()
+}
+
+// squashfsRoot returns the name of the folder an image is extracted into: the image's name without its suffix.
+func (x *XFile) squashfsRoot() string {
+	name := filepath.Base(x.FilePath)
+	for _, suffix := range squashfsSuffixes {
+		if strings.HasSuffix(strings.ToLower(name), suffix) {
+			return name[:len(name)-len(suffix)]
+		}
+	}
+
+	return name
+}
+
+// squashfsLinks are the symlinks created by ExtractSquashFS. Nothing is written through them,
+// and their targets do not go through each other, because a symlink to a folder changes where
+// a path with .. in it goes. Otherwise links could be chained to write outside the output folder.
+type squashfsLinks struct {
+	*XFile
+	links map[string]bool
+	// traversed are the paths the targets of the links go through. They can not become links.
+	traversed map[string]bool
+}
+
+// through returns true if path is a symlink created earlier, or inside one.
+func (l *squashfsLinks) through(path string) bool {
+	for ; l.within(path) && path != filepath.Clean(l.OutputDir); path = filepath.Dir(path) {
+		if l.links[path] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// create makes a symlink if its target stays inside the output folder,
+// without going through another link. Returns true if it was created.
+func (l *squashfsLinks) create(link, target string) bool {
+	if filepath.IsAbs(target) || l.traversed[link] {
+		return false
+	}
+
+	// Follow the target one part at a time, like the file system does.
+	resolved, parts := filepath.Dir(link), strings.Split(filepath.ToSlash(target), "/")
+	traversed := []string{}
+
+	for idx, part := range parts {
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			resolved = filepath.Dir(resolved)
+		default:
+			resolved = filepath.Join(resolved, part)
+		}
+
+		if idx < len(parts)-1 {
+			if l.links[resolved] {
+				return false
+			}
+
+			traversed = append(traversed, resolved)
+		}
+	}
+
+	if !strings.HasPrefix(resolved, filepath.Clean(l.OutputDir)+string(filepath.Separator)) {
+		return false
+	}
+
+	if err := os.MkdirAll(filepath.Dir(link), l.DirMode); err != nil {
+		return false
+	}
+
+	if err := os.Symlink(target, link); err != nil {
+		return false
+	}
+
+	l.links[link] = true
+	for _, path := range traversed {
+		l.traversed[path] = true
+	}
+
+	return true
+}
+
+// openSquashFS opens an image and reads its superblock.
+func openSquashFS(filePath string) (*squashfs, error) {
+	img, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+
+	sqfs := &squashfs{img: img, meta: make(map[int64]*squashfsMetaBlock)}
+	sqfs.fragment.index = squashfsNoFragment
+
+	if err := sqfs.readSuper(); err != nil {
+		sqfs.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	return sqfs, nil
+}
+
+// readSuper reads the superblock, and picks the decompressor.
+func (s *squashfs) readSuper() error {
+	if err := binary.Read(io.NewSectionReader(s.img, 0, 96), binary.LittleEndian, &s.super); err != nil { //nolint:gomnd
+		return fmt.Errorf("%w: reading squashfs superblock: %v", ErrInvalidHead, err) //nolint:errorlint
+	}
+
+	switch {
+	case string(s.super.Magic[:]) != squashfsMagic:
+		return fmt.Errorf("%w: missing squashfs magic", ErrInvalidHead)
+	case s.super.Major != 4: //nolint:gomnd
+		return fmt.Errorf("%w: squashfs version %d.%d", ErrUnsupportedMethod, s.super.Major, s.super.Minor)
+	case s.super.BlockSize == 0 || s.super.BlockSize > squashfsMaxBlockSize:
+		return fmt.Errorf("%w: squashfs block size %d", ErrInvalidHead, s.super.BlockSize)
+	}
+
+	switch s.super.Compression {
+	case squashfsGzip:
+		s.decompress = squashfsReader(func(r io.Reader) (io.Reader, error) { return zlib.NewReader(r) })
+	case squashfsLZMA:
+		s.decompress = squashfsReader(func(r io.Reader) (io.Reader, error) { return lzma.NewReader(r) })
+	case squashfsXZ:
+		s.decompress = squashfsReader(func(r io.Reader) (io.Reader, error) { return xz.NewReader(r) })
+	case squashfsLZ4:
+		s.decompress = func(src []byte, size int) ([]byte, error) {
+			out := make([]byte, size)
+			n, err := lz4.UncompressBlock(src, out)
+
+			return out[:n], err //nolint:wrapcheck
+		}
+	case squashfsZstd:
+		var err error
+		if s.zstd, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1),
+			zstd.WithDecoderMaxMemory(2*squashfsMaxBlockSize)); err != nil { //nolint:gomnd
+			return fmt.Errorf("zstd.NewReader: %w", err)
+		}
+
+		s.decompress = func(src []byte, size int) ([]byte, error) {
+			out, err := s.zstd.DecodeAll(src, make([]byte, 0, size))
+			if err == nil && len(out) > size {
+				err = fmt.Errorf("%w: squashfs block is larger than %d bytes", ErrCorruptArchive, size)
+			}
+
+			return out, err
+		}
+	case squashfsLZO:
+		return fmt.Errorf("%w: squashfs lzo compression", ErrUnsupportedMethod)
+	default:
+		return fmt.Errorf("%w: squashfs compression %d", ErrUnsupportedMethod, s.super.Compression)
+	}
+
+	return nil
+}
+
+// squashfsReader turns a streaming decompressor into a block decompressor.
+func squashfsReader(open func(io.Reader) (io.Reader, error)) func(src []byte, size int) ([]byte, error) {
+	return func(src []byte, size int) ([]byte, error) {
+		reader, err := open(bytes.NewReader(src))
+		if err != nil {
+			return nil, err
+		}
+
+		out, err := io.ReadAll(io.LimitReader(reader, int64(size)+1))
+		if err == nil && len(out) > size {
+			err = fmt.Errorf("%w: squashfs block is larger than %d bytes", ErrCorruptArchive, size)
+		}
+
+		return out, err
+	}
+}
+
+// Close closes the image.
+func (s *squashfs) Close() {
+	if s.zstd != nil {
+		s.zstd.Close()
+	}
+
+	s.img.Close()
+}
+
+// walk calls fn for every inode in the image, parents first, starting with the root folder.
+func (s *squashfs) walk(root string, fn func(name string, inode *squashfsInode) error) error {
+	return s.walkInode(s.super.Root, root, fn, map[uint64]bool{})
+}
+
+func (s *squashfs) walkInode(ref uint64, name string, fn func(string, *squashfsInode) error, seen map[uint64]bool) error {
+	inode, err := s.readInode(ref)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if err := fn(name, inode); err != nil || !inode.mode.IsDir() {
+		return err
+	}
+
+	if seen[ref] {
+		return fmt.Errorf("%s: %w: folder loop", name, ErrInvalidHead)
+	}
+
+	seen[ref] = true
+
+	entries, err := s.readDir(inode)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	for _, entry := range entries {
+		if err := s.walkInode(entry.inode, path.Join(name, entry.name), fn, seen); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// metaBlock reads and decompresses the metadata block at pos.
+func (s *squashfs) metaBlock(pos int64) (*squashfsMetaBlock, error) {
+	if block, ok := s.meta[pos]; ok {
+		return block, nil
+	}
+
+	header := make([]byte, 2) //nolint:gomnd
+	if _, err := s.img.ReadAt(header, pos); err != nil {
+		return nil, fmt.Errorf("reading squashfs metadata: %w", noEOF(err))
+	}
+
+	length := binary.LittleEndian.Uint16(header)
+	size := int64(length &^ squashfsMetaUncompressed)
+
+	if size > squashfsMetaSize {
+		return nil, fmt.Errorf("%w: squashfs metadata block is %d bytes", ErrInvalidHead, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := s.img.ReadAt(data, pos+2); err != nil {
+		return nil, fmt.Errorf("reading squashfs metadata: %w", noEOF(err))
+	}
+
+	if length&squashfsMetaUncompressed == 0 {
+		var err error
+		if data, err = s.decompress(data, squashfsMetaSize); err != nil {
+			return nil, fmt.Errorf("squashfs metadata: %w", err)
+		}
+	}
+
+	block := &squashfsMetaBlock{data: data, next: pos + 2 + size}
+	s.meta[pos] = block
+
+	return block, nil
+}
+
+// metaReader returns a reader for the metadata starting at offset in the block at pos.
+func (s *squashfs) metaReader(pos int64, offset int) (*metaReader, error) {
+	block, err := s.metaBlock(pos)
+	if err != nil {
+		return nil, err
+	}
+
+	if offset > len(block.data) {
+		return nil, fmt.Errorf("%w: squashfs metadata offset %d is outside its block", ErrInvalidHead, offset)
+	}
+
+	return &metaReader{s: s, pos: block.next, buf: block.data[offset:]}, nil
+}
+
+func (m *metaReader) Read(data []byte) (int, error) {
+	for len(m.buf) == 0 {
+		block, err := m.s.metaBlock(m.pos)
+		if err != nil {
+			return 0, err
+		}
+
+		if len(block.data) == 0 {
+			return 0, io.ErrUnexpectedEOF
+		}
+
+		m.buf, m.pos = block.data, block.next
+	}
+
+	size := copy(data, m.buf)
+	m.buf = m.buf[size:]
+
+	return size, nil
+}
+
+// readInode reads the inode an inode reference points to: its metadata block, and the offset in it.
+func (s *squashfs) readInode(ref uint64) (*squashfsInode, error) {
+	reader, err := s.metaReader(int64(s.super.InodeTable+ref>>16), int(ref&0xffff)) //nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+
+	var header struct {
+		Kind, Perm, UID, GID uint16
+		ModTime, Number      uint32
+	}
+
+	if err := binary.Read(reader, binary.LittleEndian, &header); err != nil {
+		return nil, fmt.Errorf("reading squashfs inode: %w", noEOF(err))
+	}
+
+	inode := &squashfsInode{
+		kind:     header.Kind,
+		modTime:  time.Unix(int64(header.ModTime), 0),
+		fragment: squashfsNoFragment,
+	}
+	// The type bits are added by read, for types other than files.
+	inode.mode = unixMode(uint32(header.Perm)&0o7777 | unixTypeRegular) //nolint:gomnd
+
+	if err := inode.read(reader, s.super.BlockSize); err != nil {
+		return nil, fmt.Errorf("reading squashfs inode: %w", noEOF(err))
+	}
+
+	return inode, nil
+}
+
+// read reads the part of an inode that depends on its type.
+func (i *squashfsInode) read(reader io.Reader, blockSize uint32) error {
+	read := func(data ...interface{}) error {
+		for _, field := range data {
+			if err := binary.Read(reader, binary.LittleEndian, field); err != nil {
+				return err //nolint:wrapcheck
+			}
+		}
+
+		return nil
+	}
+
+	var (
+		skip32 uint32
+		skip16 uint16
+		size32 uint32
+		start  uint32
+	)
+
+	switch i.kind {
+	case squashfsDir:
+		i.mode |= os.ModeDir
+		var size16 uint16
+
+		err := read(&i.dirBlock, &skip32, &size16, &i.dirOffset, &skip32)
+		i.dirSize = uint32(size16)
+
+		return err
+	case squashfsDir + squashfsExtended:
+		i.mode |= os.ModeDir
+		// link count, size, block, parent, index count, offset, xattr. The index is not needed.
+		return read(&skip32, &i.dirSize, &i.dirBlock, &skip32, &skip16, &i.dirOffset, &skip32)
+	case squashfsFile:
+		if err := read(&start, &i.fragment, &i.fragOffset, &size32); err != nil {
+			return err
+		}
+
+		i.start, i.size = uint64(start), uint64(size32)
+	case squashfsFile + squashfsExtended:
+		// start, size, sparse, link count, fragment, offset, xattr.
+		if err := read(&i.start, &i.size, new(uint64), &skip32, &i.fragment, &i.fragOffset, &skip32); err != nil {
+			return err
+		}
+	case squashfsSymlink, squashfsSymlink + squashfsExtended:
+		i.mode |= os.ModeSymlink
+		if err := read(&skip32, &size32); err != nil {
+			return err
+		}
+
+		if size32 > cpioMaxName {
+			return fmt.Errorf("%w: squashfs symlink target is %d bytes", ErrInvalidHead, size32)
+		}
+
+		target := make([]byte, size32)
+		_, err := io.ReadFull(reader, target)
+		i.target = string(target)
+
+		return err //nolint:wrapcheck
+	case squashfsBlockDev, squashfsBlockDev + squashfsExtended:
+		i.mode |= os.ModeDevice
+		return nil
+	case squashfsCharDev, squashfsCharDev + squashfsExtended:
+		i.mode |= os.ModeDevice | os.ModeCharDevice
+		return nil
+	case squashfsFIFO, squashfsFIFO + squashfsExtended:
+		i.mode |= os.ModeNamedPipe
+		return nil
+	case squashfsSocket, squashfsSocket + squashfsExtended:
+		i.mode |= os.ModeSocket
+		return nil
+	default:
+		return fmt.Errorf("%w: squashfs inode type %d", ErrInvalidHead, i.kind)
+	}
+
+	// Files: one block size for each full block, and the last partial block if it's not in a fragment.
+	count := i.size / uint64(blockSize)
+	if i.fragment == squashfsNoFragment && i.size%uint64(blockSize) != 0 {
+		count++
+	}
+
+	for ; count > 0; count-- {
+		var block uint32
+		if err := read(&block); err != nil {
+			return err
+		}
+
+		i.blocks = append(i.blocks, block)
+	}
+
+	return nil
+}
+
+// readDir reads a folder's listing.
+func (s *squashfs) readDir(inode *squashfsInode) ([]squashfsDirEntry, error) {
+	if inode.dirSize <= 3 { //nolint:gomnd // the size includes 3 bytes that are not stored.
+		return nil, nil
+	}
+
+	reader, err := s.metaReader(int64(s.super.DirTable)+int64(inode.dirBlock), int(inode.dirOffset))
+	if err != nil {
+		return nil, err
+	}
+
+	entries := []squashfsDirEntry{}
+	listing := &io.LimitedReader{R: reader, N: int64(inode.dirSize) - 3} //nolint:gomnd
+
+	for listing.N > 0 {
+		var header struct{ Count, Start, Number uint32 }
+		if err := binary.Read(listing, binary.LittleEndian, &header); err != nil {
+			return entries, fmt.Errorf("reading squashfs folder: %w", noEOF(err))
+		}
+
+		if header.Count >= 256 { //nolint:gomnd
+			return entries, fmt.Errorf("%w: squashfs folder header has %d entries", ErrInvalidHead, header.Count+1)
+		}
+
+		for idx := uint32(0); idx <= header.Count; idx++ {
+			var entry struct{ Offset, Number, Kind, NameSize uint16 }
+			if err := binary.Read(listing, binary.LittleEndian, &entry); err != nil {
+				return entries, fmt.Errorf("reading squashfs folder: %w", noEOF(err))
+			}
+
+			name := make([]byte, int(entry.NameSize)+1)
+			if _, err := io.ReadFull(listing, name); err != nil {
+				return entries, fmt.Errorf("reading squashfs folder: %w", noEOF(err))
+			}
+
+			if bytes.ContainsAny(name, "/\x00") || string(name) == "." || string(name) == ".." {
+				return entries, fmt.Errorf("%w: squashfs file name %q", ErrInvalidPath, name)
+			}
+
+			entries = append(entries, squashfsDirEntry{name: string(name), inode: uint64(header.Start)<<16 | uint64(entry.Offset)})
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].name < entries[j].name })
+
+	return entries, nil
+}
+
+// open returns a reader for a file's data.
+func (s *squashfs) open(inode *squashfsInode) io.Reader {
+	return &squashfsFileReader{s: s, inode: inode, pos: int64(inode.start), remaining: int64(inode.size)}
+}
+
+func (f *squashfsFileReader) Read(data []byte) (int, error) {
+	for len(f.buf) == 0 {
+		if f.remaining <= 0 {
+			return 0, io.EOF
+		}
+
+		var err error
+		if f.buf, err = f.next(); err != nil {
+			return 0, err
+		}
+
+		if int64(len(f.buf)) > f.remaining {
+			f.buf = f.buf[:f.remaining]
+		}
+
+		f.remaining -= int64(len(f.buf))
+	}
+
+	size := copy(data, f.buf)
+	f.buf = f.buf[size:]
+
+	return size, nil
+}
+
+// next returns the next data block, or the file's fragment after the last block.
+func (f *squashfsFileReader) next() ([]byte, error) {
+	blockSize := int(f.s.super.BlockSize)
+
+	if f.block >= len(f.inode.blocks) {
+		if f.inode.fragment == squashfsNoFragment {
+			return nil, io.ErrUnexpectedEOF
+		}
+
+		fragment, err := f.s.readFragment(f.inode.fragment)
+		if err != nil {
+			return nil, err
+		}
+
+		start, end := int64(f.inode.fragOffset), int64(f.inode.fragOffset)+f.remaining
+		if end > int64(len(fragment)) {
+			return nil, fmt.Errorf("%w: squashfs fragment is too short", ErrCorruptArchive)
+		}
+
+		return fragment[start:end], nil
+	}
+
+	entry := f.inode.blocks[f.block]
+	f.block++
+
+	size := int64(entry &^ squashfsBlockUncompressed)
+	if size == 0 { // sparse block.
+		return make([]byte, blockSize), nil
+	}
+
+	data, err := f.s.readBlock(f.pos, size, entry&squashfsBlockUncompressed != 0)
+	f.pos += size
+
+	return data, err
+}
+
+// readFragment reads a fragment block, which holds the ends of several files.
+func (s *squashfs) readFragment(index uint32) ([]byte, error) {
+	if s.fragment.index == index {
+		return s.fragment.data, nil
+	}
+
+	if index >= s.super.Fragments {
+		return nil, fmt.Errorf("%w: squashfs fragment %d of %d", ErrInvalidHead, index, s.super.Fragments)
+	}
+
+	pointer := make([]byte, 8) //nolint:gomnd
+	if _, err := s.img.ReadAt(pointer, int64(s.super.FragTable)+int64(index/squashfsFragmentsPerBlock)*8); err != nil {
+		return nil, fmt.Errorf("reading squashfs fragment table: %w", noEOF(err))
+	}
+
+	reader, err := s.metaReader(int64(binary.LittleEndian.Uint64(pointer)), int(index%squashfsFragmentsPerBlock)*16) //nolint:gomnd
+	if err != nil {
+		return nil, err
+	}
+
+	var entry struct {
+		Start        uint64
+		Size, Unused uint32
+	}
+
+	if err := binary.Read(reader, binary.LittleEndian, &entry); err != nil {
+		return nil, fmt.Errorf("reading squashfs fragment table: %w", noEOF(err))
+	}
+
+	data, err := s.readBlock(int64(entry.Start), int64(entry.Size&^squashfsBlockUncompressed),
+		entry.Size&squashfsBlockUncompressed != 0)
+	if err != nil {
+		return nil, err
+	}
+
+	s.fragment.index, s.fragment.data = index, data
+
+	return data, nil
+}
+
+// readBlock reads a data block or a fragment block, and decompresses it.
+func (s *squashfs) readBlock(pos, size int64, stored bool) ([]byte, error) {
+	if size > int64(s.super.BlockSize) {
+		return nil, fmt.Errorf("%w: squashfs block is %d bytes", ErrInvalidHead, size)
+	}
+
+	data := make([]byte, size)
+	if _, err := s.img.ReadAt(data, pos); err != nil {
+		return nil, fmt.Errorf("reading squashfs block: %w", noEOF(err))
+	}
+
+	if stored {
+		return data, nil
+	}
+
+	data, err := s.decompress(data, int(s.super.BlockSize))
+	if err != nil && !errors.Is(err, ErrCorruptArchive) {
+		err = fmt.Errorf("%w: squashfs block: %v", ErrCorruptArchive, err) //nolint:errorlint
+	}
+
+	return data, err
+}
@@ -0,0 +1,394 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+// SquashFS compression IDs.
+const (
+	sqfsGzip = 1
+	sqfsXZ   = 4
+	sqfsLZ4  = 5
+	sqfsZstd = 6
+)
+
+type sqfsTestFile struct {
+	name   string
+	mode   uint16 // permissions.
+	data   string
+	target string // symlinks.
+	dir    bool
+}
+
+func testSquashFSFiles() []sqfsTestFile {
+	return []sqfsTestFile{
+		{name: "bin", mode: 0o755, dir: true},
+		{name: "bin/busybox", mode: 0o755, data: strings.Repeat("\x7fELF busybox binary ", 700)},
+		{name: "bin/sh", target: "busybox"},
+		{name: "etc", mode: 0o755, dir: true},
+		{name: "etc/hostname", mode: 0o644, data: "router\n"},
+		{name: "etc/shadow", mode: 0o600, data: "root:*:19000:0:99999:7:::\n"},
+		{name: "etc/evil", target: "../../../../etc/passwd"},
+		{name: "empty", mode: 0o700, dir: true},
+		{name: "zeros.img", mode: 0o644, data: strings.Repeat("\x00", 8192) + "end"},
+	}
+}
+
+func TestExtractSquashFS(t *testing.T) {
+	t.Parallel()
+
+	for _, compression := range []uint16{sqfsGzip, sqfsXZ, sqfsLZ4, sqfsZstd} {
+		dir := t.TempDir()
+		image := filepath.Join(dir, "rootfs.squashfs")
+		assert.NoError(t, makeSquashFS(image, compression, testSquashFSFiles()))
+
+		format, err := xtractr.DetectFormat(image)
+		assert.NoError(t, err)
+		assert.Equal(t, xtractr.FormatSquashFS, format)
+
+		entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: image})
+		assert.NoError(t, err, compression)
+		assert.Len(t, entries, len(testSquashFSFiles())+1, "every file, and the root folder")
+		assert.Equal(t, "rootfs", entries[0].Name)
+
+		// Like ExtractISO, the files go in a folder named after the image.
+		out := filepath.Join(dir, "out")
+		size, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: image, OutputDir: out})
+		assert.NoError(t, err, compression)
+		assert.ElementsMatch(t, []string{
+			filepath.Join(out, "rootfs", "bin", "busybox"),
+			filepath.Join(out, "rootfs", "bin", "sh"),
+			filepath.Join(out, "rootfs", "etc", "hostname"),
+			filepath.Join(out, "rootfs", "etc", "shadow"),
+			filepath.Join(out, "rootfs", "zeros.img"),
+		}, files, "symlinks pointing out of the output folder are not created")
+
+		total := int64(0)
+
+		for _, file := range testSquashFSFiles() {
+			if file.dir || file.target != "" {
+				continue
+			}
+
+			total += int64(len(file.data))
+			data, err := os.ReadFile(filepath.Join(out, "rootfs", file.name))
+			assert.NoError(t, err, file.name)
+			assert.Equal(t, file.data, string(data), file.name)
+
+			info, err := os.Stat(filepath.Join(out, "rootfs", file.name))
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(file.mode), info.Mode().Perm(), file.name)
+		}
+
+		assert.Equal(t, total, size)
+
+		target, err := os.Readlink(filepath.Join(out, "rootfs", "bin", "sh"))
+		assert.NoError(t, err)
+		assert.Equal(t, "busybox", target)
+		assert.DirExists(t, filepath.Join(out, "rootfs", "empty"))
+
+		_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: image})
+		assert.NoError(t, err)
+	}
+}
+
+func TestExtractSquashFSLinks(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	image := filepath.Join(dir, "links.squashfs")
+	assert.NoError(t, makeSquashFS(image, sqfsGzip, []sqfsTestFile{
+		{name: "d", mode: 0o755, dir: true},
+		{name: "d/e", mode: 0o755, dir: true},
+		{name: "d/e/s", target: "../.."},
+		// Both stay inside when .. is applied to the name, but not when s and m are followed.
+		{name: "l", target: "m/m/m/m/d/../../../../x"},
+		{name: "m", target: "."},
+		{name: "t", target: "d/e/s/../../x"},
+	}))
+
+	out := filepath.Join(dir, "out")
+	_, files, _, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: image, OutputDir: out})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{filepath.Join(out, "links", "d", "e", "s"), filepath.Join(out, "links", "l")}, files,
+		"links through other links are not created")
+
+	for _, name := range []string{"m", "t"} {
+		_, err := os.Lstat(filepath.Join(out, "links", name))
+		assert.ErrorIs(t, err, os.ErrNotExist, name)
+	}
+
+	// A folder with the same name as a link is written through the link.
+	image = filepath.Join(dir, "through.squashfs")
+	assert.NoError(t, makeSquashFS(image, sqfsGzip, []sqfsTestFile{
+		{name: "up", target: "."},
+		{name: "up", mode: 0o755, dir: true},
+		{name: "up/file", mode: 0o644, data: "through the link"},
+	}))
+
+	out = filepath.Join(dir, "through")
+	_, _, _, err = xtractr.ExtractFile(&xtractr.XFile{FilePath: image, OutputDir: out})
+	assert.ErrorIs(t, err, xtractr.ErrInvalidPath)
+	assert.NoFileExists(t, filepath.Join(out, "through", "file"))
+}
+
+// sqfsImage builds a SquashFS image. Every table fits in one metadata block,
+// so inode and folder references are offsets into the first block.
+type sqfsImage struct {
+	compression uint16
+	data        bytes.Buffer
+	fragment    []byte
+	fragments   [][2]uint64 // start, size.
+}
+
+// sqfsNode is a file or folder in the image being built.
+type sqfsNode struct {
+	sqfsTestFile
+	number   uint32
+	children []*sqfsNode
+	// pos is the node's offset in the inode table, listing its offset in the folder table.
+	pos, listing, listingSize int
+	blocks                    []uint32
+	start                     uint32
+	fragment, fragOffset      uint32
+}
+
+// makeSquashFS writes a SquashFS image with a 4KB block size.
+func makeSquashFS(fileName string, compression uint16, files []sqfsTestFile) error {
+	const blockSize = 4096
+
+	img := &sqfsImage{compression: compression}
+	img.data.Write(make([]byte, 96)) //nolint:gomnd // superblock, written last.
+
+	root := &sqfsNode{sqfsTestFile: sqfsTestFile{mode: 0o755, dir: true}}
+	nodes := map[string]*sqfsNode{"": root}
+	all := []*sqfsNode{root}
+
+	for _, file := range files {
+		node := &sqfsNode{sqfsTestFile: file, fragment: 0xffffffff}
+		parent := nodes[path.Dir("/" + file.name)[1:]]
+		parent.children = append(parent.children, node)
+		nodes[file.name] = node
+		all = append(all, node)
+
+		if file.dir || file.target != "" {
+			continue
+		}
+
+		// Full blocks are written as data blocks. Sparse blocks are not written. The rest goes in a fragment.
+		node.start = uint32(img.data.Len())
+		data := []byte(file.data)
+
+		for ; len(data) >= blockSize; data = data[blockSize:] {
+			if bytes.Equal(data[:blockSize], make([]byte, blockSize)) {
+				node.blocks = append(node.blocks, 0)
+				continue
+			}
+
+			node.blocks = append(node.blocks, img.writeBlock(data[:blockSize]))
+		}
+
+		if len(data) > 0 {
+			node.fragment, node.fragOffset = uint32(len(img.fragments)), uint32(len(img.fragment))
+			img.fragment = append(img.fragment, data...)
+		}
+	}
+
+	if len(img.fragment) > 0 {
+		start := uint64(img.data.Len())
+		size := img.writeBlock(img.fragment)
+		img.fragments = append(img.fragments, [2]uint64{start, uint64(size)})
+
+		for _, node := range all {
+			if node.fragment != 0xffffffff {
+				node.fragment = 0
+			}
+		}
+	}
+
+	// Inode sizes do not depend on their contents, so their positions are known first.
+	pos := 0
+
+	for idx, node := range all {
+		node.number, node.pos = uint32(idx+1), pos
+
+		sort.Slice(node.children, func(i, j int) bool { return node.children[i].name < node.children[j].name })
+
+		switch {
+		case node.dir:
+			pos += 32
+		case node.target != "":
+			pos += 24 + len(node.target)
+		default:
+			pos += 32 + 4*len(node.blocks)
+		}
+	}
+
+	listings := &bytes.Buffer{}
+
+	for _, node := range all {
+		if !node.dir {
+			continue
+		}
+
+		node.listing = listings.Len()
+
+		if len(node.children) > 0 {
+			_ = binary.Write(listings, binary.LittleEndian, []uint32{uint32(len(node.children) - 1), 0, node.children[0].number})
+		}
+
+		for _, child := range node.children {
+			name := path.Base(child.name)
+			_ = binary.Write(listings, binary.LittleEndian, []uint16{
+				uint16(child.pos), uint16(child.number - node.children[0].number), child.kind(), uint16(len(name) - 1),
+			})
+			listings.WriteString(name)
+		}
+
+		node.listingSize = listings.Len() - node.listing
+	}
+
+	inodes := &bytes.Buffer{}
+
+	for _, node := range all {
+		_ = binary.Write(inodes, binary.LittleEndian, []uint16{node.kind(), node.mode | map[bool]uint16{true: 0o777}[node.target != ""], 0, 0})
+		_ = binary.Write(inodes, binary.LittleEndian, []uint32{1700000000, node.number})
+
+		switch {
+		case node.dir:
+			_ = binary.Write(inodes, binary.LittleEndian, []uint32{0, 2})
+			_ = binary.Write(inodes, binary.LittleEndian, []uint16{uint16(node.listingSize + 3), uint16(node.listing)})
+			_ = binary.Write(inodes, binary.LittleEndian, uint32(len(all)+1))
+		case node.target != "":
+			_ = binary.Write(inodes, binary.LittleEndian, []uint32{1, uint32(len(node.target))})
+			inodes.WriteString(node.target)
+		default:
+			_ = binary.Write(inodes, binary.LittleEndian, []uint32{node.start, node.fragment, node.fragOffset, uint32(len(node.data))})
+			_ = binary.Write(inodes, binary.LittleEndian, node.blocks)
+		}
+	}
+
+	super := struct {
+		Magic                                           [4]byte
+		Inodes, ModTime, BlockSize, Fragments           uint32
+		Compression, BlockLog, Flags, IDs, Major, Minor uint16
+		Root, BytesUsed, IDTable, XattrTable            uint64
+		InodeTable, DirTable, FragTable, ExportTable    uint64
+	}{
+		Magic: [4]byte{'h', 's', 'q', 's'}, Inodes: uint32(len(all)), ModTime: 1700000000, BlockSize: blockSize,
+		Fragments: uint32(len(img.fragments)), Compression: compression, BlockLog: 12, Flags: 0x200, IDs: 1,
+		Major: 4, Root: uint64(root.pos), XattrTable: ^uint64(0), ExportTable: ^uint64(0),
+	}
+
+	super.InodeTable = uint64(img.data.Len())
+	img.writeMeta(inodes.Bytes())
+	super.DirTable = uint64(img.data.Len())
+	img.writeMeta(listings.Bytes())
+
+	fragTable := &bytes.Buffer{}
+	for _, fragment := range img.fragments {
+		_ = binary.Write(fragTable, binary.LittleEndian, []uint64{fragment[0], fragment[1]})
+	}
+
+	fragBlock := uint64(img.data.Len())
+	img.writeMeta(fragTable.Bytes())
+	super.FragTable = uint64(img.data.Len())
+	_ = binary.Write(&img.data, binary.LittleEndian, fragBlock)
+
+	idBlock := uint64(img.data.Len())
+	img.writeMeta([]byte{0, 0, 0, 0})
+	super.IDTable = uint64(img.data.Len())
+	_ = binary.Write(&img.data, binary.LittleEndian, idBlock)
+	super.BytesUsed = uint64(img.data.Len())
+
+	buf := &bytes.Buffer{}
+	_ = binary.Write(buf, binary.LittleEndian, &super)
+	image := img.data.Bytes()
+	copy(image, buf.Bytes())
+
+	return os.WriteFile(fileName, image, 0o600) //nolint:wrapcheck
+}
+
+// kind returns the node's basic inode type.
+func (n *sqfsNode) kind() uint16 {
+	switch {
+	case n.dir:
+		return 1
+	case n.target != "":
+		return 3 //nolint:gomnd
+	default:
+		return 2 //nolint:gomnd
+	}
+}
+
+// writeBlock writes a data block, and returns its size entry for an inode or the fragment table.
+func (i *sqfsImage) writeBlock(data []byte) uint32 {
+	compressed := i.compress(data)
+	if len(compressed) >= len(data) {
+		i.data.Write(data)
+		return uint32(len(data)) | 1<<24
+	}
+
+	i.data.Write(compressed)
+
+	return uint32(len(compressed))
+}
+
+// writeMeta writes a metadata block, with its 2 byte header.
+func (i *sqfsImage) writeMeta(data []byte) {
+	compressed := i.compress(data)
+	if len(compressed) >= len(data) {
+		_ = binary.Write(&i.data, binary.LittleEndian, uint16(len(data))|0x8000)
+		i.data.Write(data)
+
+		return
+	}
+
+	_ = binary.Write(&i.data, binary.LittleEndian, uint16(len(compressed)))
+	i.data.Write(compressed)
+}
+
+func (i *sqfsImage) compress(data []byte) []byte {
+	buf := &bytes.Buffer{}
+
+	switch i.compression {
+	case sqfsGzip:
+		writer := zlib.NewWriter(buf)
+		_, _ = writer.Write(data)
+		_ = writer.Close()
+	case sqfsXZ:
+		writer, _ := xz.NewWriter(buf)
+		_, _ = writer.Write(data)
+		_ = writer.Close()
+	case sqfsLZ4:
+		out := make([]byte, lz4.CompressBlockBound(len(data)))
+		size, _ := lz4.CompressBlock(data, out, nil)
+
+		if size == 0 {
+			return data // incompressible.
+		}
+
+		return out[:size]
+	case sqfsZstd:
+		encoder, _ := zstd.NewWriter(nil)
+		defer encoder.Close()
+
+		return encoder.EncodeAll(data, nil)
+	}
+
+	return buf.Bytes()
+}
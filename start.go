@@ -3,6 +3,7 @@ package xtractr
 import (
 	"fmt"
 	"os"
+	"time"
 )
 
 // Sane defaults.
@@ -13,6 +14,8 @@ const (
 	// DefaultBufferSize is the size of the extraction buffer.
 	// ie. How many jobs can be queued before things get slow.
 	DefaultBufferSize = 1000
+	// DefaultProgressInterval is how often progress callbacks are called.
+	DefaultProgressInterval = time.Second
 )
 
 // Config is the input data to configure the Xtract queue. Fill this out and
@@ -31,6 +34,17 @@ type Config struct {
 	Suffix string
 	// Logs are sent to this Logger.
 	Logger
+	// Path to a journal file. Optional. When set, every queued Xtract is recorded in this
+	// file (as JSON lines), and jobs that never finished are queued again by Start(),
+	// ie. after a crash or restart. Interrupted jobs have their temporary (Suffix) output
+	// folder removed before they retry. Callbacks, contexts and password providers are not saved; use Restore.
+	// The file is rewritten with only the unfinished jobs every 1000 lines, and when every job finished.
+	// Password and Passwords are saved in plain text. The file is created readable by its owner only,
+	// so keep it in a private folder, or use a PasswordProvider (and Restore) to keep them out of it.
+	Journal string
+	// Restore is called with each job restored from the Journal, before it's queued.
+	// Use this to attach callbacks. Optional.
+	Restore func(*Xtract)
 }
 
 // Logger allows this library to write logs.
@@ -43,9 +57,10 @@ type Logger interface {
 // Xtractr is what you get from NewQueue(). This is the main app struct.
 // Use this struct to call Xtractr.Extract() to queue an extraction.
 type Xtractr struct {
-	config *Config
-	queue  chan *Xtract
-	done   chan struct{}
+	config  *Config
+	queue   chan *Xtract
+	done    chan struct{}
+	journal *journal
 }
 
 // Custom errors returned by this module.
@@ -58,6 +73,23 @@ var (
 	ErrQueueRunning       = fmt.Errorf("extractor queue running, cannot start")
 	ErrNoConfig           = fmt.Errorf("call NewQueue() to initialize a queue")
 	ErrNoLogger           = fmt.Errorf("xtractr.Config.Logger must be non-nil")
+	ErrNoTester           = fmt.Errorf("archive type does not support integrity testing")
+	ErrTestFailed         = fmt.Errorf("archive failed integrity test")
+	ErrChecksum           = fmt.Errorf("checksum mismatch")
+	ErrNoFS               = fmt.Errorf("archive type cannot be opened as a file system")
+	ErrIsDir              = fmt.Errorf("is a directory")
+	ErrNotDir             = fmt.Errorf("not a directory")
+	ErrSizeLimit          = fmt.Errorf("extraction size limit exceeded")
+	ErrEntryLimit         = fmt.Errorf("extraction file count limit exceeded")
+	ErrRatioLimit         = fmt.Errorf("extraction compression ratio limit exceeded")
+	ErrDepthLimit         = fmt.Errorf("extraction nesting depth limit exceeded")
+	ErrWrongPassword      = fmt.Errorf("wrong archive password")
+	ErrEncrypted          = fmt.Errorf("archive is encrypted and no password was provided")
+	ErrCorruptArchive     = fmt.Errorf("archive is corrupt")
+	ErrMissingVolume      = fmt.Errorf("archive volume is missing")
+	ErrTruncated          = fmt.Errorf("archive is truncated")
+	ErrUnsupportedMethod  = fmt.Errorf("archive uses an unsupported compression or encryption method")
+	ErrUnknownCharset     = fmt.Errorf("unknown character set")
 )
 
 // NewQueue returns a new Xtractr Queue you can send Xtract jobs into.
@@ -89,12 +121,25 @@ func (x *Xtractr) Start() error {
 		return ErrNoLogger
 	}
 
+	var unfinished []*journalRecord
+
+	if x.config.Journal != "" {
+		journal, records, err := openJournal(x.config.Journal)
+		if err != nil {
+			return err
+		}
+
+		x.journal, unfinished = journal, records
+	}
+
 	x.queue = make(chan *Xtract, x.config.BuffSize)
 
 	for i := 0; i < x.config.Parallel; i++ {
 		go x.processQueue()
 	}
 
+	x.restoreJournal(unfinished)
+
 	return nil
 }
 
@@ -141,5 +186,9 @@ func (x *Xtractr) Stop() {
 		<-x.done
 	}
 
-	x.queue = nil
+	if err := x.journal.close(); err != nil {
+		x.config.Printf("Error: %v", err)
+	}
+
+	x.queue, x.journal = nil, nil
 }
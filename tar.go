@@ -2,95 +2,44 @@ package xtractr
 
 import (
 	"archive/tar"
-	"compress/bzip2"
-	"compress/gzip"
 	"errors"
 	"fmt"
 	"io"
 	"os"
-	"strings"
+)
+
+const (
+	// tarBlockSize is the size of a tar header; the ustar magic lives inside it.
+	tarBlockSize = 512
+	// gzipTrailerSize is the length of the uncompressed size (mod 2^32) at the end of a gzip file.
+	gzipTrailerSize = 4
 )
 
 // ExtractTar extracts a raw (non-compressed) tar archive.
 func ExtractTar(xFile *XFile) (int64, []string, error) {
-	tarFile, err := os.Open(xFile.FilePath)
-	if err != nil {
-		return 0, nil, fmt.Errorf("os.Open: %w", err)
-	}
-	defer tarFile.Close()
-
-	return xFile.untar(tar.NewReader(tarFile))
+	return xFile.extractStream(FormatTar)
 }
 
 // ExtractBzip extracts a bzip2-compressed file. That is, a single file.
+// If the file holds a tarball, or more compression layers, they are extracted too.
 func ExtractBzip(xFile *XFile) (int64, []string, error) {
-	compressedFile, err := os.Open(xFile.FilePath)
-	if err != nil {
-		return 0, nil, fmt.Errorf("os.Open: %w", err)
-	}
-	defer compressedFile.Close()
-
-	// Get the absolute path of the file were writing.
-	wfile := xFile.clean(xFile.FilePath, ".bz", ".bz2")
-
-	size, err := writeFile(wfile, bzip2.NewReader(compressedFile), xFile.FileMode, xFile.DirMode)
-	if err != nil {
-		return size, nil, err
-	}
-
-	return size, []string{wfile}, nil
+	return xFile.extractStream(FormatBzip2)
 }
 
 // ExtractGzip extracts a gzip-compressed file. That is, a single file.
+// If the file holds a tarball, or more compression layers, they are extracted too.
 func ExtractGzip(xFile *XFile) (int64, []string, error) {
-	compressedFile, err := os.Open(xFile.FilePath)
-	if err != nil {
-		return 0, nil, fmt.Errorf("os.Open: %w", err)
-	}
-	defer compressedFile.Close()
-
-	zipReader, err := gzip.NewReader(compressedFile)
-	if err != nil {
-		return 0, nil, fmt.Errorf("gzip.NewReader: %w", err)
-	}
-
-	// Get the absolute path of the file were writing.
-	wfile := xFile.clean(xFile.FilePath, ".gz")
-
-	size, err := writeFile(wfile, zipReader, xFile.FileMode, xFile.DirMode)
-	if err != nil {
-		return size, nil, err
-	}
-
-	return size, []string{wfile}, nil
+	return xFile.extractStream(FormatGzip)
 }
 
-// ExtractTarBzip extracts a bzip2-compressed tar archive.
+// ExtractTarBzip extracts a bzip2-compressed tar archive. It is the same as ExtractBzip.
 func ExtractTarBzip(xFile *XFile) (int64, []string, error) {
-	compressedFile, err := os.Open(xFile.FilePath)
-	if err != nil {
-		return 0, nil, fmt.Errorf("os.Open: %w", err)
-	}
-	defer compressedFile.Close()
-
-	return xFile.untar(tar.NewReader(bzip2.NewReader(compressedFile)))
+	return xFile.extractStream(FormatBzip2)
 }
 
-// ExtractTarGzip extracts a gzip-compressed tar archive.
+// ExtractTarGzip extracts a gzip-compressed tar archive. It is the same as ExtractGzip.
 func ExtractTarGzip(xFile *XFile) (int64, []string, error) {
-	compressedFile, err := os.Open(xFile.FilePath)
-	if err != nil {
-		return 0, nil, fmt.Errorf("os.Open: %w", err)
-	}
-	defer compressedFile.Close()
-
-	gzipstream, err := gzip.NewReader(compressedFile)
-	if err != nil {
-		return 0, nil, fmt.Errorf("gzip.NewReader: %w", err)
-	}
-	defer gzipstream.Close()
-
-	return xFile.untar(tar.NewReader(gzipstream))
+	return xFile.extractStream(FormatGzip)
 }
 
 func (x *XFile) untar(tarReader *tar.Reader) (int64, []string, error) {
@@ -109,26 +58,58 @@ func (x *XFile) untar(tarReader *tar.Reader) (int64, []string, error) {
 			return size, files, fmt.Errorf("%w: %s", ErrInvalidHead, x.FilePath)
 		}
 
-		wfile := x.clean(header.Name)
-		if !strings.HasPrefix(wfile, x.OutputDir) {
-			// The file being written is trying to write outside of our base path. Malicious archive?
-			return size, files, fmt.Errorf("%s: %w: %s (from: %s)", x.FilePath, ErrInvalidPath, wfile, header.Name)
+		wfile, fSize, err := x.writeEntry(header.Name, header.FileInfo().Mode(), tarReader)
+		if err != nil {
+			return size, files, err
+		}
+
+		if wfile != "" {
+			files = append(files, wfile)
+			size += fSize
 		}
+	}
+}
 
-		if header.Typeflag == tar.TypeDir {
-			if err = os.MkdirAll(wfile, header.FileInfo().Mode()); err != nil {
-				return size, files, fmt.Errorf("os.MkdirAll: %w", err)
-			}
+// writeEntry writes one entry from a tarball or cpio archive. Folders are created,
+// and every other entry is written as a file; links and devices have no data to write.
+// Returns the file written, or an empty string for a folder or a skipped file.
+func (x *XFile) writeEntry(name string, mode os.FileMode, data io.Reader) (string, int64, error) {
+	wfile, err := x.outputPath(name)
+	if err != nil {
+		return "", 0, fmt.Errorf("%s: %w", x.FilePath, err)
+	} else if wfile == "" {
+		return "", 0, nil
+	}
 
-			continue
+	if mode.IsDir() {
+		if err := os.MkdirAll(wfile, mode); err != nil {
+			return "", 0, fmt.Errorf("os.MkdirAll: %w", err)
 		}
 
-		fSize, err := writeFile(wfile, tarReader, header.FileInfo().Mode(), x.DirMode)
-		if err != nil {
-			return size, files, err
+		return "", 0, nil
+	}
+
+	size, err := x.writeFile(wfile, data, mode, x.DirMode)
+
+	return wfile, size, err
+}
+
+func (x *XFile) listTar(tarReader *tar.Reader) ([]Entry, error) {
+	entries := []Entry{}
+
+	for {
+		header, err := tarReader.Next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return entries, nil
+		case err != nil:
+			return entries, fmt.Errorf("%s: tarReader.Next: %w", x.FilePath, err)
+		case header == nil:
+			return entries, fmt.Errorf("%w: %s", ErrInvalidHead, x.FilePath)
 		}
 
-		files = append(files, wfile)
-		size += fSize
+		// Compressed size is unknown when the whole tarball is compressed.
+		entries = append(entries, newEntry(header.Name, header.Size, -1, header.FileInfo().Mode(), header.ModTime))
 	}
 }
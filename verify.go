@@ -0,0 +1,405 @@
+package xtractr
+
+/* Code to test the integrity of an archive without writing anything to disk. */
+
+import (
+	"archive/tar"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kdomanski/iso9660"
+)
+
+// Tester is an optional interface an Extractor may implement to support TestFile.
+type Tester interface {
+	// Test decompresses every entry in an archive into io.Discard and verifies checksums.
+	// Returns size of data tested, list of archives tested, and/or error.
+	Test(xFile *XFile) (int64, []string, error)
+}
+
+// IntegrityError is returned by TestFile when one or more entries in an archive are damaged.
+// Use errors.Is(err, ErrTestFailed) to check for it, or errors.As to read the entries.
+type IntegrityError struct {
+	// Archive that was tested.
+	Archive string
+	// Entries that failed the test.
+	Entries []EntryError
+}
+
+// EntryError is an entry inside an archive that failed an integrity test.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+// TestFile is the equivalent of `unrar t` or `7z t`. It decompresses every entry in
+// an archive into io.Discard and verifies the checksums the format provides:
+// CRC32 for ZIP and 7-Zip, RAR checksums, and the gzip and bzip2 trailers.
+// Nothing is written to disk, so xFile.OutputDir is not used.
+// Returns size of data tested, list of archives tested, and/or error.
+// If any entries fail, the error is an *IntegrityError that lists all of them.
+func TestFile(xFile *XFile) (int64, []string, error) {
+	reg, err := registrationFor(xFile.FilePath, xFile.SFX)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	tester, ok := reg.extractor.(Tester)
+	if !ok {
+		return 0, nil, fmt.Errorf("%w: %s: %s", ErrNoTester, reg.format, xFile.FilePath)
+	}
+
+	xFile.tries = xFile.passwordList()
+	defer func() { xFile.tries = nil }()
+
+	size, archives, err := tester.Test(xFile)
+
+	return size, archives, archiveError(err, xFile.hasPassword())
+}
+
+func (e *IntegrityError) Error() string {
+	msgs := make([]string, len(e.Entries))
+	for idx, entry := range e.Entries {
+		msgs[idx] = entry.Name + ": " + entry.Err.Error()
+	}
+
+	return fmt.Sprintf("%s: %v: %d damaged: %s", e.Archive, ErrTestFailed, len(e.Entries), strings.Join(msgs, "; "))
+}
+
+// Is allows errors.Is(err, ErrTestFailed) to work. It also matches the errors
+// of the damaged entries, so errors.Is(err, ErrCorruptArchive) works too.
+func (e *IntegrityError) Is(target error) bool {
+	if target == ErrTestFailed { //nolint:errorlint,goerr113
+		return true
+	}
+
+	for _, entry := range e.Entries {
+		if errors.Is(entry.Err, target) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// add records a damaged entry.
+func (e *IntegrityError) add(name string, err error) {
+	e.Entries = append(e.Entries, EntryError{Name: name, Err: archiveError(err, true)})
+}
+
+func (e EntryError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+// Unwrap returns the entry's error.
+func (e EntryError) Unwrap() error {
+	return e.Err
+}
+
+// discard reads an entry to the end and records it as damaged if that fails.
+func (e *IntegrityError) discard(name string, reader io.Reader) int64 {
+	size, err := io.Copy(io.Discard, reader)
+	if err != nil {
+		e.add(name, err)
+	}
+
+	return size
+}
+
+// err returns nil if nothing failed, so it can be returned directly.
+func (e *IntegrityError) err() error {
+	if len(e.Entries) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// testZIP tries each password like ExtractZIP does, until one reads every file in a zip archive.
+func testZIP(xFile *XFile) (int64, []string, error) {
+	passwords := xFile.passwordList()
+	if len(passwords) == 1 {
+		return testZIPPassword(xFile)
+	}
+
+	for idx, password := range passwords {
+		attempt := *xFile
+		attempt.Password, attempt.Passwords = password, nil
+
+		size, archives, err := testZIPPassword(&attempt)
+		if err == nil {
+			xFile.passwordWorked(password)
+			return size, archives, nil
+		}
+
+		if !errors.Is(err, ErrWrongPassword) && !errors.Is(err, ErrEncrypted) {
+			return size, archives, fmt.Errorf("used password %d of %d: %w", idx+1, len(passwords), err)
+		}
+
+		if idx == len(passwords)-1 {
+			return size, archives, wrongPassword(err)
+		}
+	}
+
+	return 0, nil, nil // unreachable, passwordList always returns one item.
+}
+
+// testZIPPassword reads every file in a zip archive. The zip reader verifies each CRC32.
+func testZIPPassword(xFile *XFile) (int64, []string, error) {
+	zipReader, err := openZIP(xFile)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer zipReader.Close()
+
+	failed := &IntegrityError{Archive: xFile.FilePath}
+	size := int64(0)
+
+	for _, zipFile := range zipReader.File {
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+
+		if zipFile.IsEncrypted() {
+			zipFile.SetPassword(xFile.Password)
+		}
+
+		zFile, err := zipFile.Open()
+		if err != nil {
+			failed.add(zipFile.Name, zipPasswordError(zipFile, xFile.Password, err))
+			continue
+		}
+
+		fSize, err := io.Copy(io.Discard, zFile)
+		size += fSize
+
+		zFile.Close()
+
+		if err != nil {
+			failed.add(zipFile.Name, zipPasswordError(zipFile, xFile.Password, err))
+		}
+	}
+
+	return size, zipReader.volumes, failed.err()
+}
+
+// testRAR tries each password until one opens the archive, then reads every file in it.
+func testRAR(xFile *XFile) (int64, []string, error) {
+	passwords := xFile.passwordList()
+	if len(passwords) == 1 {
+		return testRARPassword(xFile)
+	}
+
+	// Try all the passwords, except the blank one at the end.
+	passwords = passwords[:len(passwords)-1]
+
+	for idx, password := range passwords {
+		attempt := *xFile
+		attempt.Password, attempt.Passwords = password, nil
+
+		size, archives, err := testRARPassword(&attempt)
+		if err == nil {
+			xFile.passwordWorked(password)
+			return size, archives, nil
+		}
+
+		// https://github.com/nwaples/rardecode/issues/28
+		if !errors.Is(err, ErrWrongPassword) && !errors.Is(err, ErrEncrypted) {
+			return size, archives, fmt.Errorf("used password %d of %d: %w", idx+1, len(passwords), err)
+		}
+
+		if idx == len(passwords)-1 {
+			return size, archives, wrongPassword(err)
+		}
+	}
+
+	return 0, nil, nil // unreachable, passwordList always returns one item.
+}
+
+// testRARPassword reads every file in a rar archive. The rar reader verifies each checksum.
+func testRARPassword(xFile *XFile) (int64, []string, error) {
+	rarReader, volumes, err := openRAR(xFile, xFile.Password)
+	if err != nil {
+		return 0, volumes.files, archiveError(err, xFile.Password != "")
+	}
+	defer rarReader.Close()
+
+	failed := &IntegrityError{Archive: xFile.FilePath}
+	size := int64(0)
+
+	for {
+		header, err := rarReader.Next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			return size, volumes.files, failed.err()
+		case err != nil:
+			return size, volumes.files, archiveError(fmt.Errorf("%s: rarReader.Next: %w",
+				xFile.FilePath, volumeError(err)), xFile.Password != "")
+		case header == nil:
+			return size, volumes.files, fmt.Errorf("%w: %s", ErrInvalidHead, xFile.FilePath)
+		case header.IsDir:
+			continue
+		}
+
+		size += failed.discard(header.Name, rarReader)
+	}
+}
+
+// test7z tries each password until one works, then verifies the CRC32 of every file.
+func test7z(xFile *XFile) (int64, []string, error) {
+	passwords := xFile.passwordList()
+	if len(passwords) == 1 {
+		return test7zPassword(xFile)
+	}
+
+	// Try all the passwords, except the blank one at the end.
+	passwords = passwords[:len(passwords)-1]
+
+	for idx, password := range passwords {
+		attempt := *xFile
+		attempt.Password, attempt.Passwords = password, nil
+
+		size, archives, err := test7zPassword(&attempt)
+		if err == nil {
+			xFile.passwordWorked(password)
+			return size, archives, nil
+		}
+
+		if !errors.Is(err, ErrWrongPassword) && !errors.Is(err, ErrEncrypted) {
+			return size, archives, fmt.Errorf("used password %d of %d: %w", idx+1, len(passwords), err)
+		}
+
+		if idx == len(passwords)-1 {
+			return size, archives, wrongPassword(err)
+		}
+	}
+
+	return 0, nil, nil // unreachable, passwordList always returns one item.
+}
+
+// test7zPassword reads every file in a 7zip archive and compares its CRC32 to the header.
+func test7zPassword(xFile *XFile) (int64, []string, error) {
+	sevenZip, err := open7z(xFile, xFile.Password)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer sevenZip.Close()
+
+	failed := &IntegrityError{Archive: xFile.FilePath}
+	size := int64(0)
+
+	for _, zipFile := range sevenZip.File {
+		if zipFile.FileInfo().IsDir() {
+			continue
+		}
+
+		zFile, err := sevenZip.open(zipFile)
+		if err != nil {
+			failed.add(zipFile.Name, err)
+			continue
+		}
+
+		hash := crc32.NewIEEE()
+		fSize, err := io.Copy(hash, zFile)
+		size += fSize
+
+		zFile.Close()
+
+		switch {
+		case err != nil:
+			failed.add(zipFile.Name, err)
+		case zipFile.CRC32 != 0 && hash.Sum32() != zipFile.CRC32: // 0 means no CRC was recorded.
+			failed.add(zipFile.Name, fmt.Errorf("%w: crc32 %08x != %08x", ErrChecksum, hash.Sum32(), zipFile.CRC32))
+		}
+	}
+
+	return size, sevenZip.Volumes(), failed.err()
+}
+
+// testTar reads every entry in a tar stream, then drains the stream
+// so a compression layer underneath gets to verify its trailer.
+func (x *XFile) testTar(stream io.Reader) (int64, []string, error) {
+	tarReader := tar.NewReader(stream)
+	failed := &IntegrityError{Archive: x.FilePath}
+	size := int64(0)
+
+	for {
+		header, err := tarReader.Next()
+
+		switch {
+		case errors.Is(err, io.EOF):
+			if _, err := io.Copy(io.Discard, stream); err != nil {
+				failed.add(filepath.Base(x.FilePath), err)
+			}
+
+			return size, []string{x.FilePath}, failed.err()
+		case err != nil:
+			return size, []string{x.FilePath}, fmt.Errorf("%s: tarReader.Next: %w", x.FilePath, err)
+		case header == nil:
+			return size, []string{x.FilePath}, fmt.Errorf("%w: %s", ErrInvalidHead, x.FilePath)
+		}
+
+		size += failed.discard(header.Name, tarReader)
+	}
+}
+
+// testISO reads every file in an ISO image. ISO9660 has no checksums, so this only proves the data is readable.
+func testISO(xFile *XFile) (int64, []string, error) {
+	openISO, err := os.Open(xFile.FilePath)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open iso file: %s: %w", xFile.FilePath, err)
+	}
+	defer openISO.Close()
+
+	iso, err := iso9660.OpenImage(openISO)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open iso image: %s: %w", xFile.FilePath, err)
+	}
+
+	root, err := iso.RootDir()
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to open iso root: %s: %w", xFile.FilePath, err)
+	}
+
+	failed := &IntegrityError{Archive: xFile.FilePath}
+	size, err := testISOFile(root, "", failed)
+
+	if err != nil {
+		return size, []string{xFile.FilePath}, fmt.Errorf("%s: %w", xFile.FilePath, err)
+	}
+
+	return size, []string{xFile.FilePath}, failed.err()
+}
+
+func testISOFile(isoFile *iso9660.File, parent string, failed *IntegrityError) (int64, error) {
+	itemName := filepath.Join(parent, isoFile.Name())
+	if isoFile.Name() == string([]byte{0}) { // root folder has no name.
+		itemName = parent
+	}
+
+	if !isoFile.IsDir() {
+		return failed.discard(itemName, isoFile.Reader()), nil
+	}
+
+	children, err := isoFile.GetChildren()
+	if err != nil {
+		return 0, fmt.Errorf("getting children for %s: %w", isoFile.Name(), err)
+	}
+
+	size := int64(0)
+
+	for _, child := range children {
+		childSize, err := testISOFile(child, itemName, failed)
+		if size += childSize; err != nil {
+			return size, err
+		}
+	}
+
+	return size, nil
+}
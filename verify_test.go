@@ -0,0 +1,108 @@
+package xtractr_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+	yzip "github.com/yeka/zip"
+)
+
+func TestTestFile(t *testing.T) {
+	t.Parallel()
+
+	size, archives, err := xtractr.TestFile(&xtractr.XFile{
+		FilePath:  testFile,
+		Passwords: []string{"wrong", "some_password"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, testDataSize, size)
+	assert.Equal(t, []string{testFile}, archives)
+
+	// A wrong password is reported as one, not as a missing password.
+	for _, archive := range []string{testFile, "test_data/encrypted.7z"} {
+		_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: archive, Passwords: []string{"wrong"}})
+		assert.ErrorIs(t, err, xtractr.ErrWrongPassword, archive)
+		assert.NotErrorIs(t, err, xtractr.ErrEncrypted, archive)
+	}
+
+	// Build a zip with two stored files, then damage the second one.
+	buf := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buf)
+
+	for _, name := range []string{"good.txt", "bad.txt"} {
+		writer, err := zipWriter.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store})
+		assert.NoError(t, err)
+		_, err = writer.Write([]byte("content of " + name))
+		assert.NoError(t, err)
+	}
+
+	assert.NoError(t, zipWriter.Close())
+
+	data := bytes.Replace(buf.Bytes(), []byte("content of bad"), []byte("CONTENT of bad"), 1)
+	zipFile := filepath.Join(t.TempDir(), "damaged.zip")
+	assert.NoError(t, os.WriteFile(zipFile, data, xtractr.DefaultFileMode))
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: zipFile})
+	assert.ErrorIs(t, err, xtractr.ErrTestFailed)
+
+	var integrity *xtractr.IntegrityError
+	if assert.True(t, errors.As(err, &integrity)) {
+		assert.Equal(t, 1, len(integrity.Entries), "only one entry is damaged")
+		assert.Equal(t, "bad.txt", integrity.Entries[0].Name)
+	}
+}
+
+func TestTestZIPPasswords(t *testing.T) {
+	t.Parallel()
+
+	archive := filepath.Join(t.TempDir(), "secret.zip")
+	assert.NoError(t, makeEncryptedZip(archive, "secret", yzip.StandardEncryption))
+
+	_, _, err := xtractr.TestFile(&xtractr.XFile{FilePath: archive})
+	assert.ErrorIs(t, err, xtractr.ErrEncrypted)
+
+	_, _, err = xtractr.TestFile(&xtractr.XFile{FilePath: archive, Passwords: []string{"wrong", "also wrong"}})
+	assert.ErrorIs(t, err, xtractr.ErrWrongPassword)
+
+	// The password is only in Passwords, like ExtractZIP uses it.
+	size, _, err := xtractr.TestFile(&xtractr.XFile{FilePath: archive, Passwords: []string{"wrong", "secret"}})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len("secret")*100), size)
+}
+
+func TestTestOnly(t *testing.T) {
+	t.Parallel()
+
+	queue := xtractr.NewQueue(&xtractr.Config{Logger: &testLogger{t: t}})
+	defer queue.Stop()
+
+	xFile := &xtractr.Xtract{
+		Filter:    xtractr.Filter{Path: testSetupTestDir(t)},
+		Password:  "some_password",
+		TestOnly:  true,
+		CBChannel: make(chan *xtractr.Response),
+	}
+	defer os.RemoveAll(xFile.Path)
+
+	_, err := queue.Extract(xFile)
+	assert.NoError(t, err)
+
+	for resp := range xFile.CBChannel {
+		if !resp.Done {
+			continue
+		}
+
+		assert.NoError(t, resp.Error)
+		assert.Equal(t, testDataSize*4, resp.Size, "every archive must be tested")
+		assert.Equal(t, 0, len(resp.NewFiles), "nothing is written in test mode")
+		assert.NoDirExists(t, xFile.Path+xtractr.DefaultSuffix)
+
+		break
+	}
+}
@@ -0,0 +1,275 @@
+package xtractr
+
+/* Find every volume of a multi-volume archive before it is extracted. */
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// VolumeSet is the volumes of a multi-volume archive, found by name.
+type VolumeSet struct {
+	Format Format
+	// Volumes is every volume that was found, in order.
+	Volumes []string
+	// Missing is every volume that does not exist or is empty, in order. A rar or zip set
+	// that is missing volumes at the end only names the one after the last volume found.
+	Missing []string
+}
+
+// maxVolumes is the most volumes a set may have. Suffixes have 3 digits.
+const maxVolumes = 999
+
+// volumeNaming is how the volumes of a multi-volume archive are named.
+type volumeNaming struct {
+	format Format
+	// match finds a volume. The first group is the set's name, and the second is the
+	// volume's label. index turns a label into the volume's place in the set, and label
+	// turns it back, in the same case and width as another label (like).
+	match *regexp.Regexp
+	index func(label string) int
+	label func(idx int, like string) string
+	// count returns how many volumes a set has, at least, from the volumes found.
+	count func(found map[int]string, last int) int
+	// firstOptional is true if a set may start at the second volume.
+	firstOptional bool
+}
+
+//nolint:gochecknoglobals
+var volumeNamings = []*volumeNaming{
+	{format: Format7z, match: regexp.MustCompile(`(?i)^(.+)\.7z\.([0-9]{3})$`), index: volumeNumber, label: volumeLabel, count: sevenZipCount},
+	{format: FormatZIP, match: regexp.MustCompile(`(?i)^(.+)\.zip\.([0-9]{3})$`), index: volumeNumber, label: volumeLabel, count: zipPieceCount},
+	{format: FormatRAR, match: regexp.MustCompile(`(?i)^(.+)\.part([0-9]+)\.rar$`), index: volumeNumber, label: volumeLabel, count: rarCount},
+	{
+		// name.rar, name.r00, name.r01, …; some sets have no name.rar.
+		format:        FormatRAR,
+		match:         regexp.MustCompile(`(?i)^(.+)\.(rar|r[0-9]{2,3})$`),
+		index:         rarOldIndex,
+		label:         rarOldLabel,
+		count:         rarCount,
+		firstOptional: true,
+	},
+}
+
+// ScanVolumes finds the volumes of the multi-volume archive that path belongs to: a 7z
+// archive (name.7z.001), a rar archive (name.part1.rar, or name.rar and name.r00) or a
+// split zip (name.zip.001, or name.z01 and name.zip). Volumes are found by name, and
+// the last volume found is checked for an end of archive, so volumes missing from the
+// end of a set are found too. This only reads a few bytes of a volume.
+// A missing or empty volume returns an ErrMissingVolume that names it, with the set.
+// Returns nil, nil if path is not part of a multi-volume archive.
+func ScanVolumes(path string) (*VolumeSet, error) {
+	for _, naming := range volumeNamings {
+		if naming.match.MatchString(filepath.Base(path)) {
+			return naming.scan(path)
+		}
+	}
+
+	if lowerName := strings.ToLower(path); zipSplitPart.MatchString(lowerName) || strings.HasSuffix(lowerName, ".zip") {
+		return zipSpannedVolumes(path)
+	}
+
+	return nil, nil //nolint:nilnil
+}
+
+// scan reads the folder with path in it, for the other volumes in its set.
+func (n *volumeNaming) scan(path string) (*VolumeSet, error) {
+	dir, base := filepath.Split(path)
+	match := n.match.FindStringSubmatchIndex(base)
+	setName, like := base[match[2]:match[3]], base[match[4]:match[5]]
+
+	fileList, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return nil, fmt.Errorf("os.ReadDir: %w", err)
+	}
+
+	found := map[int]string{}
+	first, last := -1, -1
+
+	for _, file := range fileList {
+		volume := n.match.FindStringSubmatch(file.Name())
+		if file.IsDir() || volume == nil || !strings.EqualFold(volume[1], setName) {
+			continue
+		}
+
+		idx := n.index(volume[2])
+		if found[idx] == "" || file.Name() == base {
+			found[idx] = filepath.Join(dir, file.Name())
+		}
+
+		if idx > last {
+			last = idx
+		}
+
+		if first == -1 || idx < first {
+			first = idx
+		}
+	}
+
+	total := last + 1
+	if count := n.count(found, last); count > total {
+		total = count
+	}
+
+	if !n.firstOptional || found[0] != "" || first < 0 {
+		first = 0
+	}
+
+	set := &VolumeSet{Format: n.format}
+
+	for idx := first; idx < total; idx++ {
+		if found[idx] != "" {
+			set.add(found[idx])
+		} else {
+			set.add(filepath.Join(dir, base[:match[4]]+n.label(idx, like)+base[match[5]:]))
+		}
+	}
+
+	if len(set.Volumes) == 1 && len(set.Missing) == 0 {
+		return nil, nil //nolint:nilnil // one complete archive.
+	}
+
+	return set, set.err()
+}
+
+// add adds a volume to the set, or to the missing volumes if it does not exist or is empty.
+func (s *VolumeSet) add(path string) {
+	info, err := os.Stat(path)
+	if err == nil {
+		s.Volumes = append(s.Volumes, path)
+	}
+
+	if err != nil || info.Size() == 0 {
+		s.Missing = append(s.Missing, path)
+	}
+}
+
+// err returns an ErrMissingVolume that names the missing volumes, or nil if none are missing.
+func (s *VolumeSet) err() error {
+	if len(s.Missing) == 0 {
+		return nil
+	}
+
+	return &ArchiveError{
+		Kind: ErrMissingVolume,
+		Err:  fmt.Errorf("%s volume missing or empty: %s", s.Format, strings.Join(s.Missing, ", ")),
+	}
+}
+
+// volumeNumber turns a volume number that starts at 1 into an index.
+func volumeNumber(label string) int {
+	num, _ := strconv.Atoi(label)
+	return num - 1
+}
+
+// volumeLabel is a volume number with as many digits as like.
+func volumeLabel(idx int, like string) string {
+	return fmt.Sprintf("%0*d", len(like), idx+1)
+}
+
+// rarOldIndex returns the place of a volume in a set named name.rar, name.r00, name.r01, ….
+func rarOldIndex(label string) int {
+	if strings.EqualFold(label, "rar") {
+		return 0
+	}
+
+	num, _ := strconv.Atoi(label[1:])
+
+	return num + 1
+}
+
+// rarOldLabel is the suffix of a volume in a set named name.rar, name.r00, name.r01, ….
+func rarOldLabel(idx int, like string) string {
+	switch {
+	case idx > 0:
+		return fmt.Sprintf("%s%02d", like[:1], idx-1)
+	case like[0] == 'R':
+		return "RAR"
+	default:
+		return "rar"
+	}
+}
+
+// sevenZipCount returns how many volumes a 7z archive has. The start header in the first
+// volume has the size of the archive, and every volume but the last is the same size.
+func sevenZipCount(found map[int]string, _ int) int {
+	file, err := os.Open(found[0])
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	header := make([]byte, 32) //nolint:gomnd
+	if _, err := io.ReadFull(file, header); err != nil || string(header[:6]) != "7z\xbc\xaf\x27\x1c" {
+		return 0
+	}
+
+	volumeSize, err := fileSize(file)
+	if err != nil {
+		return 0
+	}
+
+	size := int64(len(header)) + int64(binary.LittleEndian.Uint64(header[12:])) +
+		int64(binary.LittleEndian.Uint64(header[20:]))
+	count := (size + volumeSize - 1) / volumeSize
+	if size <= 0 || count > maxVolumes {
+		return 0 // garbage.
+	}
+
+	return int(count)
+}
+
+// rarCount returns one more than the last volume found, if its end of archive header says
+// there is another volume.
+func rarCount(found map[int]string, last int) int {
+	if rarContinues(found[last]) {
+		return last + 2 //nolint:gomnd
+	}
+
+	return 0
+}
+
+// rarContinues returns true if a rar volume ends with a header that says another volume follows.
+func rarContinues(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer file.Close()
+
+	size, err := fileSize(file)
+	if err != nil || size < 8 { //nolint:gomnd
+		return false
+	}
+
+	tail := make([]byte, 16) //nolint:gomnd
+	if int64(len(tail)) > size {
+		tail = tail[:size]
+	}
+
+	if _, err := file.ReadAt(tail, size-int64(len(tail))); err != nil {
+		return false
+	}
+
+	end := len(tail)
+	// RAR 5: crc32, header size 3, type 5 (end of archive), flags 0, then the end of archive flags.
+	if tail[end-4] == 3 && tail[end-3] == 5 && tail[end-2] == 0 {
+		return tail[end-1]&1 != 0
+	}
+
+	// RAR 1.5 - 4: crc16, type 0x7b (end of archive), flags, header size, then an optional crc and volume number.
+	for headerSize := 7; headerSize <= 13 && headerSize <= end; headerSize++ {
+		header := tail[end-headerSize:]
+		if header[2] == 0x7b && int(binary.LittleEndian.Uint16(header[5:])) == headerSize {
+			return binary.LittleEndian.Uint16(header[3:])&1 != 0
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,102 @@
+package xtractr_test
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanVolumesRAR(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	data := []byte(strings.Repeat("multi-volume rar data. ", 100))
+	parts := []string{
+		filepath.Join(dir, "set.part1.rar"),
+		filepath.Join(dir, "set.part2.rar"),
+		filepath.Join(dir, "set.part3.rar"),
+		filepath.Join(dir, "set.part4.rar"),
+		filepath.Join(dir, "set.part5.rar"),
+	}
+
+	assert.NoError(t, makeRAR5Volumes(filepath.Join(dir, "set.part%d.rar"), "file.txt", data, 5))
+
+	set, err := xtractr.ScanVolumes(parts[2])
+	assert.NoError(t, err)
+	assert.Equal(t, &xtractr.VolumeSet{Format: xtractr.FormatRAR, Volumes: parts}, set)
+
+	// A gap in the middle, an empty volume, and the last volume says there is another.
+	assert.NoError(t, os.Remove(parts[1]))
+	assert.NoError(t, os.WriteFile(parts[2], nil, 0o600))
+	assert.NoError(t, os.Remove(parts[4]))
+
+	set, err = xtractr.ScanVolumes(parts[0])
+	assert.ErrorIs(t, err, xtractr.ErrMissingVolume)
+	assert.Equal(t, []string{parts[0], parts[2], parts[3]}, set.Volumes)
+	assert.Equal(t, []string{parts[1], parts[2], parts[4]}, set.Missing)
+
+	size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{FilePath: parts[0], OutputDir: filepath.Join(dir, "out")})
+	assert.ErrorIs(t, err, xtractr.ErrMissingVolume)
+	assert.Contains(t, err.Error(), parts[1], "the error names the gap")
+	assert.Equal(t, []string{parts[0], parts[2], parts[3]}, archives)
+	assert.Zero(t, size)
+	assert.Empty(t, files)
+	assert.NoDirExists(t, filepath.Join(dir, "out"), "nothing is extracted")
+}
+
+func TestScanVolumesRAROld(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	for _, name := range []string{"Show.RAR", "Show.R00", "Show.R02", "other.r01", "show.txt"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("data"), 0o600))
+	}
+
+	set, err := xtractr.ScanVolumes(filepath.Join(dir, "Show.RAR"))
+	assert.ErrorIs(t, err, xtractr.ErrMissingVolume)
+	assert.Equal(t, []string{filepath.Join(dir, "Show.R01")}, set.Missing)
+	assert.Len(t, set.Volumes, 3)
+
+	// Some sets start at .r00.
+	assert.NoError(t, os.Remove(filepath.Join(dir, "Show.RAR")))
+	assert.NoError(t, os.Rename(filepath.Join(dir, "Show.R02"), filepath.Join(dir, "Show.R01")))
+
+	set, err = xtractr.ScanVolumes(filepath.Join(dir, "Show.R00"))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "Show.R00"), filepath.Join(dir, "Show.R01")}, set.Volumes)
+
+	// One rar file is not a set.
+	set, err = xtractr.ScanVolumes(filepath.Join(dir, "other.rar"))
+	assert.NoError(t, err)
+	assert.Nil(t, set)
+}
+
+func TestScanVolumes7z(t *testing.T) {
+	t.Parallel()
+
+	// The start header says the archive is 250 bytes, so there are 3 volumes of 100 bytes.
+	dir := t.TempDir()
+	first := make([]byte, 100)
+	copy(first, "7z\xbc\xaf\x27\x1c\x00\x04")
+	binary.LittleEndian.PutUint64(first[12:], 200)
+	binary.LittleEndian.PutUint64(first[20:], 18)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "backup.7z.001"), first, 0o600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "backup.7z.002"), make([]byte, 100), 0o600))
+
+	set, err := xtractr.ScanVolumes(filepath.Join(dir, "backup.7z.001"))
+	assert.ErrorIs(t, err, xtractr.ErrMissingVolume)
+	assert.Equal(t, []string{filepath.Join(dir, "backup.7z.003")}, set.Missing)
+
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "backup.7z.003"), make([]byte, 50), 0o600))
+
+	set, err = xtractr.ScanVolumes(filepath.Join(dir, "backup.7z.001"))
+	assert.NoError(t, err)
+	assert.Len(t, set.Volumes, 3)
+	assert.Empty(t, set.Missing)
+}
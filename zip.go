@@ -1,25 +1,82 @@
 package xtractr
 
 import (
+	"bytes"
 	"crypto/md5"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"github.com/yeka/zip"
 	"io"
-	"log"
+	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
 	"strings"
-	"unicode"
+
+	"github.com/yeka/zip"
 )
 
 /* How to extract a ZIP file. */
 
 // ExtractZIP extracts a zip file.. to a destination. Simple enough.
+// Encrypted files (ZipCrypto and AES) are supported. Passwords are tried in the same
+// order as the other formats, until one works for every encrypted file in the archive.
 func ExtractZIP(xFile *XFile) (int64, []string, error) {
-	zipReader, err := zip.OpenReader(xFile.FilePath)
+	passwords := xFile.passwordList()
+	if len(passwords) == 1 {
+		return extractZIP(xFile)
+	}
+
+	limit := xFile.limiter() // shared by every attempt.
+
+	for idx, password := range passwords {
+		attempt := *xFile
+		attempt.Password, attempt.Passwords = password, nil
+
+		counted := limit.save()
+
+		size, files, err := extractZIP(&attempt)
+		if err == nil {
+			xFile.passwordWorked(password)
+			return size, files, nil
+		}
+
+		if !errors.Is(err, ErrWrongPassword) && !errors.Is(err, ErrEncrypted) {
+			return size, files, fmt.Errorf("used password %d of %d: %w", idx+1, len(passwords), err)
+		}
+
+		for _, file := range files {
+			os.Remove(file) // written with the wrong password, or before an encrypted file.
+		}
+
+		limit.restore(counted)
+
+		if idx == len(passwords)-1 {
+			return 0, nil, wrongPassword(err)
+		}
+	}
+
+	return 0, nil, nil // unreachable, passwordList always returns one item.
+}
+
+// extractZIPVolumes extracts a zip file, and returns every volume of a split zip as an archive.
+func extractZIPVolumes(xFile *XFile) (int64, []string, []string, error) {
+	volumes, err := zipVolumes(xFile.FilePath)
 	if err != nil {
-		return 0, nil, fmt.Errorf("zip.OpenReader: %w", err)
+		return 0, nil, []string{xFile.FilePath}, err
+	}
+
+	size, files, err := ExtractZIP(xFile)
+
+	return size, files, volumes, err
+}
+
+func extractZIP(xFile *XFile) (int64, []string, error) {
+	zipReader, err := openZIP(xFile)
+	if err != nil {
+		return 0, nil, err
 	}
 	defer zipReader.Close()
 
@@ -27,138 +84,494 @@ func ExtractZIP(xFile *XFile) (int64, []string, error) {
 	size := int64(0)
 
 	for _, zipFile := range zipReader.Reader.File {
-		fSize, err := xFile.unzip(zipFile)
+		wfile, fSize, err := xFile.unzip(zipFile)
 		if err != nil {
 			return size, files, fmt.Errorf("%s: %w", xFile.FilePath, err)
 		}
 
-		files = append(files, filepath.Join(xFile.OutputDir, zipFile.Name)) //nolint: gosec
-		size += fSize
+		if wfile != "" {
+			files = append(files, wfile)
+			size += fSize
+		}
 	}
 
 	return size, files, nil
 }
 
-func (x *XFile) unzip(zipFile *zip.File) (int64, error) { //nolint:dupl
-	wfile := x.clean(zipFile.Name)
-	if !strings.HasPrefix(wfile, x.OutputDir) {
-		// The file being written is trying to write outside of our base path. Malicious archive?
-		return 0, fmt.Errorf("%s: %w: %s (from: %s)", zipFile.FileInfo().Name(), ErrInvalidPath, wfile, zipFile.Name)
+// listZIP lists the contents of a zip file from its central directory.
+func listZIP(xFile *XFile) ([]Entry, error) {
+	zipReader, err := openZIP(xFile)
+	if err != nil {
+		return nil, err
+	}
+	defer zipReader.Close()
+
+	entries := make([]Entry, len(zipReader.File))
+	for idx, zipFile := range zipReader.File {
+		entries[idx] = newEntry(zipFile.Name, int64(zipFile.UncompressedSize64),
+			int64(zipFile.CompressedSize64), zipFile.Mode(), zipFile.ModTime())
+		entries[idx].Encrypted = zipFile.IsEncrypted()
+	}
+
+	return entries, nil
+}
+
+// unzip writes one file from a zip file. Returns the path written, or an empty string if it was skipped.
+func (x *XFile) unzip(zipFile *zip.File) (string, int64, error) { //nolint:dupl
+	wfile, err := x.outputPath(zipFile.Name)
+	if err != nil || wfile == "" {
+		return "", 0, err
 	}
 
 	if strings.HasSuffix(wfile, "/") || zipFile.FileInfo().IsDir() {
 		if err := os.MkdirAll(wfile, x.DirMode); err != nil {
-			return 0, fmt.Errorf("making zipFile dir: %w", err)
+			return "", 0, fmt.Errorf("making zipFile dir: %w", err)
 		}
 
-		return 0, nil
+		return wfile, 0, nil
+	}
+
+	if zipFile.IsEncrypted() {
+		zipFile.SetPassword(x.Password)
 	}
 
 	zFile, err := zipFile.Open()
 	if err != nil {
-		return 0, fmt.Errorf("zipFile.Open: %w", err)
+		return "", 0, zipPasswordError(zipFile, x.Password, fmt.Errorf("zipFile.Open: %w", err))
 	}
 	defer zFile.Close()
 
-	s, err := writeFile(wfile, zFile, x.FileMode, x.DirMode)
+	s, err := x.writeFile(wfile, zFile, x.FileMode, x.DirMode)
 	if err != nil {
-		return s, fmt.Errorf("%s: %w: %s (from: %s)", zipFile.FileInfo().Name(), err, wfile, zipFile.Name)
+		err = zipPasswordError(zipFile, x.Password, err)
+		if errors.Is(err, ErrWrongPassword) || errors.Is(err, ErrEncrypted) {
+			os.Remove(wfile) // garbage.
+		}
+
+		return wfile, s, fmt.Errorf("%s: %w: %s (from: %s)", zipFile.FileInfo().Name(), err, wfile, zipFile.Name)
 	}
 
-	return s, nil
+	return wfile, s, nil
 }
+
+// zipPasswordError returns ErrWrongPassword or ErrEncrypted for an encrypted file that fails
+// to decrypt. ZipCrypto only checks one byte of the password before the data is read, so a
+// wrong password is often only found by the CRC at the end of the file. AES has an auth code.
+func zipPasswordError(zipFile *zip.File, password string, err error) error {
+	if !zipFile.IsEncrypted() || isStopError(err) {
+		return err
+	}
+
+	switch kind := errorKind(err); {
+	case password == "" && kind != nil:
+		return &ArchiveError{Kind: ErrEncrypted, Err: err}
+	case kind == ErrWrongPassword, kind == ErrCorruptArchive, kind == ErrTruncated:
+		return &ArchiveError{Kind: ErrWrongPassword, Err: err}
+	default:
+		return err
+	}
+}
+
+// ExtractZipWithPassword extracts an encrypted zip file with xFile.Password.
+// Like it always has, it skips macOS metadata and writes portable names.
+// A NamePolicy set on xFile is used instead.
+//
+// Deprecated: ExtractZIP supports Password and Passwords, and ExtractFile uses it.
 func ExtractZipWithPassword(xFile *XFile) (int64, []string, error) {
-	// 打开ZIP文件
-	zipReader, err := zip.OpenReader(xFile.FilePath)
+	if xFile.NamePolicy != (NamePolicy{}) {
+		return ExtractZIP(xFile)
+	}
+
+	withPolicy := *xFile
+	withPolicy.NamePolicy = NamePolicy{SkipMacOS: true, Portable: true}
+
+	return ExtractZIP(&withPolicy)
+}
+
+// CalculateMD5 计算给定字符串的 MD5 哈希
+func CalculateMD5(text string) string {
+	hashes := md5.New()
+	hashes.Write([]byte(text))
+	md5Hash := hashes.Sum(nil)
+	return hex.EncodeToString(md5Hash)
+}
+
+/* Split and spanned zip files. */
+
+const (
+	zipEndMagic     = "PK\x05\x06"
+	zipEnd64Magic   = "PK\x06\x07" // zip64 end of central directory locator.
+	zipCentralMagic = "PK\x01\x02"
+	zipEndLen       = 22
+	zipEnd64Len     = 20
+	zipCentralLen   = 46
+	zipMaxComment   = 65535
+	zipMaxOffset    = 0xffffffff
+)
+
+// zipSplitPart is a volume before the last one in a spanned set: name.z01, name.z02, …, name.zip.
+var zipSplitPart = regexp.MustCompile(`\.z[0-9]{2,3}$`) //nolint:gochecknoglobals
+
+// zipReader is a zip file opened from one file, or from every volume of a split zip.
+type zipReader struct {
+	*zip.Reader
+	volumes []string
+	files   []*os.File
+}
+
+// zipEnd is the end of central directory record. Offsets are from the start of a volume.
+type zipEnd struct {
+	offset    int64  // where this record is.
+	disk      uint16 // the number of the volume with this record; the last one.
+	dirDisk   uint16 // the number of the volume where the central directory starts.
+	entries   uint16
+	dirSize   uint32
+	dirOffset uint32
+	comment   []byte
+	zip64     bool
+}
+
+// multiReaderAt reads several readers one after the other, as one.
+type multiReaderAt struct {
+	parts  []io.ReaderAt
+	starts []int64 // the offset of each part, and the total size last.
+}
+
+func newMultiReaderAt(parts []io.ReaderAt, sizes []int64) *multiReaderAt {
+	starts := make([]int64, len(sizes)+1)
+	for idx, size := range sizes {
+		starts[idx+1] = starts[idx] + size
+	}
+
+	return &multiReaderAt{parts: parts, starts: starts}
+}
+
+// Size returns the combined size of every part.
+func (m *multiReaderAt) Size() int64 {
+	return m.starts[len(m.starts)-1]
+}
+
+// ReadAt reads from every part that overlaps the requested range.
+func (m *multiReaderAt) ReadAt(data []byte, offset int64) (int, error) {
+	read := 0
+
+	for read < len(data) {
+		// The first part that ends after offset.
+		idx := sort.Search(len(m.parts), func(i int) bool { return m.starts[i+1] > offset })
+		if idx == len(m.parts) || offset < 0 {
+			return read, io.EOF
+		}
+
+		want := int64(len(data) - read)
+		if left := m.starts[idx+1] - offset; want > left {
+			want = left
+		}
+
+		count, err := m.parts[idx].ReadAt(data[read:read+int(want)], offset-m.starts[idx])
+		read += count
+		offset += int64(count)
+
+		if err != nil && !(errors.Is(err, io.EOF) && int64(count) == want) {
+			return read, err //nolint:wrapcheck
+		}
+	}
+
+	return read, nil
+}
+
+// openZIP opens a zip file. Every volume of a split or spanned zip is opened with it.
+// Names are decoded to UTF-8 with xFile.NameCharset.
+func openZIP(xFile *XFile) (*zipReader, error) {
+	filePath := xFile.FilePath
+
+	volumes, err := zipVolumes(filePath)
 	if err != nil {
-		return 0, nil, fmt.Errorf("failed to open zip file: %v", err)
+		return nil, err
 	}
-	defer zipReader.Close()
-	//跳过zip为空的文件
-	if len(zipReader.File) == 0 {
-		return 0, nil, fmt.Errorf("zip file is empty")
+
+	reader := &zipReader{volumes: volumes}
+	parts := make([]io.ReaderAt, len(volumes))
+	sizes := make([]int64, len(volumes))
+
+	for idx, volume := range volumes {
+		file, err := os.Open(volume)
+		if err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("os.Open: %w", volumeError(err))
+		}
+
+		reader.files = append(reader.files, file)
+		parts[idx] = file
+
+		if sizes[idx], err = fileSize(file); err != nil {
+			reader.Close()
+			return nil, err
+		}
 	}
-	files := []string{}
-	size := int64(0)
-	// 遍历ZIP文件中的每个文件/目录
-	for _, f := range zipReader.File {
-		if f.IsEncrypted() && xFile.Password == "" {
-			return 0, nil, fmt.Errorf("zip file is encrypted, please set password")
+
+	archive := newMultiReaderAt(parts, sizes)
+	if len(volumes) > 1 {
+		if archive, err = unspanZIP(archive); err != nil {
+			reader.Close()
+			return nil, fmt.Errorf("%s: %w", filePath, err)
 		}
-		// 为这个文件/目录设置密码
-		if xFile.Password != "" && f.IsEncrypted() {
-			f.SetPassword(xFile.Password)
+	}
+
+	if reader.Reader, err = newZIPReader(archive, archive.Size()); err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	if err = decodeZIPNames(reader.File, xFile.NameCharset); err != nil {
+		reader.Close()
+		return nil, fmt.Errorf("%s: %w", filePath, err)
+	}
+
+	return reader, nil
+}
+
+// newZIPReader reads a zip file from reader. Data before the zip file is skipped, like the
+// program in a self-extracting archive. Some programs change the zip file's offsets to start
+// at the program, and some do not, so the central directory's offset says which it is.
+func newZIPReader(reader io.ReaderAt, size int64) (*zip.Reader, error) {
+	base := int64(0)
+
+	end, err := readZIPEnd(reader, size)
+	if err == nil && end != nil && end.disk == 0 && !end.zip64 {
+		if base = end.offset - int64(end.dirSize) - int64(end.dirOffset); base < 0 {
+			base = 0
 		}
-		//跳过隐藏文件和隐藏文件夹
-		if strings.HasPrefix(f.Name, ".") || strings.Contains(f.Name, "__MACOSX") {
-			continue
+	}
+
+	zipReader, err := zip.NewReader(io.NewSectionReader(reader, base, size-base), size-base)
+	if err != nil {
+		return nil, fmt.Errorf("zip.NewReader: %w", err)
+	}
+
+	return zipReader, nil
+}
+
+// Close closes every volume.
+func (z *zipReader) Close() error {
+	var err error
+
+	for _, file := range z.files {
+		if closeErr := file.Close(); closeErr != nil {
+			err = closeErr
 		}
-		cleanedName := cleanFileName(f.Name)
-		fpath := filepath.Join(xFile.OutputDir, cleanedName)
+	}
 
-		if f.FileInfo().IsDir() {
-			os.MkdirAll(fpath, os.ModePerm)
-			continue
+	return err //nolint:wrapcheck
+}
+
+// fileSize returns the size of an open file.
+func fileSize(file *os.File) (int64, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("file.Stat: %w", err)
+	}
+
+	return info.Size(), nil
+}
+
+// zipVolumes returns the volumes of a zip file in order. This is one file, unless it is
+// part of a spanned set (name.z01, …, name.zip) or was cut into pieces (name.zip.001, …).
+// A missing or empty volume returns an ErrMissingVolume. See ScanVolumes.
+func zipVolumes(filePath string) ([]string, error) {
+	set, err := ScanVolumes(filePath)
+
+	switch {
+	case err != nil:
+		return nil, err
+	case set == nil:
+		return []string{filePath}, nil
+	default:
+		return set.Volumes, nil
+	}
+}
+
+// zipSpannedVolumes returns the volumes of a spanned zip: name.z01, name.z02, …, name.zip.
+// The end of central directory record in the last volume says how many volumes there are.
+// Returns nil if the zip is not spanned.
+func zipSpannedVolumes(filePath string) (*VolumeSet, error) {
+	if ext := filepath.Ext(filePath); zipSplitPart.MatchString(strings.ToLower(ext)) {
+		last := ".zip"
+		if ext[1] == 'Z' {
+			last = ".ZIP"
 		}
 
-		if err := os.MkdirAll(filepath.Dir(fpath), os.ModePerm); err != nil {
-			return 0, nil, fmt.Errorf("failed to create directory: %v", err)
+		filePath = strings.TrimSuffix(filePath, ext) + last
+	}
+
+	file, err := os.Open(filePath)
+	if errors.Is(err, fs.ErrNotExist) {
+		set := &VolumeSet{Format: FormatZIP, Missing: []string{filePath}}
+		return set, set.err()
+	} else if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	defer file.Close()
+
+	size, err := fileSize(file)
+	if err != nil {
+		return nil, err
+	}
+
+	end, err := readZIPEnd(file, size)
+	if err != nil || end == nil || end.disk == 0 || end.zip64 {
+		return nil, err // not spanned; or zip.NewReader explains what's wrong.
+	} else if end.disk >= maxVolumes {
+		return nil, fmt.Errorf("%s: %w: %d volumes", filePath, ErrInvalidHead, end.disk+1)
+	}
+
+	set := &VolumeSet{Format: FormatZIP}
+	base, ext := strings.TrimSuffix(filePath, filepath.Ext(filePath)), filepath.Ext(filePath)
+
+	for disk := 1; disk <= int(end.disk); disk++ {
+		set.add(fmt.Sprintf("%s%s%02d", base, ext[:2], disk))
+	}
+
+	set.add(filePath)
+
+	return set, set.err()
+}
+
+// zipPieceCount returns one more than the last piece found of a zip file that was cut into
+// pieces, if the zip file does not end in it. The end record may start in the piece before.
+func zipPieceCount(found map[int]string, last int) int {
+	parts, sizes := []io.ReaderAt{}, []int64{}
+
+	for _, volume := range []string{found[last-1], found[last]} {
+		if volume == "" {
+			continue
 		}
 
-		outFile, err := os.OpenFile(fpath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode())
+		file, err := os.Open(volume)
 		if err != nil {
-			if strings.Contains(err.Error(), "illegal byte sequence") {
-				// 记录错误，跳过当前文件
-				log.Printf("Warning: Skipping file %s due to illegal byte sequence error: %v\n", f.Name, err)
-				continue
-			} else if strings.Contains(err.Error(), "is a directory") {
-				// 记录错误，跳过当前文件
-				log.Printf("Warning: Skipping file %s due to is a directory error: %v\n", f.Name, err)
-				continue
-			}
-			return 0, nil, fmt.Errorf("failed to open file: %v", err)
-		}
-
-		rc, err := f.Open()
+			return 0
+		}
+		defer file.Close()
+
+		size, err := fileSize(file)
 		if err != nil {
-			outFile.Close()
-			return 0, nil, fmt.Errorf("failed to open file inside zip: %v", err)
+			return 0
 		}
 
-		s, err := io.Copy(outFile, rc)
-		size += s
-		files = append(files, filepath.Join(xFile.OutputDir, f.Name))
-		outFile.Close()
-		rc.Close()
+		parts, sizes = append(parts, file), append(sizes, size)
+	}
 
-		if err != nil {
-			return 0, nil, fmt.Errorf("password error: %v", err)
+	tail := newMultiReaderAt(parts, sizes)
+	if end, err := readZIPEnd(tail, tail.Size()); err == nil && end == nil {
+		return last + 2 //nolint:gomnd
+	}
+
+	return 0
+}
+
+// readZIPEnd finds the end of central directory record in the last 64KB of a zip file.
+// Returns nil if there is no record.
+func readZIPEnd(reader io.ReaderAt, size int64) (*zipEnd, error) {
+	search := int64(zipEndLen + zipMaxComment)
+	if search > size {
+		search = size
+	}
+
+	buf := make([]byte, search)
+	if _, err := reader.ReadAt(buf, size-search); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("reading zip end: %w", err)
+	}
+
+	for idx := len(buf) - zipEndLen; idx >= 0; idx-- {
+		record := buf[idx:]
+		if string(record[:4]) != zipEndMagic {
+			continue
 		}
+
+		commentLen := int(binary.LittleEndian.Uint16(record[20:]))
+		if zipEndLen+commentLen > len(record) {
+			continue // a comment that contains the magic.
+		}
+
+		return &zipEnd{
+			offset:    size - search + int64(idx),
+			disk:      binary.LittleEndian.Uint16(record[4:]),
+			dirDisk:   binary.LittleEndian.Uint16(record[6:]),
+			entries:   binary.LittleEndian.Uint16(record[10:]),
+			dirSize:   binary.LittleEndian.Uint32(record[12:]),
+			dirOffset: binary.LittleEndian.Uint32(record[16:]),
+			comment:   record[zipEndLen : zipEndLen+commentLen],
+			zip64:     idx >= zipEnd64Len && string(buf[idx-zipEnd64Len:idx-zipEnd64Len+4]) == zipEnd64Magic,
+		}, nil
 	}
 
-	return size, files, nil
+	return nil, nil //nolint:nilnil
 }
 
-// cleanFileName 清理文件名中的非法字符，使用 Unicode 码点表示替换非法字符
-func cleanFileName(name string) string {
-	cleaned := ""
-	for _, r := range name {
-		if unicode.IsPrint(r) && r < 127 {
-			cleaned += string(r)
-		} else {
-			cleaned += fmt.Sprintf("%X", r)
+// unspanZIP makes the volumes of a spanned zip readable as one zip file. Offsets in a spanned
+// zip's central directory are from the start of a volume, so the central directory is copied,
+// and its offsets are moved to the start of the first volume, like `zip -s 0` does.
+// Zip files cut into pieces are returned as they are.
+func unspanZIP(volumes *multiReaderAt) (*multiReaderAt, error) {
+	end, err := readZIPEnd(volumes, volumes.Size())
+	if err != nil || end == nil || end.disk == 0 {
+		return volumes, err
+	} else if end.zip64 {
+		return nil, fmt.Errorf("%w: spanned zip64", ErrUnsupportedMethod)
+	}
+
+	diskStart := func(disk uint16, offset uint32) (int64, error) {
+		if int(disk) >= len(volumes.parts) {
+			return 0, fmt.Errorf("%w: volume %d of %d", ErrInvalidHead, disk+1, len(volumes.parts))
+		} else if start := volumes.starts[disk] + int64(offset); start < zipMaxOffset {
+			return start, nil
 		}
+
+		return 0, fmt.Errorf("%w: spanned zip larger than 4GB", ErrUnsupportedMethod)
 	}
-	if len(cleaned) > 255 {
-		cleaned = CalculateMD5(cleaned)
+
+	dirStart, err := diskStart(end.dirDisk, end.dirOffset)
+	if err != nil {
+		return nil, err
 	}
-	return cleaned
-}
 
-// CalculateMD5 计算给定字符串的 MD5 哈希
-func CalculateMD5(text string) string {
-	hashes := md5.New()
-	hashes.Write([]byte(text))
-	md5Hash := hashes.Sum(nil)
-	return hex.EncodeToString(md5Hash)
+	dir := make([]byte, end.dirSize, int(end.dirSize)+zipEndLen+len(end.comment))
+	if _, err := volumes.ReadAt(dir, dirStart); err != nil {
+		return nil, fmt.Errorf("reading central directory: %w", err)
+	}
+
+	for record := dir; len(record) > 0; {
+		if len(record) < zipCentralLen || string(record[:4]) != zipCentralMagic {
+			return nil, fmt.Errorf("%w: central directory", ErrInvalidHead)
+		}
+
+		start, err := diskStart(binary.LittleEndian.Uint16(record[34:]), binary.LittleEndian.Uint32(record[42:]))
+		if err != nil {
+			return nil, err
+		}
+
+		binary.LittleEndian.PutUint16(record[34:], 0)
+		binary.LittleEndian.PutUint32(record[42:], uint32(start))
+
+		next := zipCentralLen + int(binary.LittleEndian.Uint16(record[28:])) +
+			int(binary.LittleEndian.Uint16(record[30:])) + int(binary.LittleEndian.Uint16(record[32:]))
+		if next > len(record) {
+			return nil, fmt.Errorf("%w: central directory", ErrInvalidHead)
+		}
+
+		record = record[next:]
+	}
+
+	record := make([]byte, zipEndLen)
+	copy(record, zipEndMagic)
+	binary.LittleEndian.PutUint16(record[8:], end.entries)
+	binary.LittleEndian.PutUint16(record[10:], end.entries)
+	binary.LittleEndian.PutUint32(record[12:], end.dirSize)
+	binary.LittleEndian.PutUint32(record[16:], uint32(dirStart))
+	binary.LittleEndian.PutUint16(record[20:], uint16(len(end.comment)))
+	tail := append(append(dir, record...), end.comment...)
+
+	return newMultiReaderAt(
+		[]io.ReaderAt{io.NewSectionReader(volumes, 0, dirStart), bytes.NewReader(tail)},
+		[]int64{dirStart, int64(len(tail))},
+	), nil
 }
@@ -0,0 +1,249 @@
+package xtractr_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fmzchao/xtractr"
+	"github.com/stretchr/testify/assert"
+	"github.com/yeka/zip"
+)
+
+func TestExtractZIPPassword(t *testing.T) {
+	t.Parallel()
+
+	methods := map[string]zip.EncryptionMethod{
+		"zipcrypto": zip.StandardEncryption,
+		"aes128":    zip.AES128Encryption,
+		"aes256":    zip.AES256Encryption,
+	}
+
+	for name, method := range methods {
+		dir := t.TempDir()
+		archive := filepath.Join(dir, name+".zip")
+		assert.NoError(t, makeEncryptedZip(archive, "secret", method), name)
+
+		xFile := &xtractr.XFile{
+			FilePath:  archive,
+			OutputDir: filepath.Join(dir, "out"),
+			FileMode:  xtractr.DefaultFileMode,
+			DirMode:   xtractr.DefaultDirMode,
+		}
+
+		_, _, _, err := xtractr.ExtractFile(xFile)
+		assert.ErrorIs(t, err, xtractr.ErrEncrypted, name)
+
+		xFile.Passwords = []string{"wrong", "also wrong"}
+		_, _, _, err = xtractr.ExtractFile(xFile)
+		assert.ErrorIs(t, err, xtractr.ErrWrongPassword, name)
+		assert.NoFileExists(t, filepath.Join(xFile.OutputDir, "secret.txt"), "files with the wrong password are removed")
+
+		xFile.Password = "secret"
+		size, files, _, err := xtractr.ExtractFile(xFile)
+		assert.NoError(t, err, name)
+		assert.Equal(t, int64(len(name)*100), size, name)
+		assert.Equal(t, 2, len(files), name)
+
+		data, err := os.ReadFile(filepath.Join(xFile.OutputDir, "secret.txt"))
+		assert.NoError(t, err, name)
+		assert.Equal(t, len(name)*100, len(data), name)
+	}
+}
+
+func TestExtractZIPSplit(t *testing.T) {
+	t.Parallel()
+
+	for _, spanned := range []bool{true, false} {
+		dir := t.TempDir()
+		volumes, err := makeSplitZip(dir, "movie", 3, spanned)
+		assert.NoError(t, err)
+
+		// The .zip is last in a spanned set, and first in a numbered set.
+		first := volumes[0]
+		if spanned {
+			first = volumes[len(volumes)-1]
+		}
+
+		found := xtractr.FindCompressedFiles(xtractr.Filter{Path: dir, DetectByContent: true})
+		assert.Equal(t, []string{first}, found[dir], "only one volume of a set is an archive")
+
+		entries, err := xtractr.ListFile(&xtractr.XFile{FilePath: first})
+		assert.NoError(t, err)
+		assert.Len(t, entries, len(testZipFiles()))
+
+		_, archives, err := xtractr.TestFile(&xtractr.XFile{FilePath: first})
+		assert.NoError(t, err)
+		assert.Equal(t, volumes, archives)
+
+		size, files, archives, err := xtractr.ExtractFile(&xtractr.XFile{
+			FilePath:  first,
+			OutputDir: filepath.Join(dir, "out"),
+			FileMode:  xtractr.DefaultFileMode,
+			DirMode:   xtractr.DefaultDirMode,
+		})
+		assert.NoError(t, err, spanned)
+		assert.Equal(t, volumes, archives, "every volume is returned so it can be deleted")
+		assert.Len(t, files, len(testZipFiles()))
+
+		total := int64(0)
+		for name, data := range testZipFiles() {
+			written, err := os.ReadFile(filepath.Join(dir, "out", name))
+			assert.NoError(t, err)
+			assert.Equal(t, data, written, name)
+
+			total += int64(len(data))
+		}
+
+		assert.Equal(t, total, size)
+
+		// A spanned set's .zip says how many volumes there are. A numbered set must end with the zip.
+		missing := volumes[1]
+		if !spanned {
+			missing = volumes[len(volumes)-1]
+		}
+
+		assert.NoError(t, os.Remove(missing))
+
+		_, _, _, err = xtractr.ExtractFile(&xtractr.XFile{FilePath: first, OutputDir: filepath.Join(dir, "out2")})
+		assert.ErrorIs(t, err, xtractr.ErrMissingVolume, spanned)
+	}
+}
+
+func testZipFiles() map[string][]byte {
+	return map[string][]byte{
+		"movie.mkv":      bytes.Repeat([]byte("\x1aE\xdf\xa3 matroska frame "), 3000),
+		"subs/movie.srt": []byte(strings.Repeat("1\n00:00:01,000 --> 00:00:02,000\nHello\n\n", 50)),
+		"movie.nfo":      []byte("release notes"),
+	}
+}
+
+// makeSplitZip writes testZipFiles to a zip file in volumes. A spanned set is written like
+// `zip -s`: name.z01, name.z02, …, name.zip, with offsets from the start of each volume.
+// Otherwise the zip file is cut into name.zip.001, name.zip.002, ….
+func makeSplitZip(dir, name string, volumes int, spanned bool) ([]string, error) {
+	buf := &bytes.Buffer{}
+	zipWriter := zip.NewWriter(buf)
+
+	for fileName, data := range testZipFiles() {
+		writer, err := zipWriter.Create(fileName)
+		if err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		_, _ = writer.Write(data)
+	}
+
+	if err := zipWriter.Close(); err != nil {
+		return nil, err //nolint:wrapcheck
+	}
+
+	data := buf.Bytes()
+	paths := []string{}
+
+	if !spanned {
+		for vol := 0; vol < volumes; vol++ {
+			path := filepath.Join(dir, fmt.Sprintf("%s.zip.%03d", name, vol+1))
+			paths = append(paths, path)
+
+			if err := os.WriteFile(path, data[len(data)*vol/volumes:len(data)*(vol+1)/volumes], 0o600); err != nil {
+				return nil, err //nolint:wrapcheck
+			}
+		}
+
+		return paths, nil
+	}
+
+	end := bytes.LastIndex(data, []byte("PK\x05\x06"))
+	dirStart := int(binary.LittleEndian.Uint32(data[end+16:]))
+	// A spanned set starts with a marker, and the central directory is in the last volume.
+	files := append([]byte("PK\x07\x08"), data[:dirStart]...)
+	cuts := []int{}
+
+	for vol := 0; vol < volumes; vol++ {
+		cuts = append(cuts, len(files)*vol/volumes)
+	}
+
+	diskOf := func(offset int) (uint16, uint32) {
+		disk := 0
+		for disk < volumes-1 && cuts[disk+1] <= offset {
+			disk++
+		}
+
+		return uint16(disk), uint32(offset - cuts[disk])
+	}
+
+	central := append([]byte{}, data[dirStart:]...)
+	for record := central; bytes.HasPrefix(record, []byte("PK\x01\x02")); {
+		disk, offset := diskOf(int(binary.LittleEndian.Uint32(record[42:])) + 4)
+		binary.LittleEndian.PutUint16(record[34:], disk)
+		binary.LittleEndian.PutUint32(record[42:], offset)
+		record = record[46+int(binary.LittleEndian.Uint16(record[28:]))+
+			int(binary.LittleEndian.Uint16(record[30:]))+int(binary.LittleEndian.Uint16(record[32:])):]
+	}
+
+	record := central[end-dirStart:]
+	binary.LittleEndian.PutUint16(record[4:], uint16(volumes-1))
+	binary.LittleEndian.PutUint16(record[6:], uint16(volumes-1))
+	binary.LittleEndian.PutUint16(record[8:], binary.LittleEndian.Uint16(record[10:]))
+	binary.LittleEndian.PutUint32(record[16:], uint32(len(files)-cuts[volumes-1]))
+
+	for vol := 0; vol < volumes; vol++ {
+		path := filepath.Join(dir, fmt.Sprintf("%s.z%02d", name, vol+1))
+		volume := files[cuts[vol]:]
+
+		if vol < volumes-1 {
+			volume = files[cuts[vol]:cuts[vol+1]]
+		} else {
+			path = filepath.Join(dir, name+".zip")
+			volume = append(volume, central...)
+		}
+
+		if err := os.WriteFile(path, volume, 0o600); err != nil {
+			return nil, err //nolint:wrapcheck
+		}
+
+		paths = append(paths, path)
+	}
+
+	return paths, nil
+}
+
+// makeEncryptedZip writes a zip file with one plain and one encrypted file.
+// The encrypted file is the name of the file repeated 100 times.
+func makeEncryptedZip(fileName, password string, method zip.EncryptionMethod) error {
+	file, err := os.Create(fileName)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+	defer file.Close()
+
+	zipWriter := zip.NewWriter(file)
+
+	plain, err := zipWriter.Create("plain.txt")
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	if _, err = plain.Write(nil); err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	secret, err := zipWriter.Encrypt("secret.txt", password, method)
+	if err != nil {
+		return err //nolint:wrapcheck
+	}
+
+	name := filepath.Base(fileName[:len(fileName)-len(filepath.Ext(fileName))])
+	for i := 0; i < 100; i++ {
+		if _, err = secret.Write([]byte(name)); err != nil {
+			return err //nolint:wrapcheck
+		}
+	}
+
+	return zipWriter.Close() //nolint:wrapcheck
+}